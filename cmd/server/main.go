@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"htmx-blog/handlers"
 	"htmx-blog/handlers/mangaHandler"
 	"htmx-blog/handlers/markdownHandler"
 	log "htmx-blog/logging"
+	"htmx-blog/metrics"
 	"htmx-blog/services/cache"
 	"htmx-blog/services/content"
 	"htmx-blog/services/manga"
@@ -12,16 +14,21 @@ import (
 	"htmx-blog/services/notion/imageenc"
 	"htmx-blog/services/strava"
 	"htmx-blog/services/visitors"
+	"htmx-blog/tracing"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
 )
 
-// immutableImageCache wraps a handler and sets a long-lived, immutable
-// Cache-Control for responses under /images/. Safe because IDs are
-// content-addressed (Notion block ID) and never reused.
-func immutableImageCache(h http.Handler) http.Handler {
+// imageCache wraps a handler serving /images/ files and sets their
+// Cache-Control: immutable for content digests, short-lived for files still
+// named by block ID (see notion.ImageCacheControl).
+func imageCache(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("Cache-Control", notion.ImageCacheControl(path.Base(r.URL.Path)))
 		h.ServeHTTP(w, r)
 	})
 }
@@ -33,14 +40,33 @@ func main() {
 	staticFs := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/", http.StripPrefix("/static/", staticFs))
 	if !imageenc.Available() {
-		log.Error("cwebp binary not on PATH; new Notion images will fall back to their original format until it's installed (apt install webp)")
+		log.Error("cwebp binary not on PATH; new Notion images are served as pure-Go JPEG/PNG variants until it's installed (apt install webp)")
+	}
+	if !imageenc.AVIF.Available() {
+		log.Info("avifenc not on PATH; skipping AVIF copies (apt install libavif-bin, then run the image backfill)")
+	}
+
+	imageStore, err := notion.ImageStoreFromEnv()
+	if err != nil {
+		log.Fatal("image store: %v", err)
+	}
+	notion.SetImageStore(imageStore)
+	log.Info("publishing images to %s", imageStore)
+
+	shutdownTracing, err := tracing.SetupFromEnv()
+	if err != nil {
+		log.Fatal("tracing: %v", err)
 	}
 
+	var imageFiles http.Handler
 	_, exists := os.LookupEnv("PROD")
 	if !exists {
-		imagesFs := http.StripPrefix("/images/", http.FileServer(http.Dir("./images")))
-		mux.Handle("/images/", immutableImageCache(imagesFs))
+		imageFiles = imageCache(http.StripPrefix("/images/", http.FileServer(http.Dir("./images"))))
+		mux.Handle("/images/", imageFiles)
 	}
+	// Resized/re-encoded copies on demand; file names fall through to the
+	// file server above.
+	mux.HandleFunc("GET /images/{id}", handlers.ImageTransformHandler(imageFiles))
 	handler := markdownHandler.NewHandler()
 	stravaClient := strava.NewStravaService()
 	mangaService := manga.NewMangaService()
@@ -50,9 +76,9 @@ func main() {
 	cacheService := cache.NewCache(contentSource)
 	blockRenderer := notion.NewBlockRenderer()
 	pageRenderer := content.NewPageRenderer(cacheService, blockRenderer)
-	visitorTracker := visitors.NewTracker("")
+	visitorTracker := visitors.NewTracker("", visitors.Options{})
 
-	blogPostHandler := handlers.NewBlogPostHandler(cacheService, pageRenderer)
+	blogPostHandler := handlers.NewBlogPostHandler(cacheService, pageRenderer, visitorTracker)
 	readingNowHandler := handlers.NewReadingNowHandler(cacheService)
 	stravaHandler := handlers.NewStravaHandler(stravaClient)
 
@@ -61,8 +87,11 @@ func main() {
 	mux.HandleFunc("GET /reviews/{slug}", handler.GetReviewByTitle())
 	mux.HandleFunc("GET /blogposts", handler.GetBlogList())
 	mux.HandleFunc("GET /notion/{filter}", blogPostHandler.ListPosts())
+	mux.HandleFunc("GET /notion/popular", blogPostHandler.PopularPosts())
 	mux.HandleFunc("GET /notion/posts/{slug}", blogPostHandler.GetPostPage())
 	mux.HandleFunc("GET /notion/content/{slug}", blogPostHandler.GetPostContent())
+	mux.HandleFunc("GET /archive", blogPostHandler.Archive())
+	mux.HandleFunc("GET /archive/{year}", blogPostHandler.Archive())
 	mangaH := mangaHandler.NewHandler()
 	mux.HandleFunc("GET /strava", stravaHandler.GetStravaHandler())
 	mux.HandleFunc("GET /manga", mangaH.GetMangaPage())
@@ -74,9 +103,19 @@ func main() {
 	internalMux.HandleFunc("GET /cron/refresh-strava", stravaHandler.RefreshAccessToken())
 	internalMux.HandleFunc("GET /cron/refresh-manga", mangaH.UpdateMangaData())
 	internalMux.HandleFunc("POST /cron/backfill-images", handlers.ImageBackfillHandler())
+	internalMux.HandleFunc("GET /cron/backfill-images", handlers.ImageBackfillStatusHandler())
+	internalMux.HandleFunc("DELETE /cron/backfill-images", handlers.ImageBackfillCancelHandler())
+	internalMux.HandleFunc("GET /cron/audit-images", handlers.ImageAuditHandler())
+	internalMux.HandleFunc("POST /cron/audit-images", handlers.ImageAuditHandler())
+	internalMux.Handle("GET /metrics", metrics.Handler())
+	internalMux.HandleFunc("GET /log/level", log.LevelHandler())
+	internalMux.HandleFunc("PUT /log/level", log.LevelHandler())
 	internalMux.HandleFunc("GET /stats/visitors", visitorTracker.StatsHandler())
+	internalMux.HandleFunc("GET /stats/visitors/dashboard", visitorTracker.DashboardHandler())
+	// The dashboard renders with the site layout, which loads htmx and the stylesheet.
+	internalMux.Handle("/static/", http.StripPrefix("/static/", staticFs))
 	// refresh strava token on init always in prod
-	err := mangaService.UpdateMangaData()
+	err = mangaService.UpdateMangaData()
 	if err != nil {
 		log.Error("error updating manga data: %v", err)
 	}
@@ -86,20 +125,42 @@ func main() {
 			log.Error("error refreshing strava token: %v", err)
 		}
 	}
-	go runInternalServer(internalMux)
+	// Pick up a backfill the last process didn't get to finish.
+	if job, err := notion.ResumeBackfill(); err != nil {
+		log.Error("error resuming image backfill: %v", err)
+	} else if job != nil {
+		log.Info("resumed interrupted image backfill %s", job.Status().ID)
+	}
+	go runInternalServer(log.Middleware(metrics.InstrumentMux("internal", internalMux)))
+	// Visitor counts and spans are held in memory between flushes; write
+	// them out before exiting.
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		if err := visitorTracker.Close(); err != nil {
+			log.Error("error flushing visitor stats: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error("error flushing traces: %v", err)
+		}
+		cancel()
+		os.Exit(0)
+	}()
 	localAddress := "localhost:3000"
 	if os.Getenv("PROD") == "true" {
 		localAddress = os.Getenv("PROD_ADDRESS")
 	}
 	log.Info("server started on %s", localAddress)
-	if err := http.ListenAndServe(localAddress, visitorTracker.Middleware(mux)); err != nil {
+	if err := http.ListenAndServe(localAddress, log.Middleware(tracing.Middleware(mux, visitorTracker.Middleware(metrics.InstrumentMux("public", mux))))); err != nil {
 		log.Fatal("server died: %v", err)
 	}
 }
 
-func runInternalServer(internalMux *http.ServeMux) {
+func runInternalServer(internalHandler http.Handler) {
 	log.Info("Starting internal API server on 127.0.0.1:8081")
-	if err := http.ListenAndServe("127.0.0.1:8081", internalMux); err != nil {
+	if err := http.ListenAndServe("127.0.0.1:8081", internalHandler); err != nil {
 		log.Error(err.Error())
 	}
 }
@@ -2,13 +2,20 @@ package handlers
 
 import (
 	"errors"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 
 	log "htmx-blog/logging"
 	"htmx-blog/services/cache"
 	"htmx-blog/services/content"
+	"htmx-blog/services/visitors"
+	"htmx-blog/tracing"
 	"htmx-blog/utils"
 )
 
@@ -53,16 +60,36 @@ func buildPageList(current, totalPages int) []int {
 	return out
 }
 
+// section is a post list, served at /notion/{Filter}.
+type section struct {
+	Filter string
+	Title  string
+}
+
+// sections are the site's post lists. The archive and popular posts merge
+// all of them.
+var sections = []section{
+	{Filter: "book-reviews", Title: "Book Reviews"},
+	{Filter: "engineering", Title: "Coding"},
+	{Filter: "travel", Title: "Travel"},
+	{Filter: "speaking", Title: "Speaking"},
+}
+
+// sectionFilters returns the filter slug of every section.
+func sectionFilters() []string {
+	filters := make([]string, len(sections))
+	for i, s := range sections {
+		filters[i] = s.Filter
+	}
+	return filters
+}
+
 // sectionTitle converts a URL filter slug into a human-readable heading.
 func sectionTitle(filter string) string {
-	titles := map[string]string{
-		"book-reviews": "Book Reviews",
-		"engineering":  "Coding",
-		"travel":       "Travel",
-		"speaking":     "Speaking",
-	}
-	if t, ok := titles[filter]; ok {
-		return t
+	for _, s := range sections {
+		if s.Filter == filter {
+			return s.Title
+		}
 	}
 	// Fallback: capitalize and replace hyphens
 	if filter == "" {
@@ -74,15 +101,19 @@ func sectionTitle(filter string) string {
 type BlogPostHandler struct {
 	cache         cache.Cache
 	pageRenderer content.PageRenderer
+	pageCounter  visitors.PageCounter
 }
 
 // NewBlogPostHandler creates a handler that uses cache for list views and
 // pageRenderer for rendering single posts. Both are backed by the content
 // abstraction, so the data source (Notion, Markdown, etc.) can be swapped.
-func NewBlogPostHandler(cache cache.Cache, pageRenderer content.PageRenderer) *BlogPostHandler {
+// pageCounter supplies view counts for the popular posts partial; it may be
+// nil, in which case the partial renders nothing.
+func NewBlogPostHandler(cache cache.Cache, pageRenderer content.PageRenderer, pageCounter visitors.PageCounter) *BlogPostHandler {
 	return &BlogPostHandler{
 		cache:         cache,
 		pageRenderer: pageRenderer,
+		pageCounter:  pageCounter,
 	}
 }
 
@@ -99,17 +130,58 @@ type Pagination struct {
 	NextPage   int
 	Filter     string
 	Pages      []int
+	// Sort, From and To echo the list query so page links keep them.
+	Sort string
+	From string
+	To   string
+}
+
+const (
+	// popularPostsDays is the window the popular posts partial counts over.
+	popularPostsDays  = 30
+	popularPostsLimit = 5
+)
+
+// PopularPost is a post entry with its view counts over popularPostsDays.
+type PopularPost struct {
+	content.PostEntry
+	Views    int
+	Visitors int
+}
+
+// dateParamLayout is the format of the from/to query parameters.
+const dateParamLayout = "2006-01-02"
+
+// parseDateRange reads ?from= and ?to= (YYYY-MM-DD in the site timezone, both
+// inclusive). Invalid
+// values are ignored and returned as "" so links don't echo them back.
+func parseDateRange(r *http.Request) (from, to time.Time, fromParam, toParam string) {
+	if v := r.URL.Query().Get("from"); v != "" {
+		if t, err := time.ParseInLocation(dateParamLayout, v, utils.SiteLocation()); err == nil {
+			from, fromParam = t, v
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if t, err := time.ParseInLocation(dateParamLayout, v, utils.SiteLocation()); err == nil {
+			to, toParam = t.AddDate(0, 0, 1).Add(-time.Nanosecond), v
+		}
+	}
+	return from, to, fromParam, toParam
 }
 
 // ListPosts returns a handler that renders the list of posts for the given filter with pagination.
 func (h *BlogPostHandler) ListPosts() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		filter := r.PathValue("filter")
+		ctx, span := tracing.Start(r.Context(), "BlogPostHandler.ListPosts", attribute.String("post.filter", filter))
+		defer span.End()
+		r = r.WithContext(ctx)
 		collectionID := h.cache.GetSource().GetDefaultCollectionID()
 
 		postEntries, err := h.cache.GetPostEntries(r.Context(), collectionID, filter)
 		if err != nil {
-			log.Error("error getting post entries: %v", err)
+			tracing.RecordError(span, err)
+			log.ErrorContext(r.Context(), "getting post entries", "filter", filter, "err", err)
 			w.Write([]byte("error getting post entries"))
 			return
 		}
@@ -120,6 +192,11 @@ func (h *BlogPostHandler) ListPosts() http.HandlerFunc {
 			}
 		}
 
+		sortOrder := content.ParseSortOrder(r.URL.Query().Get("sort"))
+		from, to, fromParam, toParam := parseDateRange(r)
+		postEntries = content.FilterPostsByDate(postEntries, from, to)
+		postEntries = content.SortPosts(postEntries, sortOrder)
+
 		page := 1
 		if p := r.URL.Query().Get("page"); p != "" {
 			if n, err := strconv.Atoi(p); err == nil && n > 0 {
@@ -169,6 +246,11 @@ func (h *BlogPostHandler) ListPosts() http.HandlerFunc {
 			NextPage:   page + 1,
 			Filter:     filter,
 			Pages:      buildPageList(page, totalPages),
+			From:       fromParam,
+			To:         toParam,
+		}
+		if sortOrder != content.SortNewest {
+			pagination.Sort = string(sortOrder)
 		}
 
 		utils.Render(w, map[string]interface{}{
@@ -185,32 +267,71 @@ func (h *BlogPostHandler) GetPostPage() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		subtitle := r.PathValue("slug")
 		postType := r.URL.Query().Get("type")
+		ctx, span := tracing.Start(r.Context(), "BlogPostHandler.GetPostPage", attribute.String("post.slug", subtitle), attribute.String("post.type", postType))
+		defer span.End()
+		r = r.WithContext(ctx)
 
 		collectionID := h.cache.GetSource().GetDefaultCollectionID()
-		_, err := h.cache.GetBlockIDBySlug(r.Context(), collectionID, subtitle, postType)
+		blockID, err := h.cache.GetBlockIDBySlug(r.Context(), collectionID, subtitle, postType)
 		if err != nil {
 			if errors.Is(err, cache.ErrSlugNotFound) {
 				w.WriteHeader(http.StatusNotFound)
 				utils.Render(w, nil, "./templates/pages/not-found.html")
 				return
 			}
-			log.Error("error resolving slug for post page: %v", err)
+			tracing.RecordError(span, err)
+			log.ErrorContext(r.Context(), "resolving slug for post page", "slug", subtitle, "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("error loading post"))
 			return
 		}
 
-		utils.Render(w, map[string]interface{}{"Slug": subtitle, "PostType": postType}, "./templates/pages/notion-post.html")
+		// Forward render options to the htmx content request.
+		contentQuery := url.Values{}
+		if postType != "" {
+			contentQuery.Set("type", postType)
+		}
+		if toc := content.ParseTOCPlacement(r.URL.Query().Get("toc")); toc != content.TOCNone {
+			contentQuery.Set("toc", string(toc))
+		}
+		var contentQueryString string
+		if len(contentQuery) > 0 {
+			contentQueryString = "?" + contentQuery.Encode()
+		}
+
+		data := map[string]interface{}{
+			"Slug":         subtitle,
+			"PostType":     postType,
+			"ContentQuery": contentQueryString,
+		}
+		// Meta tags come from the list entry; the slug lookup above just
+		// succeeded, so this is a cache read.
+		if postEntries, err := h.cache.GetPostEntries(r.Context(), collectionID, postType); err == nil {
+			for _, entry := range postEntries {
+				if entry.ID == blockID {
+					data["MetaTitle"] = entry.Title
+					data["MetaDescription"] = entry.Excerpt
+					data["ReadingMinutes"] = entry.ReadingMinutes
+					break
+				}
+			}
+		}
+
+		utils.Render(w, data, "./templates/pages/notion-post.html")
 	}
 }
 
 // GetPostContent returns a handler that renders a single post's content (used by htmx to swap
 // into the post page). The URL segment is the post subtitle (slug); it is resolved to a block ID
 // via the cache. Uses the content PageRenderer interface, so the backend is interchangeable.
+// An optional ?toc=top|sidebar query adds a generated table of contents.
 func (h *BlogPostHandler) GetPostContent() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		slug := r.PathValue("slug")
 		postType := r.URL.Query().Get("type")
+		ctx, span := tracing.Start(r.Context(), "BlogPostHandler.GetPostContent", attribute.String("post.slug", slug), attribute.String("post.type", postType))
+		defer span.End()
+		r = r.WithContext(ctx)
 
 		collectionID := h.cache.GetSource().GetDefaultCollectionID()
 		blockID, err := h.cache.GetBlockIDBySlug(r.Context(), collectionID, slug, postType)
@@ -220,18 +341,216 @@ func (h *BlogPostHandler) GetPostContent() http.HandlerFunc {
 				w.Write([]byte("post not found"))
 				return
 			}
-			log.Error("error resolving slug: %v", err)
+			tracing.RecordError(span, err)
+			log.ErrorContext(r.Context(), "resolving slug", "slug", slug, "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("error loading post"))
 			return
 		}
 
-		err = h.pageRenderer.RenderPage(r.Context(), w, blockID, content.RenderOptions{PostType: postType})
+		err = h.pageRenderer.RenderPage(r.Context(), w, blockID, content.RenderOptions{
+			PostType: postType,
+			TOC:      content.ParseTOCPlacement(r.URL.Query().Get("toc")),
+		})
 		if err != nil {
-			log.Error("error rendering post: %v", err)
+			tracing.RecordError(span, err)
+			log.ErrorContext(r.Context(), "rendering post", "slug", slug, "block", blockID, "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("error rendering post"))
 			return
 		}
+
+		h.renderPostNav(r, w, collectionID, blockID, postType)
+	}
+}
+
+// renderPostNav appends previous/next and related-post links beneath a post's
+// content. Entries come from the same filtered, ordered list the post was
+// resolved from. Failures are logged only: the post itself has already been
+// written, so a missing nav block is better than an error mid-response.
+func (h *BlogPostHandler) renderPostNav(r *http.Request, w io.Writer, collectionID, blockID, postType string) {
+	ctx, span := tracing.Start(r.Context(), "BlogPostHandler.renderPostNav")
+	defer span.End()
+
+	postEntries, err := h.cache.GetPostEntries(ctx, collectionID, postType)
+	if err != nil {
+		tracing.RecordError(span, err)
+		log.ErrorContext(ctx, "getting post entries for post nav", "filter", postType, "err", err)
+		return
+	}
+	if postType != "" {
+		for i := range postEntries {
+			postEntries[i].PostType = postType
+		}
+	}
+
+	var updated time.Time
+	for _, entry := range postEntries {
+		if entry.ID == blockID {
+			updated = postUpdated(entry)
+			break
+		}
+	}
+
+	nav := content.BuildNavigation(postEntries, blockID, content.DefaultRelatedLimit)
+	err = utils.RenderPartial(w, "postNav", map[string]interface{}{
+		"Nav":      nav,
+		"PostType": postType,
+		"Updated":  updated,
+	}, "./templates/partials/post-nav.html", "./templates/partials/post-entry.html")
+	if err != nil {
+		tracing.RecordError(span, err)
+		log.ErrorContext(ctx, "rendering post nav", "block", blockID, "err", err)
+	}
+}
+
+// postUpdated is when entry was last edited, or zero if that was on the day
+// it was published: edits made while publishing aren't worth a note.
+func postUpdated(entry content.PostEntry) time.Time {
+	if entry.UpdatedTime.IsZero() || entry.CreatedTime.IsZero() {
+		return time.Time{}
+	}
+	loc := utils.SiteLocation()
+	cy, cm, cd := entry.CreatedTime.In(loc).Date()
+	uy, um, ud := entry.UpdatedTime.In(loc).Date()
+	if !time.Date(uy, um, ud, 0, 0, 0, 0, loc).After(time.Date(cy, cm, cd, 0, 0, 0, 0, loc)) {
+		return time.Time{}
+	}
+	return entry.UpdatedTime
+}
+
+// Archive returns a handler for /archive and /archive/{year}: every published
+// post across the archive filters, grouped by year and month. An optional
+// ?type= narrows it to one filter. Unknown or empty years render the 404 page.
+func (h *BlogPostHandler) Archive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), "BlogPostHandler.Archive")
+		defer span.End()
+		r = r.WithContext(ctx)
+		collectionID := h.cache.GetSource().GetDefaultCollectionID()
+
+		filters := sectionFilters()
+		postType := r.URL.Query().Get("type")
+		if postType != "" {
+			filters = []string{postType}
+		}
+
+		postEntries := h.mergedPostEntries(r, collectionID, filters)
+		years := content.GroupByMonth(postEntries, utils.SiteLocation())
+		allYears := make([]int, len(years))
+		for i, y := range years {
+			allYears[i] = y.Year
+		}
+
+		selectedYear := 0
+		if yearParam := r.PathValue("year"); yearParam != "" {
+			year, _ := strconv.Atoi(yearParam)
+			var match []content.ArchiveYear
+			for _, y := range years {
+				if y.Year == year {
+					match = []content.ArchiveYear{y}
+					break
+				}
+			}
+			if match == nil {
+				w.WriteHeader(http.StatusNotFound)
+				utils.Render(w, nil, "./templates/pages/not-found.html")
+				return
+			}
+			years = match
+			selectedYear = year
+		}
+
+		title := "Archive"
+		if postType != "" {
+			title = sectionTitle(postType) + " archive"
+		}
+		utils.Render(w, map[string]interface{}{
+			"Years":        years,
+			"AllYears":     allYears,
+			"SelectedYear": selectedYear,
+			"PostType":     postType,
+			"SectionTitle": title,
+		}, "./templates/pages/archive.html", "./templates/partials/post-entry.html")
+	}
+}
+
+// mergedPostEntries returns the entries of every filter, each post once and
+// tagged with the first filter it appeared under. Filters that fail to load
+// are logged and skipped.
+func (h *BlogPostHandler) mergedPostEntries(r *http.Request, collectionID string, filters []string) []content.PostEntry {
+	var postEntries []content.PostEntry
+	seen := make(map[string]bool)
+	for _, filter := range filters {
+		entries, err := h.cache.GetPostEntries(r.Context(), collectionID, filter)
+		if err != nil {
+			log.ErrorContext(r.Context(), "getting post entries", "filter", filter, "err", err)
+			continue
+		}
+		for _, entry := range entries {
+			if seen[entry.ID] {
+				continue
+			}
+			seen[entry.ID] = true
+			entry.PostType = filter
+			postEntries = append(postEntries, entry)
+		}
+	}
+	return postEntries
+}
+
+// PopularPosts returns an htmx fragment listing the most viewed posts over the
+// last popularPostsDays, optionally narrowed to one filter with ?type=. Counts
+// come from the visitor tracker and are matched to posts by slug; pages that
+// are no longer in the list (renamed or unpublished) are skipped. Nothing is
+// rendered when there are no counts yet.
+func (h *BlogPostHandler) PopularPosts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.pageCounter == nil {
+			return
+		}
+		ctx, span := tracing.Start(r.Context(), "BlogPostHandler.PopularPosts")
+		defer span.End()
+		r = r.WithContext(ctx)
+		pages, err := h.pageCounter.TopPages(visitors.PostPathPrefix, popularPostsDays, 0)
+		if err != nil {
+			tracing.RecordError(span, err)
+			log.ErrorContext(r.Context(), "reading popular posts", "err", err)
+			return
+		}
+		if len(pages) == 0 {
+			return
+		}
+
+		collectionID := h.cache.GetSource().GetDefaultCollectionID()
+		filters := sectionFilters()
+		postType := r.URL.Query().Get("type")
+		if postType != "" {
+			filters = []string{postType}
+		}
+		bySlug := make(map[string]content.PostEntry)
+		for _, entry := range h.mergedPostEntries(r, collectionID, filters) {
+			bySlug[entry.Slug] = entry
+		}
+
+		var popular []PopularPost
+		for _, page := range pages {
+			entry, ok := bySlug[strings.TrimPrefix(page.Path, visitors.PostPathPrefix)]
+			if !ok {
+				continue
+			}
+			popular = append(popular, PopularPost{PostEntry: entry, Views: page.Views, Visitors: page.Visitors})
+			if len(popular) == popularPostsLimit {
+				break
+			}
+		}
+
+		err = utils.RenderPartial(w, "popularPosts", map[string]interface{}{
+			"Posts": popular,
+			"Days":  popularPostsDays,
+		}, "./templates/partials/popular-posts.html")
+		if err != nil {
+			log.ErrorContext(r.Context(), "rendering popular posts", "err", err)
+		}
 	}
 }
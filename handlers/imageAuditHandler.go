@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+	log "htmx-blog/logging"
+	"htmx-blog/services/cache"
+	"htmx-blog/services/notion"
+	"net/http"
+	"strings"
+)
+
+// ImageAuditHandler returns a handler that cross-references ./images with
+// the image IDs in the content cache. It reports orphans, corrupt files and
+// sidecars that are missing or don't match the disk. A GET changes nothing:
+// missing sidecars are recreated only on POST, and orphans older than
+// notion.OrphanGracePeriod are deleted only on POST with ?delete=true.
+// Intended to live on the internal mux (localhost-only).
+func ImageAuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		post := r.Method == http.MethodPost
+		opts := notion.AuditOptions{
+			DeleteOrphans:    post && r.URL.Query().Get("delete") == "true",
+			RecreateSidecars: post,
+		}
+		report, err := notion.AuditImages(cache.Dir(), opts)
+		if err != nil {
+			log.Error("image audit failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "audit complete: cache_files=%d referenced=%d images=%d orphans=%d deleted=%d missing_sidecars=%d sidecars_recreated=%d corrupt=%d mismatched=%d missing_originals=%d stale_refs=%d\n",
+			report.CacheFiles, report.Referenced, report.Images, len(report.Orphans), len(report.Deleted),
+			len(report.MissingSidecars), len(report.SidecarsRecreated), len(report.Corrupt), len(report.Mismatched), len(report.MissingOriginals), len(report.StaleRefs))
+		for _, section := range []struct {
+			label string
+			items []string
+		}{
+			{"orphan", report.Orphans},
+			{"deleted", report.Deleted},
+			{"missing_sidecar", report.MissingSidecars},
+			{"sidecar_recreated", report.SidecarsRecreated},
+			{"corrupt", report.Corrupt},
+			{"mismatched", report.Mismatched},
+			{"missing_original", report.MissingOriginals},
+			{"stale_ref", report.StaleRefs},
+		} {
+			if len(section.items) > 0 {
+				fmt.Fprintf(w, "%s: %s\n", section.label, strings.Join(section.items, " "))
+			}
+		}
+	}
+}
@@ -1,24 +1,75 @@
 package handlers
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	log "htmx-blog/logging"
 	"htmx-blog/services/notion"
 	"net/http"
+	"strconv"
 )
 
-// ImageBackfillHandler returns a handler that scans ./images/ and ensures
-// every non-WebP file has a WebP sibling and a metadata sidecar. Idempotent.
+// ImageBackfillHandler returns a handler that starts a background backfill
+// of ./images/ (sanitize, placeholders, encoded copies, publishing) and
+// responds 202 with the job's status. ?workers=N bounds the worker pool;
+// ?restart=true ignores an interrupted run instead of resuming it. Responds
+// 409 with the running job's status if one is already running.
 // Intended to live on the internal mux (localhost-only).
 func ImageBackfillHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		report, err := notion.BackfillImages()
+		opts := notion.BackfillOptions{Restart: r.URL.Query().Get("restart") == "true"}
+		if v := r.URL.Query().Get("workers"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "workers must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			opts.Workers = n
+		}
+		job, err := notion.StartBackfill(opts)
+		if errors.Is(err, notion.ErrBackfillRunning) {
+			writeBackfillStatus(w, http.StatusConflict, job.Status())
+			return
+		}
 		if err != nil {
-			log.Error("image backfill failed: %v", err)
+			log.Error("image backfill failed to start: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		fmt.Fprintf(w, "backfill complete: scanned=%d encoded=%d skipped=%d failed=%d\n",
-			report.Scanned, report.Encoded, report.Skipped, report.Failed)
+		writeBackfillStatus(w, http.StatusAccepted, job.Status())
+	}
+}
+
+// ImageBackfillStatusHandler reports the progress of the running backfill,
+// or the outcome of the last one.
+func ImageBackfillStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, ok := notion.LastBackfill()
+		if !ok {
+			http.Error(w, "no image backfill has run", http.StatusNotFound)
+			return
+		}
+		writeBackfillStatus(w, http.StatusOK, status)
+	}
+}
+
+// ImageBackfillCancelHandler stops the running backfill after the files in
+// progress; the next start resumes it.
+func ImageBackfillCancelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, ok := notion.CancelBackfill()
+		if !ok {
+			http.Error(w, "no image backfill running", http.StatusNotFound)
+			return
+		}
+		writeBackfillStatus(w, http.StatusOK, status)
+	}
+}
+
+func writeBackfillStatus(w http.ResponseWriter, code int, status notion.BackfillStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Error("error writing backfill status: %v", err)
 	}
 }
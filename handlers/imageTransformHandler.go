@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	log "htmx-blog/logging"
+	"htmx-blog/services/notion"
+	"net/http"
+	"strings"
+)
+
+// ImageTransformHandler serves GET /images/{id}?w=&format=&q=: the stored
+// image id resized and re-encoded on first request, then from the disk
+// cache. Without format the best type the Accept header allows is picked.
+// Requests for file names (with an extension) go to files, the plain
+// ./images file server, or 404 when files is nil.
+func ImageTransformHandler(files http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if strings.Contains(id, ".") {
+			if files == nil {
+				http.NotFound(w, r)
+				return
+			}
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		opts, err := notion.ParseTransformOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		img, err := notion.TransformImage(id, opts, r.Header.Get("Accept"))
+		switch {
+		case errors.Is(err, notion.ErrImageNotFound):
+			http.NotFound(w, r)
+			return
+		case errors.Is(err, notion.ErrFormatUnavailable):
+			http.Error(w, err.Error(), http.StatusNotAcceptable)
+			return
+		case err != nil:
+			log.Error("image transform %s: %v", id, err)
+			http.Error(w, "error transforming image", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", img.ContentType)
+		if img.Negotiated {
+			w.Header().Set("Vary", "Accept")
+		}
+		if img.Immutable {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			// Block IDs follow the image if it's replaced in Notion.
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		}
+		http.ServeFile(w, r, img.Path)
+	}
+}
@@ -1,16 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	log "htmx-blog/logging"
 	"htmx-blog/models"
 	"htmx-blog/services/cache"
+	"htmx-blog/services/notion"
 	"htmx-blog/utils"
 )
 
@@ -26,11 +25,11 @@ func NewReadingNowHandler(cache cache.Cache) *ReadingNowHandler {
 
 func (h *ReadingNowHandler) GetReadingNow() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Info("getting reading now")
+		log.DebugContext(r.Context(), "getting reading now")
 		collectionID := h.cache.GetSource().GetDefaultCollectionID()
 		readingEntries, err := h.cache.GetReadingEntries(r.Context(), collectionID, "speaking")
 		if err != nil {
-			log.Error("error getting reading now entries: %v", err)
+			log.ErrorContext(r.Context(), "getting reading now entries", "err", err)
 			w.Write([]byte("error getting reading now entries"))
 			return
 		}
@@ -46,53 +45,23 @@ func (h *ReadingNowHandler) GetReadingNow() http.HandlerFunc {
 			readingNowBlocks = append(readingNowBlocks, readingNowBlock)
 		}
 
-		log.Info("reading now blocks: %v", readingNowBlocks)
+		log.Debug("reading now blocks: %v", readingNowBlocks)
 		utils.Render(w, map[string]interface{}{
 			"Books": readingNowBlocks,
 		}, "./templates/pages/reading-now.html")
 	}
 }
 
-func StoreNotionImage(rawBlocks []json.RawMessage, i int) (string, error) {
-	var imageBlock models.Image
-	err := json.Unmarshal(rawBlocks[i], &imageBlock)
-	if err != nil {
-		log.Error("error unmarshalling imageblock: %v", err)
-	}
-	awsImageURL := imageBlock.Image.File.URL
-	resp, err := http.Get(awsImageURL)
-	if err != nil {
-		return "", fmt.Errorf("error downloading image from s3: %v", err)
-	}
-	defer resp.Body.Close()
-
-	imageBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading image bytes: %v", err)
-	}
-	absPath, err := filepath.Abs("./images")
-	if err != nil {
-		return "", fmt.Errorf("error getting absolute path: %v", err)
-	}
-
-	if _, err := os.Stat(absPath); os.IsNotExist(err) {
-		os.Mkdir(absPath, os.ModePerm)
+// StoreNotionImage stores the image of the image block rawBlocks[i] via
+// notion.StoreNotionImage (hardened download, sanitize, content-addressed
+// storage) and returns the URL the block now points at.
+func StoreNotionImage(ctx context.Context, rawBlocks []json.RawMessage, i int) (string, error) {
+	if err := notion.StoreNotionImage(ctx, rawBlocks, i); err != nil {
+		return "", err
 	}
-
-	filePath := filepath.Join(absPath, "/", imageBlock.ID+".png")
-	err = os.WriteFile(filePath, imageBytes, 0755)
-	if err != nil {
-		return "", fmt.Errorf("error writing image to file: %v", err)
-	}
-
-	imageBlock.Image.File.URL = "https://cloud.shaikzhafir.com/images/" + imageBlock.ID + ".png"
-	if os.Getenv("DEV") == "true" {
-		imageBlock.Image.File.URL = "/images/" + imageBlock.ID + ".png"
-	}
-
-	rawBlocks[i], err = json.Marshal(imageBlock)
-	if err != nil {
-		return "", fmt.Errorf("error marshalling imageblock: %v", err)
+	var imageBlock models.Image
+	if err := json.Unmarshal(rawBlocks[i], &imageBlock); err != nil {
+		return "", fmt.Errorf("error unmarshalling imageblock: %v", err)
 	}
 	return imageBlock.Image.File.URL, nil
 }
@@ -0,0 +1,41 @@
+// Package httpstatus records the status code a handler writes, for the
+// middleware that counts, traces and filters requests by it.
+package httpstatus
+
+import "net/http"
+
+// Recorder wraps a ResponseWriter and remembers the first status code
+// written. It starts as 200, what net/http sends if the handler never sets
+// one.
+type Recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// NewRecorder wraps w.
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Status is the status code sent, or 200 if none was set.
+func (r *Recorder) Status() int {
+	return r.status
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,26 @@
+package httpstatus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder(httptest.NewRecorder())
+	rec.Write([]byte("body"))
+	rec.WriteHeader(http.StatusNotFound) // too late, net/http ignores it
+	if rec.Status() != http.StatusOK {
+		t.Fatalf("after an implicit 200: %d", rec.Status())
+	}
+
+	rec = NewRecorder(httptest.NewRecorder())
+	rec.WriteHeader(http.StatusBadGateway)
+	rec.WriteHeader(http.StatusOK)
+	if rec.Status() != http.StatusBadGateway {
+		t.Fatalf("first status should win: %d", rec.Status())
+	}
+	if rc := http.NewResponseController(rec); rc.Flush() != nil {
+		t.Fatal("Flush should reach the underlying writer")
+	}
+}
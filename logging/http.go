@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries the request ID in both directions: a valid one on
+// the request (e.g. from the reverse proxy) is kept, and the ID used is
+// echoed on the response.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// Middleware gives every request an ID, puts it in the request context for
+// the *Context log functions and sets it on the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// WithRequestID returns ctx carrying id, for work started outside a request
+// that should still be traceable in the logs.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID is the ID ctx carries, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts short IDs of letters, digits, '-', '_' and '.', so
+// a client can't inject anything odd into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// LevelHandler reports the log level on GET and changes it on PUT, from a
+// ?level= parameter or the request body (debug, info, warn or error).
+func LevelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+			value := r.URL.Query().Get("level")
+			if value == "" {
+				body, _ := io.ReadAll(io.LimitReader(r.Body, 64))
+				value = string(body)
+			}
+			l, err := ParseLevel(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unknown level %q: want debug, info, warn or error", strings.TrimSpace(value)), http.StatusBadRequest)
+				return
+			}
+			previous := Level()
+			SetLevel(l)
+			InfoContext(r.Context(), "log level changed", "from", previous.String(), "to", l.String())
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, strings.ToLower(Level().String()))
+	}
+}
@@ -1,42 +1,192 @@
+// Package logging is the server's logger. It writes JSON lines carrying a
+// level, message and key/value attributes, to stdout in development and to a
+// size-rotated file in production.
+//
+// Info, Error and friends take printf-style arguments; the *Context variants
+// take slog-style key/value pairs and add the request ID that Middleware put
+// in the context. The level is read from LOG_LEVEL at startup and can be
+// changed while running with SetLevel or LevelHandler.
 package logging
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
 
-	"log/slog"
+const (
+	defaultProdLogFile = "/opt/blog/blog.log"
+	defaultMaxSizeMB   = 100
+	defaultMaxBackups  = 5
 )
 
-var logger *slog.Logger
+var (
+	level  = new(slog.LevelVar)
+	logger atomic.Pointer[slog.Logger]
+)
 
+// init configures the logger from the environment:
+//
+//   - LOG_LEVEL: debug, info (default), warn or error
+//   - LOG_FILE: file to log to; defaults to /opt/blog/blog.log when PROD=true
+//     and stdout otherwise
+//   - LOG_MAX_SIZE_MB, LOG_MAX_BACKUPS: when the file passes the size
+//     (default 100MB; 0 turns rotation off) it is rotated to LOG_FILE.1,
+//     keeping that many old files (default 5; 0 truncates instead)
+//
+// A log file that can't be opened is reported and stderr used instead: losing
+// the file shouldn't take the site down.
 func init() {
-	var file *os.File
-	logDir := "/opt/blog/blog.log"
-	if os.Getenv("PROD") == "true" {
-		var err error
-		file, err = os.OpenFile(logDir, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			panic(err)
-		}
-	} else {
-		file = os.Stdout
+	levelErr := level.UnmarshalText([]byte(envOr("LOG_LEVEL", "info")))
+	out, outErr := openOutput()
+	SetOutput(out)
+	if levelErr != nil {
+		Warn("ignoring LOG_LEVEL: %v", levelErr)
 	}
-	logger = slog.New(slog.NewJSONHandler(file, nil))
+	if outErr != nil {
+		Error("logging to stderr: %v", outErr)
+	}
+}
+
+func openOutput() (io.Writer, error) {
+	path := os.Getenv("LOG_FILE")
+	if path == "" && os.Getenv("PROD") == "true" {
+		path = defaultProdLogFile
+	}
+	if path == "" {
+		return os.Stdout, nil
+	}
+	maxSize := envInt("LOG_MAX_SIZE_MB", defaultMaxSizeMB)
+	f, err := newRotatingFile(path, int64(maxSize)<<20, envInt("LOG_MAX_BACKUPS", defaultMaxBackups))
+	if err != nil {
+		return os.Stderr, err
+	}
+	return f, nil
+}
+
+// SetOutput sends log lines to w from now on.
+func SetOutput(w io.Writer) {
+	logger.Store(slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})}))
+}
+
+// Logger returns the current logger, for code that wants slog directly.
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// With returns a logger that adds args, as key/value pairs, to every line.
+func With(args ...any) *slog.Logger {
+	return Logger().With(args...)
+}
+
+// Level is the minimum level currently logged.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel changes the minimum level logged.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// ParseLevel reads debug, info, warn or error, in any case. An offset such
+// as warn+2 is accepted too.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return l, err
+}
+
+// Debug logs detail that is only wanted while investigating something.
+func Debug(format string, a ...interface{}) {
+	logf(slog.LevelDebug, format, a...)
 }
 
 // Info logs informational messages
 func Info(format string, a ...interface{}) {
-	message := fmt.Sprintf(format, a...)
-	logger.Info(message)
+	logf(slog.LevelInfo, format, a...)
+}
+
+// Warn logs something unexpected that the server carried on from.
+func Warn(format string, a ...interface{}) {
+	logf(slog.LevelWarn, format, a...)
 }
 
 func Error(format string, a ...interface{}) {
-	message := fmt.Sprintf(format, a...)
-	logger.Error(message)
+	logf(slog.LevelError, format, a...)
 }
 
 func Fatal(format string, a ...interface{}) {
-	message := fmt.Sprintf(format, a...)
-	logger.Error(message)
+	logf(slog.LevelError, format, a...)
 	os.Exit(1)
 }
+
+// logf formats only when the level is enabled, so debug lines cost nothing
+// when they're off.
+func logf(l slog.Level, format string, a ...interface{}) {
+	lg := Logger()
+	if !lg.Enabled(context.Background(), l) {
+		return
+	}
+	lg.Log(context.Background(), l, fmt.Sprintf(format, a...))
+}
+
+// DebugContext logs msg with key/value args and the request ID from ctx.
+func DebugContext(ctx context.Context, msg string, args ...any) {
+	Logger().Log(ctx, slog.LevelDebug, msg, args...)
+}
+
+// InfoContext logs msg with key/value args and the request ID from ctx.
+func InfoContext(ctx context.Context, msg string, args ...any) {
+	Logger().Log(ctx, slog.LevelInfo, msg, args...)
+}
+
+// WarnContext logs msg with key/value args and the request ID from ctx.
+func WarnContext(ctx context.Context, msg string, args ...any) {
+	Logger().Log(ctx, slog.LevelWarn, msg, args...)
+}
+
+// ErrorContext logs msg with key/value args and the request ID from ctx.
+func ErrorContext(ctx context.Context, msg string, args ...any) {
+	Logger().Log(ctx, slog.LevelError, msg, args...)
+}
+
+// contextHandler adds the request ID carried by a record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 0 {
+		return fallback
+	}
+	return v
+}
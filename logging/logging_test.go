@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// capture sends log lines to a buffer, at level l, for the rest of the test.
+func capture(t *testing.T, l slog.Level) *bytes.Buffer {
+	t.Helper()
+	prevLogger, prevLevel := Logger(), Level()
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetLevel(l)
+	t.Cleanup(func() {
+		logger.Store(prevLogger)
+		SetLevel(prevLevel)
+	})
+	return &buf
+}
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("not JSON: %s", line)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestLevelFiltersAndPrintfFormatting(t *testing.T) {
+	buf := capture(t, slog.LevelWarn)
+	Debug("hidden %d", 1)
+	Info("hidden %d", 2)
+	Warn("shown %d", 3)
+	Error("shown %s", "four")
+
+	got := lines(t, buf)
+	if len(got) != 2 || got[0]["msg"] != "shown 3" || got[0]["level"] != "WARN" || got[1]["msg"] != "shown four" {
+		t.Fatalf("lines: %v", got)
+	}
+
+	SetLevel(slog.LevelDebug)
+	Debug("now shown")
+	if got := lines(t, buf); got[len(got)-1]["msg"] != "now shown" {
+		t.Fatalf("debug line missing after lowering the level: %v", got)
+	}
+}
+
+func TestMiddlewareCarriesRequestIDIntoLogs(t *testing.T) {
+	buf := capture(t, slog.LevelInfo)
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		InfoContext(r.Context(), "rendering post", "slug", "hello")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	generated := rec.Header().Get(RequestIDHeader)
+	if len(generated) != 16 {
+		t.Fatalf("generated request ID %q", generated)
+	}
+
+	for _, tc := range []struct{ header, want string }{
+		{"proxy-abc.123", "proxy-abc.123"},
+		{"bad id\nwith newline", ""},
+		{strings.Repeat("a", maxRequestIDLength+1), ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, tc.header)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		got := rec.Header().Get(RequestIDHeader)
+		if tc.want != "" && got != tc.want || tc.want == "" && got == tc.header {
+			t.Fatalf("incoming %q: used %q", tc.header, got)
+		}
+	}
+
+	first := lines(t, buf)[0]
+	if first["request_id"] != generated || first["slug"] != "hello" || first["msg"] != "rendering post" {
+		t.Fatalf("line: %v", first)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	capture(t, slog.LevelInfo)
+	handler := LevelHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/log/level?level=DEBUG", nil))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "debug" || Level() != slog.LevelDebug {
+		t.Fatalf("PUT: %d %q, level %v", rec.Code, rec.Body.String(), Level())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader("warn\n")))
+	if strings.TrimSpace(rec.Body.String()) != "warn" {
+		t.Fatalf("PUT body: %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/log/level?level=loud", nil))
+	if rec.Code != http.StatusBadRequest || Level() != slog.LevelWarn {
+		t.Fatalf("bad level: %d, level %v", rec.Code, Level())
+	}
+}
+
+func TestRotatingFileKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "blog.log")
+	f, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for suffix, want := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		got, err := os.ReadFile(path + suffix)
+		if err != nil || string(got) != want {
+			t.Fatalf("%s: %q, %v; want %q", path+suffix, got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("kept more than 2 backups: %v", err)
+	}
+
+	// Reopening appends to what is there and counts it towards the limit.
+	f, err = newRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("fifth\n"))
+	if got, _ := os.ReadFile(path); string(got) != "fifth\n" {
+		t.Fatalf("with no backups the file is truncated, got %q", got)
+	}
+}
+
+func TestOpenOutputFallsBackToStderr(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOG_FILE", filepath.Join(blocker, "blog.log"))
+
+	out, err := openOutput()
+	if err == nil || out != os.Stderr {
+		t.Fatalf("want stderr and an error, got %v, %v", out, err)
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"htmx-blog/internal/httpstatus"
+)
+
+var (
+	httpRequests = NewCounter("blog_http_requests_total",
+		"HTTP requests by server, route pattern and status code.", "server", "pattern", "code")
+	httpDuration = NewHistogram("blog_http_request_duration_seconds",
+		"HTTP request latency by server and route pattern.", DefaultBuckets, "server", "pattern")
+)
+
+// unmatchedPattern labels requests no route matched, so arbitrary paths
+// can't create series.
+const unmatchedPattern = "(unmatched)"
+
+// InstrumentMux counts and times requests to mux by the route pattern that
+// serves them, such as "GET /notion/posts/{slug}". server tells the public
+// and internal listeners apart.
+func InstrumentMux(server string, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = unmatchedPattern
+		}
+		rec := httpstatus.NewRecorder(w)
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+		httpDuration.Observe(time.Since(start).Seconds(), server, pattern)
+		httpRequests.Inc(server, pattern, strconv.Itoa(rec.Status()))
+	})
+}
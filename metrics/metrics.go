@@ -0,0 +1,305 @@
+// Package metrics keeps in-process counters and histograms and serves them in
+// the Prometheus text exposition format, for scraping from the internal
+// listener.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are latency buckets in seconds, for request handling.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics to expose together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is a counter or histogram family.
+type metric interface {
+	name() string
+	write(w io.Writer) error
+}
+
+// Default is the registry the package-level constructors and Handler use.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name()))
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// NewCounter registers a counter family in the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewHistogram registers a histogram family in the default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Handler serves the registry's metrics in the text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Write writes every metric, in registration order.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// family is what counters and histograms share: a name, help text and the
+// label names their series are keyed by.
+type family struct {
+	metricName string
+	help       string
+	labels     []string
+}
+
+func (f *family) name() string { return f.metricName }
+
+// key joins label values into a series key. Values must match the family's
+// label names one for one; anything else is a bug at the call site.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.metricName, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (f *family) header(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.metricName, escapeHelp(f.help), f.metricName, kind)
+	return err
+}
+
+// labelPairs renders {a="x",b="y"} for a series key, with extra pairs (such
+// as a histogram's le) appended.
+func (f *family) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(f.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labels[i], escapeLabel(v)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a family of monotonically increasing values, one per
+// combination of label values.
+type Counter struct {
+	family
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: family{metricName: name, help: help, labels: labels}, series: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the series for labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series for labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: %s can't decrease", c.metricName))
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.series[key] += v
+	c.mu.Unlock()
+}
+
+// Value is the current value of the series for labelValues.
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.series[key]
+}
+
+func (c *Counter) write(w io.Writer) error {
+	if err := c.header(w, "counter"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	keys := sortedKeys(c.series)
+	values := make([]float64, len(keys))
+	for i, k := range keys {
+		values[i] = c.series[k]
+	}
+	c.mu.Unlock()
+	for i, k := range keys {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelPairs(k), formatFloat(values[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram is a family of distributions, one per combination of label
+// values, counted into cumulative buckets.
+type Histogram struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// counts[i] is observations <= buckets[i]; the last is +Inf.
+	counts []uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given upper bounds, which are
+// sorted; +Inf is implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	h := &Histogram{
+		family:  family{metricName: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the series for labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.counts[len(h.buckets)]++
+	s.sum += v
+}
+
+// Count is the number of observations in the series for labelValues.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.counts[len(h.buckets)]
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	if err := h.header(w, "histogram"); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	keys := sortedKeys(h.series)
+	snapshot := make([]histogramSeries, len(keys))
+	for i, k := range keys {
+		s := h.series[k]
+		snapshot[i] = histogramSeries{counts: slices.Clone(s.counts), sum: s.sum}
+	}
+	h.mu.Unlock()
+
+	for i, k := range keys {
+		s := snapshot[i]
+		for j, count := range s.counts {
+			le := math.Inf(1)
+			if j < len(h.buckets) {
+				le = h.buckets[j]
+			}
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(k, "le", formatFloat(le)), count); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n",
+			h.metricName, h.labelPairs(k), formatFloat(s.sum),
+			h.metricName, h.labelPairs(k), s.counts[len(h.buckets)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+func escapeHelp(v string) string { return helpEscaper.Replace(v) }
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWritesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("test_requests_total", "Requests by route.", "route", "code")
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc("/a", "500")
+	plain := r.NewCounter("test_plain_total", "No labels.")
+	plain.Inc()
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_requests_total Requests by route.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",code="200"} 2
+test_requests_total{route="/a",code="500"} 1
+test_requests_total{route="/b",code="200"} 1
+# HELP test_plain_total No labels.
+# TYPE test_plain_total counter
+test_plain_total 1
+`
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("test_seconds", "Durations.", []float64{1, 0.1}, "op")
+	for _, v := range []float64{0.05, 0.5, 0.5, 3} {
+		h.Observe(v, "encode")
+	}
+	if got := h.Count("encode"); got != 4 {
+		t.Fatalf("count: %d", got)
+	}
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="encode",le="0.1"} 1
+test_seconds_bucket{op="encode",le="1"} 3
+test_seconds_bucket{op="encode",le="+Inf"} 4
+test_seconds_sum{op="encode"} 4.05
+test_seconds_count{op="encode"} 4
+`
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Help with a \\ and\nnewline.", "v")
+	c.Inc("a \"quoted\" \\ value\n")
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`# HELP test_total Help with a \\ and\nnewline.`,
+		`test_total{v="a \"quoted\" \\ value\n"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %s in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRegistryRejectsMisuse(t *testing.T) {
+	mustPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("%s: want a panic", name)
+			}
+		}()
+		fn()
+	}
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Help.", "a")
+	mustPanic("duplicate name", func() { r.NewCounter("test_total", "Again.") })
+	mustPanic("wrong label count", func() { c.Inc() })
+	mustPanic("negative add", func() { c.Add(-1, "x") })
+}
+
+func TestInstrumentMuxLabelsByPattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /posts/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("slug") == "missing" {
+			http.NotFound(w, r)
+		}
+	})
+	handler := InstrumentMux("test", mux)
+
+	for _, target := range []string{"/posts/a", "/posts/b", "/posts/missing", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	for _, tc := range []struct {
+		pattern, code string
+		want          float64
+	}{
+		{"GET /posts/{slug}", "200", 2},
+		{"GET /posts/{slug}", "404", 1},
+		{unmatchedPattern, "404", 1},
+	} {
+		if got := httpRequests.Value("test", tc.pattern, tc.code); got != tc.want {
+			t.Fatalf("%s %s: got %v, want %v", tc.pattern, tc.code, got, tc.want)
+		}
+	}
+	if got := httpDuration.Count("test", "GET /posts/{slug}"); got != 3 {
+		t.Fatalf("latency observations: %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", ct)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `blog_http_requests_total{server="test",pattern="GET /posts/{slug}",code="200"} 2`) {
+		t.Fatalf("metrics body:\n%s", body)
+	}
+}
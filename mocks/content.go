@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"htmx-blog/services/content"
 	"io"
+	"time"
 )
 
 // MockContentSource implements content.Source for testing
@@ -29,14 +30,14 @@ func NewMockContentSource() content.Source {
 				Slug:        "test",
 				ID:          "test",
 				Title:       "test",
-				CreatedTime: "test",
+				CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 			},
 		},
 		ReadingEntries: []content.ReadingEntry{
 			{
 				ID:          "test",
 				Title:       "test",
-				CreatedTime: "test",
+				CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 				Author:      "test author",
 			},
 		},
@@ -65,7 +66,7 @@ func (m *MockContentSource) GetDefaultCollectionID() string {
 }
 
 // ProcessBlockForStorage implements content.Source
-func (m *MockContentSource) ProcessBlockForStorage(blocks []json.RawMessage, index int) error {
+func (m *MockContentSource) ProcessBlockForStorage(ctx context.Context, blocks []json.RawMessage, index int) error {
 	// No-op for testing
 	return nil
 }
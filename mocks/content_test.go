@@ -53,7 +53,7 @@ func Test_MockContentSource_ProcessBlockForStorage(t *testing.T) {
 	source := NewMockContentSource().(*MockContentSource)
 
 	// Should be a no-op
-	err := source.ProcessBlockForStorage(nil, 0)
+	err := source.ProcessBlockForStorage(context.Background(), nil, 0)
 	assert.NoError(t, err)
 }
 
@@ -1,11 +1,13 @@
 package mocks
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"htmx-blog/models"
 	"htmx-blog/services/notion"
 	"io"
+	"time"
 )
 
 // just trying out handrolling own mocks lol
@@ -18,12 +20,12 @@ type mockNotionClient struct {
 }
 
 // GetReadingNowEntries implements notion.NotionClient.
-func (m *mockNotionClient) GetReadingNowEntries(datasourceID string, filter string) ([]notion.ReadingNow, error) {
+func (m *mockNotionClient) GetReadingNowEntries(ctx context.Context, datasourceID string, filter string) ([]notion.ReadingNow, error) {
 	panic("unimplemented")
 }
 
 // GetAllPosts implements notion.NotionClient.
-func (*mockNotionClient) GetAllPosts(databaseID string, filter string) (map[string]string, error) {
+func (*mockNotionClient) GetAllPosts(ctx context.Context, databaseID string, filter string) (map[string]string, error) {
 	panic("unimplemented")
 }
 
@@ -33,7 +35,7 @@ func (*mockNotionClient) GetBlock(blockID string) (models.Block, error) {
 }
 
 // GetBlockChildren implements notion.NotionClient.
-func (*mockNotionClient) GetBlockChildren(blockID string) ([]json.RawMessage, error) {
+func (*mockNotionClient) GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
 	testRawJSON := `[{"test":"test"}]`
 	var response []json.RawMessage
 	err := json.Unmarshal([]byte(testRawJSON), &response)
@@ -49,17 +51,17 @@ func (*mockNotionClient) GetDatabaseID() string {
 }
 
 // GetPage implements notion.NotionClient.
-func (*mockNotionClient) GetPage(pageID string) (models.Page, error) {
+func (*mockNotionClient) GetPage(ctx context.Context, pageID string) (models.Page, error) {
 	panic("unimplemented")
 }
 
 // GetSlugEntries implements notion.NotionClient.
-func (*mockNotionClient) GetSlugEntries(databaseID string, filter string) ([]notion.SlugEntry, error) {
+func (*mockNotionClient) GetSlugEntries(ctx context.Context, databaseID string, filter string) ([]notion.SlugEntry, error) {
 	return []notion.SlugEntry{
 		{
 			Slug:        "test",
 			ID:          "test",
-			CreatedTime: "test",
+			CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 			Title:       "test",
 		},
 	}, nil
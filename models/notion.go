@@ -21,6 +21,8 @@ type Block struct {
 
 	RawJSON map[string]interface{} `json:"-"`
 	Content string                 `json:"content"`
+	// AnchorID is the page-unique element ID assigned to headings at render time.
+	AnchorID string `json:"-"`
 }
 
 // paragraph block type
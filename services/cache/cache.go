@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	log "htmx-blog/logging"
+	"htmx-blog/metrics"
 	"htmx-blog/services/content"
+	"htmx-blog/tracing"
 	"os"
 	"time"
 )
@@ -25,6 +29,20 @@ var CurrentTime = func() time.Time {
 // CacheTTL is the duration after which cache entries are considered stale
 const CacheTTL = time.Minute * 1
 
+// Cache kinds, for metrics.
+const (
+	kindBlocks  = "blocks"
+	kindPosts   = "posts"
+	kindReading = "reading"
+)
+
+var (
+	cacheLookups = metrics.NewCounter("blog_cache_lookups_total",
+		"Content cache lookups by kind and result (hit or miss).", "kind", "result")
+	cacheRefreshes = metrics.NewCounter("blog_cache_stale_refreshes_total",
+		"Background refreshes of stale cache entries by kind and result (ok or error).", "kind", "result")
+)
+
 // Cache provides caching functionality for content data.
 // It wraps a content.Source and adds caching capabilities.
 type Cache interface {
@@ -59,13 +77,17 @@ type JSONClient interface {
 	Set(key string, entry *CacheEntry) error
 }
 
-// NewCache creates a new Cache instance that wraps a content source
-func NewCache(source content.Source) Cache {
-	cacheDir := "./cache"
+// Dir returns the cache directory: $CACHE_DIR, or ./cache by default.
+func Dir() string {
 	if customDir := os.Getenv("CACHE_DIR"); customDir != "" {
-		cacheDir = customDir
+		return customDir
 	}
-	jsonClient := NewJSONFileClient(cacheDir)
+	return "./cache"
+}
+
+// NewCache creates a new Cache instance that wraps a content source
+func NewCache(source content.Source) Cache {
+	jsonClient := NewJSONFileClient(Dir())
 	return &cache{
 		source:     source,
 		jsonClient: jsonClient,
@@ -83,24 +105,37 @@ func (c *cache) GetSource() content.Source {
 	return c.source
 }
 
+// countLookup records a hit or miss in the metrics and on the lookup's span.
+func countLookup(span trace.Span, kind, result string) {
+	cacheLookups.Inc(kind, result)
+	span.SetAttributes(attribute.String("cache.result", result))
+}
+
 // GetBlockChildren retrieves block children from cache or fetches from source
 func (c *cache) GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
+	ctx, span := tracing.Start(ctx, "cache.GetBlockChildren", attribute.String("cache.kind", kindBlocks), attribute.String("cache.key", blockID))
+	defer span.End()
+
 	cacheEntry, err := c.jsonClient.Get(blockID)
 	if err != nil {
 		if errors.Is(err, ErrCacheMiss) {
-			log.Info("cache miss for block %s, fetching from source", blockID)
+			countLookup(span, kindBlocks, "miss")
+			log.InfoContext(ctx, "cache miss, fetching from source", "kind", kindBlocks, "key", blockID)
 			return c.fetchAndCacheBlockChildren(ctx, blockID)
 		}
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error reading from cache: %w", err)
 	}
 
 	var blocks []json.RawMessage
 	if err := json.Unmarshal(cacheEntry.Data, &blocks); err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("failed to deserialize cached blocks: %w", err)
 	}
+	countLookup(span, kindBlocks, "hit")
 
 	// Asynchronously refresh cache if stale
-	c.refreshIfStale(blockID, func(ctx context.Context) error {
+	c.refreshIfStale(kindBlocks, blockID, func(ctx context.Context) error {
 		_, err := c.fetchAndCacheBlockChildren(ctx, blockID)
 		return err
 	})
@@ -111,23 +146,29 @@ func (c *cache) GetBlockChildren(ctx context.Context, blockID string) ([]json.Ra
 // GetPostEntries retrieves post entries from cache or fetches from source
 func (c *cache) GetPostEntries(ctx context.Context, collectionID, filter string) ([]content.PostEntry, error) {
 	cacheKey := buildCacheKey(collectionID, filter)
+	ctx, span := tracing.Start(ctx, "cache.GetPostEntries", attribute.String("cache.kind", kindPosts), attribute.String("cache.key", cacheKey))
+	defer span.End()
 
 	cacheEntry, err := c.jsonClient.Get(cacheKey)
 	if err != nil {
 		if errors.Is(err, ErrCacheMiss) {
-			log.Info("cache miss for post entries, fetching from source")
+			countLookup(span, kindPosts, "miss")
+			log.InfoContext(ctx, "cache miss, fetching from source", "kind", kindPosts, "key", cacheKey)
 			return c.fetchAndCachePostEntries(ctx, collectionID, filter)
 		}
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error reading from cache: %w", err)
 	}
 
 	var entries []content.PostEntry
 	if err := json.Unmarshal(cacheEntry.Data, &entries); err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("failed to deserialize cached post entries: %w", err)
 	}
+	countLookup(span, kindPosts, "hit")
 
 	// Asynchronously refresh cache if stale
-	c.refreshIfStale(cacheKey, func(ctx context.Context) error {
+	c.refreshIfStale(kindPosts, cacheKey, func(ctx context.Context) error {
 		_, err := c.fetchAndCachePostEntries(ctx, collectionID, filter)
 		return err
 	})
@@ -153,23 +194,29 @@ func (c *cache) GetBlockIDBySlug(ctx context.Context, collectionID, slug, filter
 // GetReadingEntries retrieves reading entries from cache or fetches from source
 func (c *cache) GetReadingEntries(ctx context.Context, collectionID, filter string) ([]content.ReadingEntry, error) {
 	cacheKey := buildCacheKey(collectionID, filter)
+	ctx, span := tracing.Start(ctx, "cache.GetReadingEntries", attribute.String("cache.kind", kindReading), attribute.String("cache.key", cacheKey))
+	defer span.End()
 
 	cacheEntry, err := c.jsonClient.Get(cacheKey)
 	if err != nil {
 		if errors.Is(err, ErrCacheMiss) {
-			log.Info("cache miss for reading entries, fetching from source")
+			countLookup(span, kindReading, "miss")
+			log.InfoContext(ctx, "cache miss, fetching from source", "kind", kindReading, "key", cacheKey)
 			return c.fetchAndCacheReadingEntries(ctx, collectionID, filter)
 		}
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error reading from cache: %w", err)
 	}
 
 	var entries []content.ReadingEntry
 	if err := json.Unmarshal(cacheEntry.Data, &entries); err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("failed to deserialize cached reading entries: %w", err)
 	}
+	countLookup(span, kindReading, "hit")
 
 	// Asynchronously refresh cache if stale
-	c.refreshIfStale(cacheKey, func(ctx context.Context) error {
+	c.refreshIfStale(kindReading, cacheKey, func(ctx context.Context) error {
 		_, err := c.fetchAndCacheReadingEntries(ctx, collectionID, filter)
 		return err
 	})
@@ -179,20 +226,28 @@ func (c *cache) GetReadingEntries(ctx context.Context, collectionID, filter stri
 
 // fetchAndCacheBlockChildren fetches block children from source and caches them
 func (c *cache) fetchAndCacheBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
+	ctx, span := tracing.Start(ctx, "cache.fetch", attribute.String("cache.kind", kindBlocks), attribute.String("cache.key", blockID))
+	defer span.End()
+
 	rawBlocks, err := c.source.GetBlockChildren(ctx, blockID)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error getting block children from source: %w", err)
 	}
 
 	// Allow the source to process blocks before caching (e.g., download images)
+	processCtx, processSpan := tracing.Start(ctx, "cache.process_blocks", attribute.Int("blocks", len(rawBlocks)))
 	for i := range rawBlocks {
-		if err := c.source.ProcessBlockForStorage(rawBlocks, i); err != nil {
-			log.Error("error processing block for storage: %v", err)
+		if err := c.source.ProcessBlockForStorage(processCtx, rawBlocks, i); err != nil {
+			tracing.RecordError(processSpan, err)
+			log.ErrorContext(ctx, "processing block for storage", "key", blockID, "block", i, "err", err)
 		}
 	}
+	processSpan.End()
 
 	// Cache the processed blocks
 	if err := c.cacheData(blockID, rawBlocks); err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error caching block children: %w", err)
 	}
 
@@ -201,13 +256,19 @@ func (c *cache) fetchAndCacheBlockChildren(ctx context.Context, blockID string)
 
 // fetchAndCachePostEntries fetches post entries from source and caches them
 func (c *cache) fetchAndCachePostEntries(ctx context.Context, collectionID, filter string) ([]content.PostEntry, error) {
+	ctx, span := tracing.Start(ctx, "cache.fetch", attribute.String("cache.kind", kindPosts), attribute.String("cache.key", buildCacheKey(collectionID, filter)))
+	defer span.End()
+
 	entries, err := c.source.GetPostEntries(ctx, collectionID, filter)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error getting post entries from source: %w", err)
 	}
+	c.attachTextStats(entries)
 
 	cacheKey := buildCacheKey(collectionID, filter)
 	if err := c.cacheData(cacheKey, entries); err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error caching post entries: %w", err)
 	}
 
@@ -216,19 +277,46 @@ func (c *cache) fetchAndCachePostEntries(ctx context.Context, collectionID, filt
 
 // fetchAndCacheReadingEntries fetches reading entries from source and caches them
 func (c *cache) fetchAndCacheReadingEntries(ctx context.Context, collectionID, filter string) ([]content.ReadingEntry, error) {
+	ctx, span := tracing.Start(ctx, "cache.fetch", attribute.String("cache.kind", kindReading), attribute.String("cache.key", buildCacheKey(collectionID, filter)))
+	defer span.End()
+
 	entries, err := c.source.GetReadingEntries(ctx, collectionID, filter)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error getting reading entries from source: %w", err)
 	}
 
 	cacheKey := buildCacheKey(collectionID, filter)
 	if err := c.cacheData(cacheKey, entries); err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("error caching reading entries: %w", err)
 	}
 
 	return entries, nil
 }
 
+// attachTextStats fills word count, reading time and excerpt for entries whose
+// blocks are already cached. It never fetches from the source: posts nobody
+// has opened yet simply get their stats on a later refresh.
+func (c *cache) attachTextStats(entries []content.PostEntry) {
+	extractor, ok := c.source.(content.TextExtractor)
+	if !ok {
+		return
+	}
+	for i := range entries {
+		cacheEntry, err := c.jsonClient.Get(entries[i].ID)
+		if err != nil {
+			continue
+		}
+		var blocks []json.RawMessage
+		if err := json.Unmarshal(cacheEntry.Data, &blocks); err != nil {
+			log.Error("error decoding cached blocks for %s: %v", entries[i].ID, err)
+			continue
+		}
+		content.ComputeTextStats(blocks, extractor).Apply(&entries[i])
+	}
+}
+
 // cacheData marshals and stores data in the JSON cache
 func (c *cache) cacheData(key string, data any) error {
 	jsonData, err := json.Marshal(data)
@@ -249,7 +337,7 @@ func (c *cache) cacheData(key string, data any) error {
 }
 
 // refreshIfStale checks if cache is stale and refreshes it asynchronously
-func (c *cache) refreshIfStale(key string, refreshFn func(ctx context.Context) error) {
+func (c *cache) refreshIfStale(kind, key string, refreshFn func(ctx context.Context) error) {
 	go func() {
 		entry, err := c.jsonClient.Get(key)
 		if err != nil {
@@ -257,10 +345,17 @@ func (c *cache) refreshIfStale(key string, refreshFn func(ctx context.Context) e
 		}
 
 		if time.Since(entry.Timestamp) > CacheTTL {
-			log.Info("cache expired for %s, refreshing", key)
-			if err := refreshFn(context.Background()); err != nil {
-				log.Error("error refreshing cache for %s: %v", key, err)
+			// The refresh outlives the request, so it starts its own trace.
+			ctx, span := tracing.Start(context.Background(), "cache.refresh", attribute.String("cache.kind", kind), attribute.String("cache.key", key))
+			defer span.End()
+			log.InfoContext(ctx, "cache expired, refreshing", "kind", kind, "key", key)
+			if err := refreshFn(ctx); err != nil {
+				tracing.RecordError(span, err)
+				cacheRefreshes.Inc(kind, "error")
+				log.ErrorContext(ctx, "refreshing cache", "kind", kind, "key", key, "err", err)
+				return
 			}
+			cacheRefreshes.Inc(kind, "ok")
 		}
 	}()
 }
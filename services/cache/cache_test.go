@@ -1,9 +1,17 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"htmx-blog/services/content"
+	"htmx-blog/tracing"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -181,3 +189,163 @@ func TestNewJSONFileClient_CreatesDirectory(t *testing.T) {
 		t.Error("NewJSONFileClient should create cache directory")
 	}
 }
+
+// textSource is a content.Source + content.TextExtractor whose blocks are
+// JSON strings treated as paragraphs.
+type textSource struct {
+	entries []content.PostEntry
+}
+
+func (s *textSource) GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
+	return nil, nil
+}
+
+func (s *textSource) GetPostEntries(ctx context.Context, collectionID, filter string) ([]content.PostEntry, error) {
+	out := make([]content.PostEntry, len(s.entries))
+	copy(out, s.entries)
+	return out, nil
+}
+
+func (s *textSource) GetReadingEntries(ctx context.Context, collectionID, filter string) ([]content.ReadingEntry, error) {
+	return nil, nil
+}
+
+func (s *textSource) GetDefaultCollectionID() string { return "db" }
+
+func (s *textSource) ProcessBlockForStorage(ctx context.Context, blocks []json.RawMessage, index int) error {
+	return nil
+}
+
+func (s *textSource) ExtractText(rawBlock []byte) (string, bool) {
+	var text string
+	_ = json.Unmarshal(rawBlock, &text)
+	return text, true
+}
+
+func TestFetchAndCachePostEntries_AttachesTextStatsFromCachedBlocks(t *testing.T) {
+	tempDir := t.TempDir()
+	client := NewJSONFileClient(tempDir)
+	source := &textSource{entries: []content.PostEntry{
+		{ID: "cached", Title: "Cached"},
+		{ID: "uncached", Title: "Uncached"},
+		{ID: "described", Title: "Described", Excerpt: "Own description"},
+	}}
+	c := &cache{source: source, jsonClient: client}
+
+	for _, id := range []string{"cached", "described"} {
+		if err := c.cacheData(id, []string{"First paragraph here.", "Then four more words."}); err != nil {
+			t.Fatalf("seed blocks: %v", err)
+		}
+	}
+
+	entries, err := c.fetchAndCachePostEntries(context.Background(), "db", "travel")
+	if err != nil {
+		t.Fatalf("fetchAndCachePostEntries: %v", err)
+	}
+
+	if entries[0].WordCount != 7 || entries[0].ReadingMinutes != 1 {
+		t.Errorf("unexpected stats for cached post: %+v", entries[0])
+	}
+	if entries[0].Excerpt != "First paragraph here." {
+		t.Errorf("unexpected excerpt %q", entries[0].Excerpt)
+	}
+	if entries[1].WordCount != 0 || entries[1].Excerpt != "" {
+		t.Errorf("expected no stats for uncached post, got %+v", entries[1])
+	}
+	if entries[2].Excerpt != "Own description" {
+		t.Errorf("expected source excerpt to win, got %q", entries[2].Excerpt)
+	}
+
+	// Stats are persisted with the cached list.
+	cached, err := client.Get(buildCacheKey("db", "travel"))
+	if err != nil {
+		t.Fatalf("get cached entries: %v", err)
+	}
+	var decoded []content.PostEntry
+	if err := json.Unmarshal(cached.Data, &decoded); err != nil {
+		t.Fatalf("decode cached entries: %v", err)
+	}
+	if decoded[0].WordCount != 7 {
+		t.Errorf("expected cached word count 7, got %d", decoded[0].WordCount)
+	}
+}
+
+func TestGetPostEntries_CountsHitsMissesAndStaleRefreshes(t *testing.T) {
+	c := &cache{source: &textSource{entries: []content.PostEntry{{ID: "a"}}}, jsonClient: NewJSONFileClient(t.TempDir())}
+	misses, hits := cacheLookups.Value(kindPosts, "miss"), cacheLookups.Value(kindPosts, "hit")
+	refreshes := cacheRefreshes.Value(kindPosts, "ok")
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetPostEntries(context.Background(), "db", "travel"); err != nil {
+			t.Fatalf("GetPostEntries: %v", err)
+		}
+	}
+	if got := cacheLookups.Value(kindPosts, "miss") - misses; got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+	if got := cacheLookups.Value(kindPosts, "hit") - hits; got != 1 {
+		t.Errorf("expected 1 hit, got %v", got)
+	}
+
+	// An entry older than the TTL is served, then refreshed in the background.
+	stale := &CacheEntry{Data: json.RawMessage(`[]`), Timestamp: time.Now().Add(-2 * CacheTTL)}
+	if err := c.jsonClient.Set(buildCacheKey("db", "travel"), stale); err != nil {
+		t.Fatalf("seed stale entry: %v", err)
+	}
+	if _, err := c.GetPostEntries(context.Background(), "db", "travel"); err != nil {
+		t.Fatalf("GetPostEntries: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for cacheRefreshes.Value(kindPosts, "ok")-refreshes != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a stale refresh to be counted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGetBlockChildren_TracesMissThroughFetch(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+	c := &cache{source: &textSource{}, jsonClient: NewJSONFileClient(t.TempDir())}
+
+	ctx, request := tracing.Start(context.Background(), "request")
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetBlockChildren(ctx, "page"); err != nil {
+			t.Fatalf("GetBlockChildren: %v", err)
+		}
+	}
+	request.End()
+
+	var root tracetest.SpanStub
+	var lookups, fetches, processing []tracetest.SpanStub
+	for _, s := range exporter.GetSpans() {
+		switch s.Name {
+		case "request":
+			root = s
+		case "cache.GetBlockChildren":
+			lookups = append(lookups, s)
+		case "cache.fetch":
+			fetches = append(fetches, s)
+		case "cache.process_blocks":
+			processing = append(processing, s)
+		}
+	}
+	if len(lookups) != 2 || len(fetches) != 1 || len(processing) != 1 {
+		t.Fatalf("expected 2 lookups, 1 fetch and 1 processing span, got %+v", exporter.GetSpans())
+	}
+	miss := lookups[0]
+	if miss.Parent.SpanID() != root.SpanContext.SpanID() {
+		t.Errorf("lookup not a child of the request span")
+	}
+	if fetches[0].Parent.SpanID() != miss.SpanContext.SpanID() || processing[0].Parent.SpanID() != fetches[0].SpanContext.SpanID() {
+		t.Errorf("fetch spans not nested under the miss")
+	}
+	for i, want := range []string{"miss", "hit"} {
+		if !slices.Contains(lookups[i].Attributes, attribute.String("cache.result", want)) {
+			t.Errorf("lookup %d: want cache.result=%s in %+v", i, want, lookups[i].Attributes)
+		}
+	}
+}
@@ -6,26 +6,35 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"time"
 )
 
 // PostEntry represents a blog post entry from any content source
 type PostEntry struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	CreatedTime string `json:"created_time"`
-	Slug        string `json:"slug"`
-	PostType    string `json:"post_type,omitempty"`
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	CreatedTime time.Time `json:"created_time"`
+	UpdatedTime time.Time `json:"updated_time"`
+	Slug        string    `json:"slug"`
+	PostType    string    `json:"post_type,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	// Excerpt is a plain-text summary: the source's own description when it
+	// has one, otherwise the first paragraph of the cached post body.
+	Excerpt        string `json:"excerpt,omitempty"`
+	WordCount      int    `json:"word_count,omitempty"`
+	ReadingMinutes int    `json:"reading_minutes,omitempty"`
 }
 
 // ReadingEntry represents a reading/book entry from any content source
 type ReadingEntry struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	CreatedTime string `json:"created_time"`
-	Image       string `json:"image,omitempty"`
-	Comment     string `json:"comment,omitempty"`
-	Progress    string `json:"progress,omitempty"`
-	Author      string `json:"author,omitempty"`
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	CreatedTime time.Time `json:"created_time"`
+	UpdatedTime time.Time `json:"updated_time"`
+	Image       string    `json:"image,omitempty"`
+	Comment     string    `json:"comment,omitempty"`
+	Progress    string    `json:"progress,omitempty"`
+	Author      string    `json:"author,omitempty"`
 }
 
 // Source is the interface that any content data source must implement.
@@ -46,7 +55,8 @@ type Source interface {
 
 	// ProcessBlockForStorage allows the source to transform blocks before caching.
 	// For example, downloading and storing images locally.
-	ProcessBlockForStorage(blocks []json.RawMessage, index int) error
+	// ctx bounds any downloads; cancelling it abandons them.
+	ProcessBlockForStorage(ctx context.Context, blocks []json.RawMessage, index int) error
 }
 
 // BlockRenderer handles rendering of raw blocks to HTML.
@@ -65,6 +75,9 @@ type BlockFetcher interface {
 // RenderOptions holds options when rendering a single page (e.g. post type).
 type RenderOptions struct {
 	PostType string
+	// TOC requests a generated table of contents. Only honoured when the
+	// BlockRenderer also implements OutlineRenderer.
+	TOC TOCPlacement
 }
 
 // PageRenderer is the interface handlers use to render a full page to HTML.
@@ -3,6 +3,7 @@ package content
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -11,7 +12,7 @@ func Test_PostEntry_JSONSerialization(t *testing.T) {
 	entry := PostEntry{
 		ID:          "123",
 		Title:       "Test Post",
-		CreatedTime: "2024-01-01T00:00:00Z",
+		CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 		Slug:        "test-post",
 	}
 
@@ -31,7 +32,7 @@ func Test_ReadingEntry_JSONSerialization(t *testing.T) {
 	entry := ReadingEntry{
 		ID:          "456",
 		Title:       "Test Book",
-		CreatedTime: "2024-01-01T00:00:00Z",
+		CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 		Image:       "https://example.com/image.jpg",
 		Comment:     "Great book!",
 		Progress:    "75",
@@ -54,7 +55,7 @@ func Test_ReadingEntry_OmitsEmptyFields(t *testing.T) {
 	entry := ReadingEntry{
 		ID:          "789",
 		Title:       "Minimal Entry",
-		CreatedTime: "2024-01-01",
+		CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
 
 	data, err := json.Marshal(entry)
@@ -72,7 +73,7 @@ func Test_PostEntry_EmptySlug(t *testing.T) {
 	entry := PostEntry{
 		ID:          "123",
 		Title:       "No Slug Post",
-		CreatedTime: "2024-01-01",
+		CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 		Slug:        "",
 	}
 
@@ -0,0 +1,112 @@
+package content
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortOrder is a post list ordering.
+type SortOrder string
+
+const (
+	SortNewest SortOrder = "newest"
+	SortOldest SortOrder = "oldest"
+	SortTitle  SortOrder = "title"
+)
+
+// ParseSortOrder maps a query value to a SortOrder, defaulting to SortNewest.
+func ParseSortOrder(s string) SortOrder {
+	switch SortOrder(strings.ToLower(strings.TrimSpace(s))) {
+	case SortOldest:
+		return SortOldest
+	case SortTitle:
+		return SortTitle
+	}
+	return SortNewest
+}
+
+// SortPosts returns a sorted copy of entries. Undated entries sort after
+// dated ones for newest/oldest; ties keep source order.
+func SortPosts(entries []PostEntry, order SortOrder) []PostEntry {
+	sorted := make([]PostEntry, len(entries))
+	copy(sorted, entries)
+
+	switch order {
+	case SortTitle:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return strings.ToLower(sorted[i].Title) < strings.ToLower(sorted[j].Title)
+		})
+	default:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			ti, tj := sorted[i].CreatedTime, sorted[j].CreatedTime
+			if ti.IsZero() != tj.IsZero() {
+				return !ti.IsZero()
+			}
+			if order == SortOldest {
+				return ti.Before(tj)
+			}
+			return ti.After(tj)
+		})
+	}
+	return sorted
+}
+
+// FilterPostsByDate keeps entries created within [from, to]. A zero bound is
+// open-ended. With any bound set, undated entries are dropped.
+func FilterPostsByDate(entries []PostEntry, from, to time.Time) []PostEntry {
+	if from.IsZero() && to.IsZero() {
+		return entries
+	}
+	filtered := make([]PostEntry, 0, len(entries))
+	for _, e := range entries {
+		t := e.CreatedTime
+		if t.IsZero() {
+			continue
+		}
+		if !from.IsZero() && t.Before(from) {
+			continue
+		}
+		if !to.IsZero() && t.After(to) {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
+
+// ArchiveMonth groups posts created in one calendar month.
+type ArchiveMonth struct {
+	Month time.Month
+	Posts []PostEntry
+}
+
+// ArchiveYear groups posts created in one year, by month.
+type ArchiveYear struct {
+	Year   int
+	Months []ArchiveMonth
+	Count  int
+}
+
+// GroupByMonth buckets entries into years and months of loc, newest first,
+// with posts newest first inside each month. Undated entries are left out.
+func GroupByMonth(entries []PostEntry, loc *time.Location) []ArchiveYear {
+	var years []ArchiveYear
+	for _, e := range SortPosts(entries, SortNewest) {
+		if e.CreatedTime.IsZero() {
+			continue
+		}
+		t := e.CreatedTime.In(loc)
+		if len(years) == 0 || years[len(years)-1].Year != t.Year() {
+			years = append(years, ArchiveYear{Year: t.Year()})
+		}
+		year := &years[len(years)-1]
+		if len(year.Months) == 0 || year.Months[len(year.Months)-1].Month != t.Month() {
+			year.Months = append(year.Months, ArchiveMonth{Month: t.Month()})
+		}
+		month := &year.Months[len(year.Months)-1]
+		month.Posts = append(month.Posts, e)
+		year.Count++
+	}
+	return years
+}
@@ -0,0 +1,82 @@
+package content
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listingFixture() []PostEntry {
+	return []PostEntry{
+		{ID: "b", Title: "banana", CreatedTime: time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC)},
+		{ID: "undated", Title: "Undated"},
+		{ID: "a", Title: "Apple", CreatedTime: time.Date(2023, 1, 15, 18, 30, 0, 0, time.UTC)},
+		{ID: "c", Title: "cherry", CreatedTime: time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)},
+		{ID: "d", Title: "Date", CreatedTime: time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC)},
+	}
+}
+
+func ids(entries []PostEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func Test_ParseSortOrder(t *testing.T) {
+	assert.Equal(t, SortOldest, ParseSortOrder("oldest"))
+	assert.Equal(t, SortTitle, ParseSortOrder("TITLE"))
+	assert.Equal(t, SortNewest, ParseSortOrder(""))
+	assert.Equal(t, SortNewest, ParseSortOrder("random"))
+}
+
+func Test_SortPosts(t *testing.T) {
+	entries := listingFixture()
+
+	assert.Equal(t, []string{"c", "b", "d", "a", "undated"}, ids(SortPosts(entries, SortNewest)))
+	assert.Equal(t, []string{"a", "d", "b", "c", "undated"}, ids(SortPosts(entries, SortOldest)))
+	assert.Equal(t, []string{"a", "b", "c", "d", "undated"}, ids(SortPosts(entries, SortTitle)))
+
+	// Input is not mutated.
+	assert.Equal(t, "b", entries[0].ID)
+}
+
+func Test_FilterPostsByDate(t *testing.T) {
+	entries := listingFixture()
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"b", "c"}, ids(FilterPostsByDate(entries, from, time.Time{})))
+
+	to := time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC)
+	assert.Equal(t, []string{"a", "d"}, ids(FilterPostsByDate(entries, time.Time{}, to)))
+
+	assert.Len(t, FilterPostsByDate(entries, time.Time{}, time.Time{}), len(entries))
+}
+
+func Test_GroupByMonth(t *testing.T) {
+	years := GroupByMonth(listingFixture(), time.UTC)
+
+	assert.Len(t, years, 2)
+	assert.Equal(t, 2024, years[0].Year)
+	assert.Equal(t, 2, years[0].Count)
+	assert.Len(t, years[0].Months, 1)
+	assert.Equal(t, time.March, years[0].Months[0].Month)
+	assert.Equal(t, []string{"c", "b"}, ids(years[0].Months[0].Posts))
+
+	assert.Equal(t, 2023, years[1].Year)
+	assert.Equal(t, time.December, years[1].Months[0].Month)
+	assert.Equal(t, time.January, years[1].Months[1].Month)
+}
+
+func Test_GroupByMonth_UsesLocation(t *testing.T) {
+	// 23:59 UTC on New Year's Eve is already January in Singapore.
+	singapore := time.FixedZone("SGT", 8*60*60)
+	years := GroupByMonth([]PostEntry{
+		{ID: "nye", CreatedTime: time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC)},
+	}, singapore)
+
+	assert.Equal(t, 2024, years[0].Year)
+	assert.Equal(t, time.January, years[0].Months[0].Month)
+}
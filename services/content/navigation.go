@@ -0,0 +1,183 @@
+package content
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultRelatedLimit is how many related posts a post page shows.
+const DefaultRelatedLimit = 3
+
+// PostNavigation links a post to its neighbours in an ordered list and to
+// other posts that look related to it.
+type PostNavigation struct {
+	// Prev is the post immediately before the current one in list order
+	// (the newer post when the list is sorted newest first). Nil at the start.
+	Prev *PostEntry
+	// Next is the post immediately after the current one in list order.
+	// Nil at the end.
+	Next *PostEntry
+	// Related holds up to the requested number of posts ranked by shared tags
+	// and text similarity. Prev/Next are never repeated here.
+	Related []PostEntry
+}
+
+// BuildNavigation locates the entry with the given ID in entries (which must
+// already be in display order, as returned by GetPostEntries) and computes
+// its previous/next neighbours and up to relatedLimit related posts from the
+// same list. Returns a zero PostNavigation if id is not in entries.
+func BuildNavigation(entries []PostEntry, id string, relatedLimit int) PostNavigation {
+	idx := -1
+	for i := range entries {
+		if entries[i].ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return PostNavigation{}
+	}
+
+	var nav PostNavigation
+	if idx > 0 {
+		prev := entries[idx-1]
+		nav.Prev = &prev
+	}
+	if idx < len(entries)-1 {
+		next := entries[idx+1]
+		nav.Next = &next
+	}
+	nav.Related = relatedPosts(entries, idx, relatedLimit)
+	return nav
+}
+
+// relatedPosts ranks every other entry against entries[idx]. Tags shared by
+// every entry in the list (typically the filter tag itself) carry no signal
+// and are ignored; each remaining shared tag outweighs text similarity so
+// curated tags win over incidental word overlap. Text similarity compares
+// the words of each post's title and excerpt, which holds the opening
+// paragraph once the post's blocks are cached (see TextStats).
+func relatedPosts(entries []PostEntry, idx, limit int) []PostEntry {
+	if limit <= 0 {
+		return nil
+	}
+
+	tagFreq := make(map[string]int)
+	for _, e := range entries {
+		for _, tag := range uniqueFold(e.Tags) {
+			tagFreq[tag]++
+		}
+	}
+
+	current := entries[idx]
+	currentTags := make(map[string]bool)
+	for _, tag := range uniqueFold(current.Tags) {
+		if tagFreq[tag] < len(entries) {
+			currentTags[tag] = true
+		}
+	}
+	words := make([]map[string]bool, len(entries))
+	for i, e := range entries {
+		words[i] = postWords(e)
+	}
+
+	type scored struct {
+		pos   int
+		score float64
+	}
+	var candidates []scored
+	for i, e := range entries {
+		if i == idx || i == idx-1 || i == idx+1 {
+			continue
+		}
+		shared := 0
+		for _, tag := range uniqueFold(e.Tags) {
+			if currentTags[tag] {
+				shared++
+			}
+		}
+		score := float64(shared) + jaccard(words[idx], words[i])
+		if score > 0 {
+			candidates = append(candidates, scored{pos: i, score: score})
+		}
+	}
+
+	// Stable so ties keep list order (i.e. recency).
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].score > candidates[b].score
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	related := make([]PostEntry, 0, len(candidates))
+	for _, c := range candidates {
+		related = append(related, entries[c.pos])
+	}
+	return related
+}
+
+// stopWords are dropped before comparing text so "the" and "of" don't make
+// unrelated posts look similar.
+var stopWords = map[string]bool{
+	"a": true, "about": true, "all": true, "also": true, "an": true, "and": true,
+	"are": true, "as": true, "at": true, "be": true, "been": true, "but": true,
+	"by": true, "can": true, "do": true, "for": true, "from": true, "had": true,
+	"has": true, "have": true, "how": true, "i": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "its": true, "just": true, "me": true,
+	"my": true, "not": true, "of": true, "on": true, "one": true, "or": true,
+	"our": true, "so": true, "that": true, "the": true, "their": true,
+	"there": true, "this": true, "to": true, "up": true, "was": true, "we": true,
+	"were": true, "what": true, "when": true, "which": true, "who": true,
+	"why": true, "will": true, "with": true, "you": true, "your": true,
+}
+
+// postWords returns the words of e's title and excerpt, as textWords.
+func postWords(e PostEntry) map[string]bool {
+	return textWords(e.Title + " " + e.Excerpt)
+}
+
+// textWords returns the set of lowercased, non-stop-word tokens in text.
+func textWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) < 2 || stopWords[w] {
+			continue
+		}
+		words[w] = true
+	}
+	return words
+}
+
+// jaccard returns |a∩b| / |a∪b|, or 0 when both sets are empty.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	inter := 0
+	for w := range a {
+		if b[w] {
+			inter++
+		}
+	}
+	union := len(a) + len(b) - inter
+	return float64(inter) / float64(union)
+}
+
+// uniqueFold lowercases tags and drops duplicates so "Go" and "go" count once.
+func uniqueFold(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		t := strings.ToLower(strings.TrimSpace(tag))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
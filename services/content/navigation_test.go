@@ -0,0 +1,101 @@
+package content
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func navFixture() []PostEntry {
+	return []PostEntry{
+		{ID: "1", Title: "Hiking in Japan", Slug: "hiking-japan", Tags: []string{"travel", "japan", "hiking"}},
+		{ID: "2", Title: "Tokyo food diary", Slug: "tokyo-food", Tags: []string{"travel", "japan"}},
+		{ID: "3", Title: "A week in Lisbon", Slug: "lisbon", Tags: []string{"travel"}},
+		{ID: "4", Title: "Lisbon trams", Slug: "lisbon-trams", Tags: []string{"travel"}},
+		{ID: "5", Title: "Hiking Mount Fuji in Japan", Slug: "fuji", Tags: []string{"travel", "Japan", "hiking"}},
+		{ID: "6", Title: "Packing light", Slug: "packing", Tags: []string{"travel"}},
+	}
+}
+
+func Test_BuildNavigation_PrevNext(t *testing.T) {
+	entries := navFixture()
+
+	nav := BuildNavigation(entries, "3", DefaultRelatedLimit)
+
+	assert.NotNil(t, nav.Prev)
+	assert.Equal(t, "2", nav.Prev.ID)
+	assert.NotNil(t, nav.Next)
+	assert.Equal(t, "4", nav.Next.ID)
+}
+
+func Test_BuildNavigation_Edges(t *testing.T) {
+	entries := navFixture()
+
+	first := BuildNavigation(entries, "1", DefaultRelatedLimit)
+	assert.Nil(t, first.Prev)
+	assert.Equal(t, "2", first.Next.ID)
+
+	last := BuildNavigation(entries, "6", DefaultRelatedLimit)
+	assert.Equal(t, "5", last.Prev.ID)
+	assert.Nil(t, last.Next)
+}
+
+func Test_BuildNavigation_UnknownID(t *testing.T) {
+	nav := BuildNavigation(navFixture(), "missing", DefaultRelatedLimit)
+
+	assert.Nil(t, nav.Prev)
+	assert.Nil(t, nav.Next)
+	assert.Empty(t, nav.Related)
+}
+
+func Test_BuildNavigation_RelatedPrefersSharedTags(t *testing.T) {
+	entries := navFixture()
+
+	nav := BuildNavigation(entries, "1", DefaultRelatedLimit)
+
+	// "travel" is on every entry so it is ignored; Fuji shares japan+hiking
+	// and title words. Post 2 is Next, so it must not be repeated.
+	assert.NotEmpty(t, nav.Related)
+	assert.Equal(t, "5", nav.Related[0].ID)
+	for _, r := range nav.Related {
+		assert.NotEqual(t, "1", r.ID)
+		assert.NotEqual(t, "2", r.ID)
+	}
+}
+
+func Test_BuildNavigation_RelatedByTextSimilarity(t *testing.T) {
+	entries := navFixture()
+
+	nav := BuildNavigation(entries, "6", DefaultRelatedLimit)
+
+	// "Packing light" shares no non-trivial tags or title words with anything.
+	assert.Empty(t, nav.Related)
+
+	nav = BuildNavigation(entries, "3", DefaultRelatedLimit)
+	// Lisbon trams is Next, so it's excluded despite the title overlap.
+	for _, r := range nav.Related {
+		assert.NotEqual(t, "4", r.ID)
+	}
+}
+
+func Test_BuildNavigation_RelatedByExcerpt(t *testing.T) {
+	entries := navFixture()
+	entries[5].Excerpt = "Everything I carried for two weeks of hills in Lisbon."
+	entries[2].Excerpt = "Pastries, fado and far too many hills."
+
+	nav := BuildNavigation(entries, "6", DefaultRelatedLimit)
+
+	// The titles share nothing; the excerpts both talk about Lisbon's hills.
+	assert.NotEmpty(t, nav.Related)
+	assert.Equal(t, "3", nav.Related[0].ID)
+}
+
+func Test_BuildNavigation_RespectsLimit(t *testing.T) {
+	entries := navFixture()
+
+	nav := BuildNavigation(entries, "1", 1)
+	assert.Len(t, nav.Related, 1)
+
+	nav = BuildNavigation(entries, "1", 0)
+	assert.Empty(t, nav.Related)
+}
@@ -0,0 +1,123 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// TOCPlacement controls where RenderPage emits a table of contents.
+type TOCPlacement string
+
+const (
+	// TOCNone emits no generated table of contents. A source-provided
+	// table-of-contents block is still rendered where it appears.
+	TOCNone TOCPlacement = ""
+	// TOCTop emits the table of contents before the first block.
+	TOCTop TOCPlacement = "top"
+	// TOCSidebar emits the table of contents as a sidebar next to the content.
+	TOCSidebar TOCPlacement = "sidebar"
+	// TOCInline is used for a table of contents placed by the source itself
+	// (e.g. Notion's table_of_contents block) at that block's position.
+	TOCInline TOCPlacement = "inline"
+)
+
+// ParseTOCPlacement maps a query value to a placement, ignoring unknown values.
+func ParseTOCPlacement(s string) TOCPlacement {
+	switch TOCPlacement(strings.ToLower(strings.TrimSpace(s))) {
+	case TOCTop:
+		return TOCTop
+	case TOCSidebar:
+		return TOCSidebar
+	}
+	return TOCNone
+}
+
+// Heading is a section heading collected while rendering a page.
+type Heading struct {
+	Level int
+	Text  string
+	// ID is the page-unique anchor assigned to the heading.
+	ID string
+}
+
+// OutlineRenderer is an optional extension of BlockRenderer for backends that
+// can identify headings and render them with a caller-assigned anchor ID.
+// blockPageRenderer uses it to build heading anchors and tables of contents;
+// renderers that don't implement it are rendered block-by-block as before.
+type OutlineRenderer interface {
+	// HeadingOf reports whether rawBlock is a heading, with its level (1-3)
+	// and plain text.
+	HeadingOf(rawBlock []byte) (level int, text string, ok bool)
+	// IsTableOfContents reports whether rawBlock asks for a table of contents
+	// at its position.
+	IsTableOfContents(rawBlock []byte) bool
+	// RenderHeading writes a heading block with anchorID as its element ID.
+	RenderHeading(writer io.Writer, rawBlock []byte, postType, anchorID string) error
+	// RenderTableOfContents writes a table of contents linking to headings.
+	RenderTableOfContents(writer io.Writer, headings []Heading, placement TOCPlacement) error
+}
+
+// anchorSet hands out unique, URL-safe anchor IDs for heading text. The first
+// "Intro" gets "intro", the next "intro-1", and so on, so IDs stay stable as
+// long as the headings before them don't change.
+type anchorSet struct {
+	used map[string]bool
+}
+
+func newAnchorSet() *anchorSet {
+	return &anchorSet{used: make(map[string]bool)}
+}
+
+// Assign returns a unique anchor for text.
+func (a *anchorSet) Assign(text string) string {
+	base := slugify(text)
+	if base == "" {
+		base = "section"
+	}
+	id := base
+	for n := 1; a.used[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	a.used[id] = true
+	return id
+}
+
+// slugify lowercases text, keeps letters and digits, and collapses everything
+// else into single hyphens.
+func slugify(text string) string {
+	var b strings.Builder
+	pendingHyphen := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		pendingHyphen = true
+	}
+	return b.String()
+}
+
+// collectHeadings walks blocks once and assigns anchors in document order.
+// The returned map is keyed by block index.
+func collectHeadings(outliner OutlineRenderer, blocks []json.RawMessage) ([]Heading, map[int]string) {
+	anchors := newAnchorSet()
+	var headings []Heading
+	ids := make(map[int]string)
+	for i, raw := range blocks {
+		level, text, ok := outliner.HeadingOf(raw)
+		if !ok {
+			continue
+		}
+		id := anchors.Assign(text)
+		ids[i] = id
+		headings = append(headings, Heading{Level: level, Text: text, ID: id})
+	}
+	return headings, ids
+}
@@ -0,0 +1,154 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Slugify(t *testing.T) {
+	assert.Equal(t, "getting-started", slugify("Getting Started"))
+	assert.Equal(t, "what-s-new-in-go-1-22", slugify("What's new in Go 1.22?"))
+	assert.Equal(t, "café-au-lait", slugify("  Café au lait!  "))
+	assert.Equal(t, "", slugify("!!!"))
+}
+
+func Test_AnchorSet_HandlesCollisions(t *testing.T) {
+	anchors := newAnchorSet()
+
+	assert.Equal(t, "intro", anchors.Assign("Intro"))
+	assert.Equal(t, "intro-1", anchors.Assign("Intro"))
+	assert.Equal(t, "intro-2", anchors.Assign("intro"))
+	assert.Equal(t, "section", anchors.Assign("???"))
+	assert.Equal(t, "section-1", anchors.Assign(""))
+}
+
+func Test_ParseTOCPlacement(t *testing.T) {
+	assert.Equal(t, TOCTop, ParseTOCPlacement("top"))
+	assert.Equal(t, TOCSidebar, ParseTOCPlacement(" Sidebar "))
+	assert.Equal(t, TOCNone, ParseTOCPlacement("inline"))
+	assert.Equal(t, TOCNone, ParseTOCPlacement(""))
+}
+
+// outlineFetcher returns a fixed block list.
+type outlineFetcher struct {
+	blocks []json.RawMessage
+}
+
+func (f *outlineFetcher) GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
+	return f.blocks, nil
+}
+
+// fakeOutliner treats {"h":N,"text":...} as headings and {"toc":true} as a
+// table-of-contents block; everything else renders as <p>.
+type fakeOutliner struct{}
+
+type fakeBlock struct {
+	H    int    `json:"h"`
+	Text string `json:"text"`
+	TOC  bool   `json:"toc"`
+}
+
+func parseFake(raw []byte) fakeBlock {
+	var b fakeBlock
+	_ = json.Unmarshal(raw, &b)
+	return b
+}
+
+func (fakeOutliner) RenderBlock(w io.Writer, raw []byte, postType string) error {
+	_, err := fmt.Fprintf(w, "<p>%s</p>", parseFake(raw).Text)
+	return err
+}
+
+func (fakeOutliner) HeadingOf(raw []byte) (int, string, bool) {
+	b := parseFake(raw)
+	return b.H, b.Text, b.H > 0
+}
+
+func (fakeOutliner) IsTableOfContents(raw []byte) bool {
+	return parseFake(raw).TOC
+}
+
+func (fakeOutliner) RenderHeading(w io.Writer, raw []byte, postType, anchorID string) error {
+	b := parseFake(raw)
+	_, err := fmt.Fprintf(w, `<h%d id="%s">%s</h%d>`, b.H, anchorID, b.Text, b.H)
+	return err
+}
+
+func (fakeOutliner) RenderTableOfContents(w io.Writer, headings []Heading, placement TOCPlacement) error {
+	ids := make([]string, len(headings))
+	for i, h := range headings {
+		ids[i] = h.ID
+	}
+	_, err := fmt.Fprintf(w, "<toc %s>%s</toc>", placement, strings.Join(ids, ","))
+	return err
+}
+
+func outlineBlocks(raw ...string) []json.RawMessage {
+	blocks := make([]json.RawMessage, len(raw))
+	for i, r := range raw {
+		blocks[i] = json.RawMessage(r)
+	}
+	return blocks
+}
+
+func Test_RenderPage_AssignsHeadingAnchors(t *testing.T) {
+	fetcher := &outlineFetcher{blocks: outlineBlocks(
+		`{"h":1,"text":"Intro"}`,
+		`{"text":"body"}`,
+		`{"h":2,"text":"Intro"}`,
+	)}
+	renderer := NewPageRenderer(fetcher, fakeOutliner{})
+
+	var buf bytes.Buffer
+	err := renderer.RenderPage(context.Background(), &buf, "page", RenderOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `<h1 id="intro">Intro</h1><p>body</p><h2 id="intro-1">Intro</h2>`, buf.String())
+}
+
+func Test_RenderPage_TOCAtTop(t *testing.T) {
+	fetcher := &outlineFetcher{blocks: outlineBlocks(
+		`{"h":1,"text":"One"}`,
+		`{"h":2,"text":"Two"}`,
+	)}
+	renderer := NewPageRenderer(fetcher, fakeOutliner{})
+
+	var buf bytes.Buffer
+	err := renderer.RenderPage(context.Background(), &buf, "page", RenderOptions{TOC: TOCTop})
+
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "<toc top>one,two</toc>"))
+}
+
+func Test_RenderPage_SourceTOCBlock(t *testing.T) {
+	fetcher := &outlineFetcher{blocks: outlineBlocks(
+		`{"text":"lede"}`,
+		`{"toc":true}`,
+		`{"h":2,"text":"Two"}`,
+	)}
+	renderer := NewPageRenderer(fetcher, fakeOutliner{})
+
+	var buf bytes.Buffer
+	err := renderer.RenderPage(context.Background(), &buf, "page", RenderOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `<p>lede</p><toc inline>two</toc><h2 id="two">Two</h2>`, buf.String())
+}
+
+func Test_RenderPage_NoHeadingsSkipsTOC(t *testing.T) {
+	fetcher := &outlineFetcher{blocks: outlineBlocks(`{"toc":true}`, `{"text":"only"}`)}
+	renderer := NewPageRenderer(fetcher, fakeOutliner{})
+
+	var buf bytes.Buffer
+	err := renderer.RenderPage(context.Background(), &buf, "page", RenderOptions{TOC: TOCSidebar})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `<p>only</p>`, buf.String())
+}
@@ -2,7 +2,12 @@ package content
 
 import (
 	"context"
+	"encoding/json"
 	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"htmx-blog/tracing"
 )
 
 // blockPageRenderer implements PageRenderer by fetching blocks from a
@@ -25,13 +30,60 @@ func NewPageRenderer(fetcher BlockFetcher, renderer BlockRenderer) PageRenderer
 }
 
 // RenderPage fetches block children for the page and writes their HTML to w.
+// When the renderer implements OutlineRenderer, headings get unique anchor IDs
+// and a table of contents is emitted per opts.TOC and wherever the source
+// placed a table-of-contents block.
 func (p *blockPageRenderer) RenderPage(ctx context.Context, w io.Writer, pageIDOrSlug string, opts RenderOptions) error {
+	ctx, span := tracing.Start(ctx, "content.RenderPage", attribute.String("content.page", pageIDOrSlug))
+	defer span.End()
+
 	blocks, err := p.fetcher.GetBlockChildren(ctx, pageIDOrSlug)
 	if err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	_, renderSpan := tracing.Start(ctx, "content.render_blocks", attribute.Int("blocks", len(blocks)))
+	defer renderSpan.End()
+	if err := p.renderBlocks(w, blocks, opts); err != nil {
+		tracing.RecordError(renderSpan, err)
 		return err
 	}
-	for _, raw := range blocks {
-		if err := p.renderer.RenderBlock(w, raw, opts.PostType); err != nil {
+	return nil
+}
+
+// renderBlocks writes blocks to w in order.
+func (p *blockPageRenderer) renderBlocks(w io.Writer, blocks []json.RawMessage, opts RenderOptions) error {
+	var err error
+	outliner, ok := p.renderer.(OutlineRenderer)
+	if !ok {
+		for _, raw := range blocks {
+			if err := p.renderer.RenderBlock(w, raw, opts.PostType); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	headings, anchorIDs := collectHeadings(outliner, blocks)
+	if opts.TOC != TOCNone && len(headings) > 0 {
+		if err := outliner.RenderTableOfContents(w, headings, opts.TOC); err != nil {
+			return err
+		}
+	}
+	for i, raw := range blocks {
+		switch {
+		case anchorIDs[i] != "":
+			err = outliner.RenderHeading(w, raw, opts.PostType, anchorIDs[i])
+		case outliner.IsTableOfContents(raw):
+			if len(headings) == 0 {
+				continue
+			}
+			err = outliner.RenderTableOfContents(w, headings, TOCInline)
+		default:
+			err = p.renderer.RenderBlock(w, raw, opts.PostType)
+		}
+		if err != nil {
 			return err
 		}
 	}
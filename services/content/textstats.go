@@ -0,0 +1,78 @@
+package content
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// WordsPerMinute is the reading speed used for reading-time estimates.
+const WordsPerMinute = 225
+
+// MaxExcerptLength caps generated excerpts, in characters.
+const MaxExcerptLength = 200
+
+// TextExtractor is an optional extension of Source for backends that can pull
+// plain text out of their raw blocks. The cache uses it to compute word
+// counts, reading time and excerpts for post entries.
+type TextExtractor interface {
+	// ExtractText returns the readable text of rawBlock and whether the block
+	// is body prose (a paragraph) suitable for an excerpt. Blocks with no
+	// readable text (images, code, dividers) return "".
+	ExtractText(rawBlock []byte) (text string, paragraph bool)
+}
+
+// TextStats summarises the readable text of a page.
+type TextStats struct {
+	WordCount      int
+	ReadingMinutes int
+	// Excerpt is the first paragraph, trimmed to MaxExcerptLength.
+	Excerpt string
+}
+
+// ComputeTextStats extracts text from every block and returns its word count,
+// estimated reading time (rounded up, at least one minute for any text) and
+// an excerpt from the first non-empty paragraph.
+func ComputeTextStats(blocks []json.RawMessage, extractor TextExtractor) TextStats {
+	var stats TextStats
+	for _, raw := range blocks {
+		text, paragraph := extractor.ExtractText(raw)
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		stats.WordCount += len(strings.Fields(text))
+		if paragraph && stats.Excerpt == "" {
+			stats.Excerpt = truncateExcerpt(text, MaxExcerptLength)
+		}
+	}
+	if stats.WordCount > 0 {
+		stats.ReadingMinutes = (stats.WordCount + WordsPerMinute - 1) / WordsPerMinute
+	}
+	return stats
+}
+
+// Apply copies stats onto entry. A source-provided excerpt (e.g. a Notion
+// description property) wins over the generated one.
+func (s TextStats) Apply(entry *PostEntry) {
+	entry.WordCount = s.WordCount
+	entry.ReadingMinutes = s.ReadingMinutes
+	if entry.Excerpt == "" {
+		entry.Excerpt = s.Excerpt
+	}
+}
+
+// truncateExcerpt shortens text to at most max characters, cutting at a word
+// boundary and appending an ellipsis when anything was dropped.
+func truncateExcerpt(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	cut := string(runes[:max])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:-") + "…"
+}
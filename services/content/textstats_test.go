@@ -0,0 +1,79 @@
+package content
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// prefixExtractor treats blocks of the form "p:<text>" as paragraphs and
+// "h:<text>" as non-paragraph text.
+type prefixExtractor struct{}
+
+func (prefixExtractor) ExtractText(raw []byte) (string, bool) {
+	var s string
+	_ = json.Unmarshal(raw, &s)
+	switch {
+	case strings.HasPrefix(s, "p:"):
+		return s[2:], true
+	case strings.HasPrefix(s, "h:"):
+		return s[2:], false
+	}
+	return "", false
+}
+
+func textBlocks(items ...string) []json.RawMessage {
+	blocks := make([]json.RawMessage, len(items))
+	for i, item := range items {
+		blocks[i], _ = json.Marshal(item)
+	}
+	return blocks
+}
+
+func Test_ComputeTextStats(t *testing.T) {
+	stats := ComputeTextStats(textBlocks(
+		"h:A heading first",
+		"img",
+		"p:The first paragraph is the excerpt.",
+		"p:Second one.",
+	), prefixExtractor{})
+
+	assert.Equal(t, 11, stats.WordCount)
+	assert.Equal(t, 1, stats.ReadingMinutes)
+	assert.Equal(t, "The first paragraph is the excerpt.", stats.Excerpt)
+}
+
+func Test_ComputeTextStats_ReadingTimeRoundsUp(t *testing.T) {
+	words := strings.Repeat("word ", WordsPerMinute+1)
+	stats := ComputeTextStats(textBlocks("p:"+words), prefixExtractor{})
+
+	assert.Equal(t, WordsPerMinute+1, stats.WordCount)
+	assert.Equal(t, 2, stats.ReadingMinutes)
+}
+
+func Test_ComputeTextStats_Empty(t *testing.T) {
+	stats := ComputeTextStats(textBlocks("img", "p:   "), prefixExtractor{})
+
+	assert.Equal(t, TextStats{}, stats)
+}
+
+func Test_TruncateExcerpt(t *testing.T) {
+	assert.Equal(t, "short", truncateExcerpt("short", 20))
+	assert.Equal(t, "one two…", truncateExcerpt("one two, three four", 10))
+	assert.Equal(t, "collapse spaces", truncateExcerpt("collapse \n  spaces", 20))
+}
+
+func Test_TextStats_ApplyKeepsSourceExcerpt(t *testing.T) {
+	entry := PostEntry{Excerpt: "From the description"}
+	TextStats{WordCount: 10, ReadingMinutes: 1, Excerpt: "From the body"}.Apply(&entry)
+
+	assert.Equal(t, "From the description", entry.Excerpt)
+	assert.Equal(t, 10, entry.WordCount)
+	assert.Equal(t, 1, entry.ReadingMinutes)
+
+	entry = PostEntry{}
+	TextStats{Excerpt: "From the body"}.Apply(&entry)
+	assert.Equal(t, "From the body", entry.Excerpt)
+}
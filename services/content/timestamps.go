@@ -0,0 +1,78 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// legacyDisplayLayout is the pre-formatted string sources used to store in
+// created_time before entries carried real timestamps. It was always
+// formatted from Notion's UTC timestamps, so it is parsed back as UTC.
+const legacyDisplayLayout = "January 2, 2006 at 15:04"
+
+// entryTime decodes a timestamp that may be RFC 3339 (current format), the
+// legacy display string, empty or null. It lets entries cached before the
+// switch to time.Time keep loading; they are rewritten in RFC 3339 on the
+// next cache refresh.
+type entryTime time.Time
+
+func (t *entryTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = entryTime{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		*t = entryTime{}
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, legacyDisplayLayout} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			*t = entryTime(parsed)
+			return nil
+		}
+	}
+	// Unparseable legacy values (e.g. a source that failed to parse its own
+	// time) were kept as-is before; treat them as undated rather than
+	// failing the whole cached list.
+	*t = entryTime{}
+	return nil
+}
+
+// UnmarshalJSON accepts both current and legacy created/updated time formats.
+func (e *PostEntry) UnmarshalJSON(data []byte) error {
+	type plain PostEntry
+	aux := struct {
+		*plain
+		CreatedTime entryTime `json:"created_time"`
+		UpdatedTime entryTime `json:"updated_time"`
+	}{plain: (*plain)(e)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return fmt.Errorf("decode post entry: %w", err)
+	}
+	e.CreatedTime = time.Time(aux.CreatedTime)
+	e.UpdatedTime = time.Time(aux.UpdatedTime)
+	return nil
+}
+
+// UnmarshalJSON accepts both current and legacy created/updated time formats.
+func (e *ReadingEntry) UnmarshalJSON(data []byte) error {
+	type plain ReadingEntry
+	aux := struct {
+		*plain
+		CreatedTime entryTime `json:"created_time"`
+		UpdatedTime entryTime `json:"updated_time"`
+	}{plain: (*plain)(e)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return fmt.Errorf("decode reading entry: %w", err)
+	}
+	e.CreatedTime = time.Time(aux.CreatedTime)
+	e.UpdatedTime = time.Time(aux.UpdatedTime)
+	return nil
+}
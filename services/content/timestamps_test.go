@@ -0,0 +1,48 @@
+package content
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PostEntry_DecodesLegacyCreatedTime(t *testing.T) {
+	var entry PostEntry
+	err := json.Unmarshal([]byte(`{"id":"1","title":"Old","created_time":"March 3, 2024 at 09:15","slug":"old"}`), &entry)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Old", entry.Title)
+	assert.Equal(t, "old", entry.Slug)
+	assert.True(t, entry.CreatedTime.Equal(time.Date(2024, 3, 3, 9, 15, 0, 0, time.UTC)))
+	assert.True(t, entry.UpdatedTime.IsZero())
+}
+
+func Test_PostEntry_DecodesRFC3339(t *testing.T) {
+	var entry PostEntry
+	err := json.Unmarshal([]byte(`{"id":"1","created_time":"2024-03-03T09:15:00Z","updated_time":"2024-03-04T10:00:00.000Z"}`), &entry)
+
+	assert.NoError(t, err)
+	assert.True(t, entry.CreatedTime.Equal(time.Date(2024, 3, 3, 9, 15, 0, 0, time.UTC)))
+	assert.True(t, entry.UpdatedTime.Equal(time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)))
+}
+
+func Test_PostEntry_UnparseableLegacyTimeIsUndated(t *testing.T) {
+	var entries []PostEntry
+	err := json.Unmarshal([]byte(`[{"id":"1","created_time":"test"},{"id":"2","created_time":null}]`), &entries)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.True(t, entries[0].CreatedTime.IsZero())
+	assert.True(t, entries[1].CreatedTime.IsZero())
+}
+
+func Test_ReadingEntry_DecodesLegacyCreatedTime(t *testing.T) {
+	var entry ReadingEntry
+	err := json.Unmarshal([]byte(`{"id":"1","title":"Book","created_time":"January 2, 2006 at 15:04","author":"A"}`), &entry)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "A", entry.Author)
+	assert.True(t, entry.CreatedTime.Equal(time.Date(2006, 1, 2, 15, 4, 0, 0, time.UTC)))
+}
@@ -0,0 +1,494 @@
+package notion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "htmx-blog/logging"
+	"htmx-blog/services/notion/imageenc"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OrphanGracePeriod protects recently written images from deletion: an image
+// is stored before the blocks referencing it are written to the cache.
+const OrphanGracePeriod = 24 * time.Hour
+
+// AuditOptions controls AuditImages.
+type AuditOptions struct {
+	// DeleteOrphans removes every file of an unreferenced image. Without it
+	// orphans are only reported.
+	DeleteOrphans bool
+	// RecreateSidecars rebuilds missing or unreadable sidecars from the
+	// disk. Without it they are only reported.
+	RecreateSidecars bool
+}
+
+// AuditReport is the outcome of AuditImages. Entries are file names under
+// ./images, except Orphans and Referenced which count image IDs.
+type AuditReport struct {
+	CacheFiles int
+	Referenced int
+	Images     int
+	// Orphans are image IDs no cached block or reading entry refers to.
+	Orphans []string
+	// Deleted lists the files removed (DeleteOrphans only).
+	Deleted []string
+	// MissingSidecars lists the .meta.json files that are missing or
+	// unreadable (without RecreateSidecars).
+	MissingSidecars []string
+	// SidecarsRecreated lists the .meta.json files rebuilt from disk.
+	SidecarsRecreated []string
+	// Corrupt lists zero-byte or undecodable files.
+	Corrupt []string
+	// Mismatched lists sidecars that disagree with the files on disk.
+	Mismatched []string
+	// MissingOriginals lists sidecars/derived files whose original is gone.
+	MissingOriginals []string
+	// StaleRefs lists refs/<id>.json files for IDs nothing refers to any
+	// more (deleted with DeleteOrphans, after the grace period).
+	StaleRefs []string
+}
+
+// imageGroup is every file on disk belonging to one image ID.
+type imageGroup struct {
+	original string
+	sidecar  string
+	derived  []string
+	modTime  time.Time
+}
+
+// AuditImages cross-references ./images with the image IDs referenced in
+// the JSON cache under cacheDir, recreates missing sidecars, flags corrupt
+// files and sidecars that don't match the disk, and reports (optionally
+// deletes) orphaned images.
+//
+// Deletion is refused when the cache holds no references at all, so a cold
+// or misconfigured cache can't wipe the image store, and while a backfill
+// is running, since it moves images to new digests and repoints their refs.
+// Each image is checked, repaired and deleted under its digest lock.
+func AuditImages(cacheDir string, opts AuditOptions) (AuditReport, error) {
+	var report AuditReport
+	if status, ok := LastBackfill(); opts.DeleteOrphans && ok && status.State == BackfillRunning {
+		return report, fmt.Errorf("image audit: backfill %s is running, refusing to delete orphans", status.ID)
+	}
+	refs, cacheFiles, err := referencedImageIDs(cacheDir)
+	if err != nil {
+		return report, err
+	}
+	report.CacheFiles, report.Referenced = cacheFiles, len(refs)
+	if opts.DeleteOrphans && len(refs) == 0 {
+		return report, errors.New("image audit: no image references found in cache, refusing to delete orphans")
+	}
+
+	dir, err := imagesDir()
+	if err != nil {
+		return report, err
+	}
+	// Cached blocks refer to images by block ID; content-addressed files
+	// are named by digest, so follow the refs. Refs of IDs no longer in the
+	// cache keep nothing alive.
+	if err := auditRefs(refs, opts, &report); err != nil {
+		return report, err
+	}
+	groups, err := groupImageFiles(dir)
+	if err != nil {
+		return report, err
+	}
+	report.Images = len(groups)
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		auditGroup(dir, id, groups[id], refs[id], opts, &report)
+	}
+	return report, nil
+}
+
+// auditGroup checks the files of image id and deletes them if the image is
+// an orphan past the grace period, holding id's digest lock so a backfill
+// or download working on the same image can't interleave.
+func auditGroup(dir, id string, g *imageGroup, referenced bool, opts AuditOptions, report *AuditReport) {
+	unlock := lockDigest(id)
+	defer unlock()
+
+	if g.original == "" {
+		report.MissingOriginals = append(report.MissingOriginals, g.files()...)
+	} else {
+		auditOriginal(dir, id, g, opts, report)
+	}
+	for _, name := range g.derived {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.Size() == 0 {
+			report.Corrupt = append(report.Corrupt, name)
+		}
+	}
+
+	if referenced {
+		return
+	}
+	report.Orphans = append(report.Orphans, id)
+	if !opts.DeleteOrphans {
+		return
+	}
+	// Files may have been rewritten or removed since they were listed.
+	if latest := g.latestModTime(dir); latest.IsZero() || time.Since(latest) < OrphanGracePeriod {
+		return
+	}
+	for _, name := range g.files() {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			log.Error("image audit: could not delete %s: %v", name, err)
+			continue
+		}
+		if !strings.HasSuffix(name, ".meta.json") {
+			if err := unpublishImageFile(name); err != nil {
+				log.Error("image audit: could not delete %s from the image store: %v", name, err)
+			}
+		}
+		report.Deleted = append(report.Deleted, name)
+	}
+	// On-demand transforms of the image (see TransformImage).
+	cached, _ := filepath.Glob(filepath.Join(dir, "cache", id+"-w*"))
+	for _, path := range cached {
+		if err := os.Remove(path); err != nil {
+			log.Error("image audit: could not delete %s: %v", path, err)
+			continue
+		}
+		report.Deleted = append(report.Deleted, filepath.Join("cache", filepath.Base(path)))
+	}
+}
+
+// auditRefs adds the digest of every referenced ID's ref to refs and
+// reports (optionally deletes) refs whose ID is no longer referenced.
+func auditRefs(refs map[string]bool, opts AuditOptions, report *AuditReport) error {
+	refsDir, err := imageRefsDir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(refsDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		if refs[id] {
+			if ref, ok := readImageRef(id); ok {
+				refs[ref.Digest] = true
+			}
+			continue
+		}
+		report.StaleRefs = append(report.StaleRefs, e.Name())
+		if opts.DeleteOrphans && deleteStaleRef(refsDir, id) {
+			report.Deleted = append(report.Deleted, filepath.Join("refs", e.Name()))
+		}
+	}
+	return nil
+}
+
+// deleteStaleRef removes the ref of id unless it was written within the
+// grace period, under id's lock (backfill holds it while repointing the ref
+// of a legacy image).
+func deleteStaleRef(refsDir, id string) bool {
+	unlock := lockDigest(id)
+	defer unlock()
+	path := filepath.Join(refsDir, id+".json")
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) < OrphanGracePeriod {
+		return false
+	}
+	if err := os.Remove(path); err != nil {
+		log.Error("image audit: could not delete ref %s.json: %v", id, err)
+		return false
+	}
+	return true
+}
+
+// auditOriginal checks an image's original and sidecar, recreating the
+// sidecar if it is missing or unreadable and opts allow it.
+func auditOriginal(dir, id string, g *imageGroup, opts AuditOptions, report *AuditReport) {
+	origPath := filepath.Join(dir, g.original)
+	if err := checkImageFile(origPath); err != nil {
+		log.Error("image audit: %s: %v", g.original, err)
+		report.Corrupt = append(report.Corrupt, g.original)
+		return
+	}
+
+	meta, err := readImageMeta(id)
+	if g.sidecar == "" || err != nil {
+		if !opts.RecreateSidecars {
+			report.MissingSidecars = append(report.MissingSidecars, id+".meta.json")
+			return
+		}
+		meta = rebuildMeta(dir, id, g.original)
+		if err := writeImageMeta(dir, id, meta); err != nil {
+			log.Error("image audit: could not recreate sidecar for %s: %v", id, err)
+			return
+		}
+		report.SidecarsRecreated = append(report.SidecarsRecreated, id+".meta.json")
+		return
+	}
+
+	if problem := metaMismatch(dir, id, g.original, meta); problem != "" {
+		log.Error("image audit: %s.meta.json: %s", id, problem)
+		report.Mismatched = append(report.Mismatched, id+".meta.json")
+	}
+}
+
+// metaMismatch describes the first way meta disagrees with the disk, or "".
+func metaMismatch(dir, id, original string, meta ImageMeta) string {
+	if ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(original)), "."); meta.FallbackExt != ext {
+		return fmt.Sprintf("fallbackExt %q but original is .%s", meta.FallbackExt, ext)
+	}
+	if w, h, err := imageenc.ReadDimensions(filepath.Join(dir, original)); err == nil && (w != meta.Width || h != meta.Height) {
+		return fmt.Sprintf("records %dx%d but original is %dx%d", meta.Width, meta.Height, w, h)
+	}
+	for _, enc := range imageenc.Encoders() {
+		format := enc.Format()
+		if meta.HasFormat(format) && format != meta.FallbackExt && !fileExists(filepath.Join(dir, id+"."+format)) {
+			return fmt.Sprintf("records %s but %s.%s is missing", format, id, format)
+		}
+	}
+	for _, v := range meta.Variants {
+		formats := v.Formats
+		if len(formats) == 0 {
+			formats = []string{"webp"}
+		}
+		for _, format := range formats {
+			if name := variantName(id, v.Width, format); !fileExists(filepath.Join(dir, name)) {
+				return fmt.Sprintf("records variant %s but it is missing", name)
+			}
+		}
+	}
+	return ""
+}
+
+// checkImageFile reports zero-byte files and files the decoders reject.
+// WebP/AVIF can't be decoded with the standard library, so only their magic
+// bytes are checked.
+func checkImageFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("zero-byte file")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".webp":
+		if len(data) < 12 || !bytes.Equal(data[0:4], []byte("RIFF")) || !bytes.Equal(data[8:12], []byte("WEBP")) {
+			return errors.New("not a WebP file")
+		}
+		return nil
+	case ".avif":
+		if len(data) < 12 || !bytes.Equal(data[4:8], []byte("ftyp")) {
+			return errors.New("not an AVIF file")
+		}
+		return nil
+	}
+	if _, _, err := imageenc.ReadDimensions(path); err != nil {
+		return fmt.Errorf("undecodable: %w", err)
+	}
+	return nil
+}
+
+// writeImageMeta writes meta as <id>.meta.json under dir.
+func writeImageMeta(dir, id string, meta ImageMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, id+".meta.json"), data, 0o644)
+}
+
+func (g *imageGroup) files() []string {
+	var files []string
+	if g.original != "" {
+		files = append(files, g.original)
+	}
+	files = append(files, g.derived...)
+	if g.sidecar != "" {
+		files = append(files, g.sidecar)
+	}
+	return files
+}
+
+// groupImageFiles buckets every file under dir by image ID: originals,
+// sidecars, <id>.<format> copies and <id>-<width>.<format> variants.
+// Derived files whose image is otherwise gone form their own group.
+func groupImageFiles(dir string) (map[string]*imageGroup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	bases := imageBases(entries)
+	groups := make(map[string]*imageGroup)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		base, sidecar := fileBase(name)
+		id := base
+		if prefix, ok := variantPrefix(base, bases); ok && !sidecar {
+			id = prefix
+		}
+		g, ok := groups[id]
+		if !ok {
+			g = &imageGroup{}
+			groups[id] = g
+		}
+		switch ext := strings.ToLower(filepath.Ext(name)); {
+		case sidecar:
+			g.sidecar = name
+		case ext == ".json":
+			continue
+		case id != base || isDerivedExt(ext):
+			g.derived = append(g.derived, name)
+		default:
+			g.original = name
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().After(g.modTime) {
+			g.modTime = info.ModTime()
+		}
+	}
+	// Originals uploaded as WebP have no other original: a .webp whose
+	// sidecar records fallbackExt "webp" is the original.
+	for id, g := range groups {
+		if i := slices.Index(g.derived, id+".webp"); g.original == "" && i >= 0 && g.sidecarSaysWebP(dir) {
+			g.original = g.derived[i]
+			g.derived = slices.Delete(g.derived, i, i+1)
+		}
+	}
+	return groups, nil
+}
+
+// latestModTime returns the newest modification time of g's files that are
+// still on disk.
+func (g *imageGroup) latestModTime(dir string) time.Time {
+	var latest time.Time
+	for _, name := range g.files() {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (g *imageGroup) sidecarSaysWebP(dir string) bool {
+	if g.sidecar == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, g.sidecar))
+	if err != nil {
+		return false
+	}
+	var meta ImageMeta
+	return json.Unmarshal(data, &meta) == nil && meta.FallbackExt == "webp"
+}
+
+// referencedImageIDs reads every cache file under cacheDir and collects the
+// image IDs it refers to: the IDs of image blocks and reading entries (whose
+// refs name their digests), and the file names in image URLs (see
+// imageURLMatcher).
+func referencedImageIDs(cacheDir string) (map[string]bool, int, error) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]bool{}, 0, nil
+		}
+		return nil, 0, err
+	}
+	refs := make(map[string]bool)
+	match := newImageURLMatcher(currentImageStore())
+	files := 0
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(cacheDir, e.Name()))
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading cache file %s: %w", e.Name(), err)
+		}
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			log.Error("image audit: skipping unparseable cache file %s: %v", e.Name(), err)
+			continue
+		}
+		files++
+		collectImageRefs(v, match, refs)
+	}
+	return refs, files, nil
+}
+
+func collectImageRefs(v any, match imageURLMatcher, refs map[string]bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		_, cover := v["image"].(string)
+		if v["type"] == "image" || cover {
+			// Image blocks, and reading entries with a cover.
+			if id, ok := v["id"].(string); ok {
+				refs[id] = true
+			}
+		}
+		for _, child := range v {
+			collectImageRefs(child, match, refs)
+		}
+	case []any:
+		for _, child := range v {
+			collectImageRefs(child, match, refs)
+		}
+	case string:
+		if id := match.imageID(v); id != "" {
+			refs[id] = true
+		}
+	}
+}
+
+// imageURLMatcher finds the stored image a URL points at. Images are served
+// from the active store's base URL, which needn't contain "/images/" (a CDN
+// or a bucket without an images/ prefix), so that base is matched as well as
+// the /images/ route of the local store and of older cached pages.
+type imageURLMatcher struct {
+	bases []string
+}
+
+func newImageURLMatcher(store ImageStore) imageURLMatcher {
+	return imageURLMatcher{bases: []string{store.URL(""), "/images/"}}
+}
+
+// imageID returns the image ID named by s, or "". Any file named
+// <digest>.<ext> counts, whatever its base URL.
+func (m imageURLMatcher) imageID(s string) string {
+	for _, base := range m.bases {
+		i := strings.Index(s, base)
+		if i < 0 {
+			continue
+		}
+		name := s[i+len(base):]
+		if j := strings.IndexAny(name, "?#/"); j >= 0 {
+			name = name[:j]
+		}
+		if id := strings.TrimSuffix(name, filepath.Ext(name)); id != "" {
+			return id
+		}
+	}
+	name := s
+	if j := strings.IndexAny(name, "?#"); j >= 0 {
+		name = name[:j]
+	}
+	name = name[strings.LastIndex(name, "/")+1:]
+	if id := strings.TrimSuffix(name, filepath.Ext(name)); id != name && isDigest(id) {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,249 @@
+package notion
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// auditFixture lays out a cache referencing two images and an image store
+// with one of each problem AuditImages looks for.
+func auditFixture(t *testing.T) (cacheDir string) {
+	t.Helper()
+	chdirTo(t, t.TempDir())
+	cacheDir = "cache"
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("images", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * OrphanGracePeriod)
+
+	// Block cache: an image block by ID. Reading entries: a cover by URL.
+	writeFile(t, filepath.Join(cacheDir, "page-1.json"),
+		[]byte(`{"data":[{"type":"image","id":"post-img","image":{"file":{"url":"/images/post-img.webp"}}},{"type":"image","id":"broken"}],"timestamp":"2024-01-01T00:00:00Z"}`), time.Time{})
+	writeFile(t, filepath.Join(cacheDir, "db-reading.json"),
+		[]byte(`{"data":[{"id":"book","title":"A Book","image":"https://cloud.shaikzhafir.com/images/book.jpg"}],"timestamp":"2024-01-01T00:00:00Z"}`), time.Time{})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatal(err)
+	}
+	pngBytes := buf.Bytes()
+
+	// post-img: sidecar claims a WebP copy that isn't there; has a 480 variant.
+	writeFile(t, "images/post-img.png", pngBytes, old)
+	writeFile(t, "images/post-img-480.png", pngBytes, old)
+	writeFile(t, "images/post-img.meta.json",
+		[]byte(`{"width":600,"height":300,"fallbackExt":"png","hasWebP":true,"variants":[{"width":480,"height":240,"formats":["png"]}]}`), old)
+	// book: sidecar lost.
+	writeFile(t, "images/book.jpg", pngBytes, old) // content sniffed, not extension
+	// broken: zero-byte original.
+	writeFile(t, "images/broken.png", nil, old)
+	// orphan: old enough to delete, with a WebP copy.
+	writeFile(t, "images/orphan.png", pngBytes, old)
+	writeFile(t, "images/orphan.webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), old)
+	writeFile(t, "images/orphan.meta.json", []byte(`{"width":600,"height":300,"fallbackExt":"png","hasWebP":true}`), old)
+	// fresh-orphan: unreferenced but inside the grace period.
+	writeFile(t, "images/fresh-orphan.png", pngBytes, time.Time{})
+	// stray: a derived copy whose original is gone.
+	writeFile(t, "images/stray.webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), old)
+	return cacheDir
+}
+
+func TestAuditImages_ReportOnly(t *testing.T) {
+	cacheDir := auditFixture(t)
+
+	report, err := AuditImages(cacheDir, AuditOptions{})
+	if err != nil {
+		t.Fatalf("AuditImages: %v", err)
+	}
+	if report.CacheFiles != 2 || report.Referenced != 3 {
+		t.Fatalf("cache: files=%d referenced=%d", report.CacheFiles, report.Referenced)
+	}
+	if want := []string{"fresh-orphan", "orphan", "stray"}; !reflect.DeepEqual(report.Orphans, want) {
+		t.Fatalf("orphans: got %v, want %v", report.Orphans, want)
+	}
+	if len(report.Deleted) != 0 {
+		t.Fatalf("report-only run deleted %v", report.Deleted)
+	}
+	if want := []string{"book.meta.json", "fresh-orphan.meta.json"}; !reflect.DeepEqual(report.MissingSidecars, want) {
+		t.Fatalf("missing sidecars: got %v, want %v", report.MissingSidecars, want)
+	}
+	if len(report.SidecarsRecreated) != 0 || fileExists("images/book.meta.json") {
+		t.Fatalf("report-only run recreated sidecars %v", report.SidecarsRecreated)
+	}
+	if want := []string{"broken.png"}; !reflect.DeepEqual(report.Corrupt, want) {
+		t.Fatalf("corrupt: got %v, want %v", report.Corrupt, want)
+	}
+	if want := []string{"post-img.meta.json"}; !reflect.DeepEqual(report.Mismatched, want) {
+		t.Fatalf("mismatched: got %v, want %v", report.Mismatched, want)
+	}
+	if want := []string{"stray.webp"}; !reflect.DeepEqual(report.MissingOriginals, want) {
+		t.Fatalf("missing originals: got %v, want %v", report.MissingOriginals, want)
+	}
+}
+
+func TestAuditImages_RecreatesSidecars(t *testing.T) {
+	cacheDir := auditFixture(t)
+
+	report, err := AuditImages(cacheDir, AuditOptions{RecreateSidecars: true})
+	if err != nil {
+		t.Fatalf("AuditImages: %v", err)
+	}
+	if want := []string{"book.meta.json", "fresh-orphan.meta.json"}; !reflect.DeepEqual(report.SidecarsRecreated, want) {
+		t.Fatalf("sidecars recreated: got %v, want %v", report.SidecarsRecreated, want)
+	}
+	meta, err := readImageMeta("book")
+	if err != nil || meta.Width != 600 || meta.Height != 300 {
+		t.Fatalf("recreated sidecar: %+v, %v", meta, err)
+	}
+}
+
+func TestAuditImages_DeleteOrphans(t *testing.T) {
+	cacheDir := auditFixture(t)
+
+	report, err := AuditImages(cacheDir, AuditOptions{DeleteOrphans: true})
+	if err != nil {
+		t.Fatalf("AuditImages: %v", err)
+	}
+	if want := []string{"orphan.png", "orphan.webp", "orphan.meta.json", "stray.webp"}; !reflect.DeepEqual(report.Deleted, want) {
+		t.Fatalf("deleted: got %v, want %v", report.Deleted, want)
+	}
+	for _, name := range []string{"post-img.png", "post-img-480.png", "book.jpg", "fresh-orphan.png"} {
+		if _, err := os.Stat(filepath.Join("images", name)); err != nil {
+			t.Fatalf("%s should survive: %v", name, err)
+		}
+	}
+}
+
+func TestAuditImages_RefusesToDeleteWithEmptyCache(t *testing.T) {
+	auditFixture(t)
+	empty := t.TempDir()
+
+	if _, err := AuditImages(empty, AuditOptions{DeleteOrphans: true}); err == nil {
+		t.Fatal("expected an error with no references")
+	}
+	if _, err := os.Stat("images/orphan.png"); err != nil {
+		t.Fatalf("nothing should be deleted: %v", err)
+	}
+}
+
+func TestAuditImages_RefusesToDeleteDuringBackfill(t *testing.T) {
+	cacheDir := auditFixture(t)
+	backfillMu.Lock()
+	prev := backfillJob
+	backfillJob = &BackfillJob{status: BackfillStatus{ID: "busy", State: BackfillRunning}}
+	backfillMu.Unlock()
+	t.Cleanup(func() {
+		backfillMu.Lock()
+		backfillJob = prev
+		backfillMu.Unlock()
+	})
+
+	if _, err := AuditImages(cacheDir, AuditOptions{DeleteOrphans: true}); err == nil {
+		t.Fatal("expected an error while a backfill is running")
+	}
+	if _, err := os.Stat("images/orphan.png"); err != nil {
+		t.Fatalf("nothing should be deleted: %v", err)
+	}
+	// Reporting is still allowed.
+	if _, err := AuditImages(cacheDir, AuditOptions{}); err != nil {
+		t.Fatalf("report-only audit: %v", err)
+	}
+}
+
+func TestAuditImages_FollowsDigestRefs(t *testing.T) {
+	cacheDir := auditFixture(t)
+	old := time.Now().Add(-2 * OrphanGracePeriod)
+	if err := os.MkdirAll("images/refs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	// "broken" is a referenced block whose image is stored by digest.
+	writeFile(t, "images/0123abcd.png", buf.Bytes(), old)
+	writeFile(t, "images/refs/broken.json", []byte(`{"digest":"0123abcd"}`), old)
+	// "gone" is a block that was deleted from its page.
+	writeFile(t, "images/refs/gone.json", []byte(`{"digest":"0123abcd"}`), old)
+
+	report, err := AuditImages(cacheDir, AuditOptions{DeleteOrphans: true})
+	if err != nil {
+		t.Fatalf("AuditImages: %v", err)
+	}
+	if slices.Contains(report.Orphans, "0123abcd") {
+		t.Fatal("digest referenced through a ref was reported as an orphan")
+	}
+	if want := []string{"gone.json"}; !reflect.DeepEqual(report.StaleRefs, want) {
+		t.Fatalf("stale refs: got %v, want %v", report.StaleRefs, want)
+	}
+	if _, err := os.Stat("images/refs/gone.json"); !os.IsNotExist(err) {
+		t.Fatalf("stale ref should be deleted: %v", err)
+	}
+	if _, err := os.Stat("images/0123abcd.png"); err != nil {
+		t.Fatalf("referenced digest should survive: %v", err)
+	}
+}
+
+func TestAuditImages_MatchesStoreBaseURL(t *testing.T) {
+	t.Setenv("IMAGE_BASE_URL", "https://cdn.example.com")
+	chdirTo(t, t.TempDir())
+	for _, dir := range []string{"cache", "images/refs"} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * OrphanGracePeriod)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	cover, moved, elsewhere := "0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210", "00112233445566778899aabbccddeeff"
+	for _, id := range []string{"legacy-cover", cover, moved, elsewhere, "orphan"} {
+		writeFile(t, "images/"+id+".png", buf.Bytes(), old)
+	}
+	// A reading entry whose cover URL is stale: its ref names the digest.
+	writeFile(t, "images/refs/entry-3.json", []byte(`{"digest":"`+moved+`"}`), old)
+	writeFile(t, "cache/db-reading.json", []byte(`{"data":[`+
+		`{"id":"entry-1","image":"https://cdn.example.com/legacy-cover.png"},`+
+		`{"id":"entry-2","image":"https://cdn.example.com/`+cover+`.png?v=2"},`+
+		`{"id":"entry-3","image":"https://old-host.example/covers/x.png"},`+
+		`{"id":"entry-4","image":"https://mirror.example/`+elsewhere+`.png"}]}`), time.Time{})
+
+	report, err := AuditImages("cache", AuditOptions{DeleteOrphans: true})
+	if err != nil {
+		t.Fatalf("AuditImages: %v", err)
+	}
+	if want := []string{"orphan"}; !reflect.DeepEqual(report.Orphans, want) {
+		t.Fatalf("orphans: got %v, want %v", report.Orphans, want)
+	}
+	if len(report.StaleRefs) != 0 {
+		t.Fatalf("reading entry ref reported stale: %v", report.StaleRefs)
+	}
+	for _, id := range []string{"legacy-cover", cover, moved, elsewhere} {
+		if _, err := os.Stat("images/" + id + ".png"); err != nil {
+			t.Fatalf("%s should survive: %v", id, err)
+		}
+	}
+}
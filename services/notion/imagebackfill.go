@@ -1,90 +1,219 @@
 package notion
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	log "htmx-blog/logging"
 	"htmx-blog/services/notion/imageenc"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 // BackfillReport summarises a backfill run.
 type BackfillReport struct {
-	Scanned int
-	Encoded int
-	Skipped int
-	Failed  int
+	Scanned int `json:"scanned"`
+	Encoded int `json:"encoded"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
 }
 
-// BackfillImages walks ./images/ and, for every non-WebP, non-meta file that
-// doesn't already have a <id>.webp sibling, encodes the WebP and writes the
-// sidecar. Files that already have a WebP sibling are left alone.
+// backfillOutcome is what backfillImage did to one original.
+type backfillOutcome int
+
+const (
+	backfillSkipped backfillOutcome = iota // nothing was missing
+	backfillUpdated                        // sanitized/placeholder/published, nothing encoded
+	backfillEncoded
+	backfillFailed
+)
+
+// BackfillImages runs a backfill job over ./images to completion (see
+// StartBackfill) and returns its counters.
 func BackfillImages() (BackfillReport, error) {
-	var report BackfillReport
-	dir, err := imagesDir()
+	job, err := StartBackfill(BackfillOptions{})
 	if err != nil {
-		return report, err
+		return BackfillReport{}, err
 	}
+	status := job.Wait()
+	if status.Error != "" {
+		return status.Report, errors.New(status.Error)
+	}
+	return status.Report, nil
+}
 
+// backfillOriginals lists the original image files under dir, skipping
+// sidecars, encoded copies and pure-Go <id>-<width> variants.
+func backfillOriginals(dir string) ([]string, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return report, err
+		return nil, err
 	}
-
+	bases := imageBases(entries)
+	var names []string
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
 		name := e.Name()
 		ext := strings.ToLower(filepath.Ext(name))
-		if ext == ".webp" || ext == ".json" {
+		if isDerivedExt(ext) || ext == ".json" {
 			continue
 		}
-		report.Scanned++
-
-		id := strings.TrimSuffix(name, filepath.Ext(name))
-		origPath := filepath.Join(dir, name)
-		webpPath := filepath.Join(dir, id+".webp")
-		metaPath := filepath.Join(dir, id+".meta.json")
-
-		webpExists := fileExists(webpPath)
-		metaExists := fileExists(metaPath)
-		if webpExists && metaExists {
-			report.Skipped++
+		if base, _ := fileBase(name); hasVariantPrefix(base, bases) {
+			// A pure-Go <id>-<width>.jpg variant, not an original.
 			continue
 		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// backfillImage brings one original up to date: strips its metadata (moving
+// it to a new digest if that changes its bytes, see sanitizeFile) and
+// computes its blur-up placeholder if that hasn't happened yet, encodes
+// whichever AVIF/WebP copies and width variants its sidecar doesn't record
+// yet, using the encoders installed on this host, and uploads it to the
+// configured ImageStore. Images with nothing missing are left alone.
+func backfillImage(dir, name string) (backfillOutcome, error) {
+	id := strings.TrimSuffix(name, filepath.Ext(name))
+	origPath := filepath.Join(dir, name)
+	metaPath := filepath.Join(dir, id+".meta.json")
 
-		// Derive fallback extension (without leading dot) from filename.
-		fallbackExt := strings.TrimPrefix(ext, ".")
+	unlock := lockDigest(id)
+	defer unlock()
 
-		meta := ImageMeta{FallbackExt: fallbackExt}
-		if w, h, derr := imageenc.ReadDimensions(origPath); derr == nil {
-			meta.Width, meta.Height = w, h
-		} else {
-			log.Error("backfill: could not read dimensions for %s: %v", name, derr)
+	var meta ImageMeta
+	if fileExists(metaPath) {
+		existing, merr := readImageMeta(id)
+		if merr != nil {
+			return backfillSkipped, fmt.Errorf("unreadable sidecar, skipping: %w", merr)
+		}
+		if !existing.needsSanitizing() && !existing.needsPlaceholder() && !needsEncoding(existing) && !needsPublishing(existing) {
+			return backfillSkipped, nil
 		}
+		meta = existing
+	} else {
+		meta = rebuildMeta(dir, id, name)
+	}
 
-		if !webpExists {
-			if err := imageenc.EncodeWebP(origPath, webpPath); err != nil {
-				log.Error("backfill: webp encode failed for %s: %v", name, err)
-				report.Failed++
-			} else {
-				meta.HasWebP = true
-				report.Encoded++
-			}
-		} else {
-			meta.HasWebP = true
+	if meta.needsSanitizing() {
+		moved, err := sanitizeFile(dir, id, origPath, &meta)
+		if err != nil {
+			return backfillFailed, fmt.Errorf("could not strip metadata: %w", err)
+		}
+		if moved {
+			// Stored, encoded and published afresh under its digest.
+			return backfillEncoded, nil
 		}
+	}
+
+	if meta.needsPlaceholder() {
+		setPlaceholder(id, origPath, &meta)
+	}
 
-		if metaBytes, merr := json.Marshal(meta); merr == nil {
-			if werr := os.WriteFile(metaPath, metaBytes, 0o644); werr != nil {
-				log.Error("backfill: error writing sidecar for %s: %v", name, werr)
+	outcome := backfillUpdated
+	var ferr error
+	encoded, failed := encodeFormats(dir, id, origPath, &meta)
+	if failed > 0 {
+		outcome, ferr = backfillFailed, fmt.Errorf("%d format(s) failed to encode", failed)
+	} else if encoded > 0 {
+		outcome = backfillEncoded
+	}
+
+	if err := publishImage(dir, id, &meta); err != nil {
+		outcome, ferr = backfillFailed, err
+	}
+
+	if err := writeImageMeta(dir, id, meta); err != nil {
+		log.Error("backfill: error writing sidecar for %s: %v", name, err)
+	}
+	return outcome, ferr
+}
+
+// rebuildMeta reconstructs a sidecar for the original file name from what is
+// on disk: its dimensions plus any derived copies and variants encoded
+// before the sidecar was lost. Sanitized and the placeholder are left unset
+// so the next backfill redoes them.
+func rebuildMeta(dir, id, name string) ImageMeta {
+	// Derive fallback extension (without leading dot) from filename.
+	meta := ImageMeta{FallbackExt: strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")}
+	if w, h, derr := imageenc.ReadDimensions(filepath.Join(dir, name)); derr == nil {
+		meta.Width, meta.Height = w, h
+	} else {
+		log.Error("could not read dimensions for %s: %v", name, derr)
+	}
+	// Copies encoded before the sidecar existed are reused as-is.
+	for _, enc := range imageenc.Encoders() {
+		format := enc.Format()
+		if fileExists(filepath.Join(dir, id+"."+format)) {
+			meta.addFormat(format)
+		}
+	}
+	formats := append([]string{meta.FallbackExt}, meta.Formats...)
+	for _, width := range meta.variantWidths() {
+		v := ImageVariant{Width: width, Height: (meta.Height*width + meta.Width/2) / meta.Width}
+		for _, format := range formats {
+			if fileExists(filepath.Join(dir, variantName(id, width, format))) {
+				v.Formats = append(v.Formats, format)
 			}
 		}
+		if len(v.Formats) > 0 {
+			meta.Variants = append(meta.Variants, v)
+		}
+	}
+	return meta
+}
+
+// fileBase strips the extension from an ./images file name, treating
+// ".meta.json" as one extension; sidecar reports whether it was one.
+func fileBase(name string) (base string, sidecar bool) {
+	if base, ok := strings.CutSuffix(name, ".meta.json"); ok {
+		return base, true
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)), false
+}
+
+// imageBases is the set of file bases in a directory listing.
+func imageBases(entries []os.DirEntry) map[string]bool {
+	bases := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		base, _ := fileBase(e.Name())
+		bases[base] = true
 	}
+	return bases
+}
 
-	return report, nil
+// variantPrefix returns the image ID of a variant base "<id>-<width>": width
+// must be one of VariantWidths and <id> another file base in the directory.
+func variantPrefix(base string, bases map[string]bool) (string, bool) {
+	i := strings.LastIndexByte(base, '-')
+	if i <= 0 {
+		return "", false
+	}
+	width, err := strconv.Atoi(base[i+1:])
+	if err != nil || !slices.Contains(VariantWidths, width) || !bases[base[:i]] {
+		return "", false
+	}
+	return base[:i], true
+}
+
+func hasVariantPrefix(base string, bases map[string]bool) bool {
+	_, ok := variantPrefix(base, bases)
+	return ok
+}
+
+// isDerivedExt reports whether ext (with dot) is one of the encoder output
+// formats. Originals uploaded as WebP are skipped too, as before.
+func isDerivedExt(ext string) bool {
+	for _, enc := range imageenc.Encoders() {
+		if ext == "."+enc.Format() {
+			return true
+		}
+	}
+	return false
 }
 
 func fileExists(path string) bool {
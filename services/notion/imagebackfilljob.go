@@ -0,0 +1,335 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "htmx-blog/logging"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Backfill job states.
+const (
+	BackfillRunning   = "running"
+	BackfillDone      = "done"
+	BackfillCancelled = "cancelled"
+	BackfillFailed    = "failed"
+)
+
+// ErrBackfillRunning is returned by StartBackfill while a job is running.
+var ErrBackfillRunning = errors.New("image backfill already running")
+
+// DefaultBackfillWorkers bounds how many images are processed at once.
+// cwebp/avifenc are CPU-bound, so more workers than cores only adds memory.
+var DefaultBackfillWorkers = min(runtime.NumCPU(), 4)
+
+// checkpointEvery is how many finished files go between checkpoint writes.
+// A crash redoes at most this many, which is safe: backfill is idempotent.
+const checkpointEvery = 20
+
+// BackfillOptions configures StartBackfill.
+type BackfillOptions struct {
+	// Workers defaults to DefaultBackfillWorkers.
+	Workers int
+	// Restart ignores the checkpoint of an interrupted or cancelled run
+	// instead of resuming it.
+	Restart bool
+}
+
+// BackfillError is the failure of one file.
+type BackfillError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// BackfillStatus is a snapshot of a backfill job, also the checkpoint
+// format at ./images/jobs/backfill.json.
+type BackfillStatus struct {
+	ID         string          `json:"id"`
+	State      string          `json:"state"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Workers    int             `json:"workers"`
+	Total      int             `json:"total"`
+	Processed  int             `json:"processed"`
+	Report     BackfillReport  `json:"report"`
+	Errors     []BackfillError `json:"errors,omitempty"`
+	// Error is set when the job as a whole failed (e.g. ./images unreadable).
+	Error string `json:"error,omitempty"`
+	// Done lists the files already processed, so a resumed job skips them.
+	Done []string `json:"done,omitempty"`
+}
+
+// BackfillJob is a backfill running in the background.
+type BackfillJob struct {
+	mu       sync.Mutex
+	writeMu  sync.Mutex // serialises checkpoint writes
+	status   BackfillStatus
+	done     map[string]bool
+	dirty    int
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+var (
+	backfillMu  sync.Mutex
+	backfillJob *BackfillJob
+)
+
+// StartBackfill starts a backfill of ./images in the background and returns
+// immediately. If the previous run was interrupted (crash or Cancel) and
+// opts.Restart isn't set, the new job resumes it, skipping files it already
+// processed. Only one job runs at a time.
+func StartBackfill(opts BackfillOptions) (*BackfillJob, error) {
+	backfillMu.Lock()
+	defer backfillMu.Unlock()
+	if backfillJob != nil && backfillJob.Status().State == BackfillRunning {
+		return backfillJob, ErrBackfillRunning
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultBackfillWorkers
+	}
+	now := time.Now()
+	status := BackfillStatus{
+		ID:        now.UTC().Format("20060102T150405Z"),
+		StartedAt: now,
+	}
+	if prev, ok := readBackfillCheckpoint(); ok && !opts.Restart && (prev.State == BackfillRunning || prev.State == BackfillCancelled) {
+		status = prev
+		status.FinishedAt = nil
+		log.Info("image backfill %s: resuming, %d files already done", status.ID, len(status.Done))
+	}
+	status.State, status.Workers, status.Error = BackfillRunning, workers, ""
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &BackfillJob{
+		status:   status,
+		done:     make(map[string]bool, len(status.Done)),
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+	for _, name := range status.Done {
+		job.done[name] = true
+	}
+	backfillJob = job
+	go job.run(ctx)
+	return job, nil
+}
+
+// ResumeBackfill restarts a backfill that was running when the process
+// last exited. Returns nil when there is nothing to resume.
+func ResumeBackfill() (*BackfillJob, error) {
+	prev, ok := readBackfillCheckpoint()
+	if !ok || prev.State != BackfillRunning {
+		return nil, nil
+	}
+	return StartBackfill(BackfillOptions{Workers: prev.Workers})
+}
+
+// LastBackfill returns the status of the current or most recent backfill,
+// from the checkpoint if none ran since the process started.
+func LastBackfill() (BackfillStatus, bool) {
+	backfillMu.Lock()
+	job := backfillJob
+	backfillMu.Unlock()
+	if job != nil {
+		return job.Status(), true
+	}
+	return readBackfillCheckpoint()
+}
+
+// CancelBackfill cancels the running job and waits for it to stop. Returns
+// false if none is running.
+func CancelBackfill() (BackfillStatus, bool) {
+	backfillMu.Lock()
+	job := backfillJob
+	backfillMu.Unlock()
+	if job == nil || job.Status().State != BackfillRunning {
+		return BackfillStatus{}, false
+	}
+	job.Cancel()
+	return job.Wait(), true
+}
+
+// Status returns a snapshot of the job. The Done list is left out.
+func (j *BackfillJob) Status() BackfillStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.status
+	s.Done = nil
+	s.Errors = slices.Clone(s.Errors)
+	return s
+}
+
+// Cancel stops the job after the files in progress. A later StartBackfill
+// resumes where it stopped.
+func (j *BackfillJob) Cancel() {
+	j.cancel()
+}
+
+// Wait blocks until the job ends and returns its final status.
+func (j *BackfillJob) Wait() BackfillStatus {
+	<-j.finished
+	return j.Status()
+}
+
+func (j *BackfillJob) run(ctx context.Context) {
+	defer close(j.finished)
+	defer j.cancel()
+
+	dir, err := imagesDir()
+	var names []string
+	if err == nil {
+		names, err = backfillOriginals(dir)
+	}
+	if err != nil {
+		j.finish(BackfillFailed, err)
+		return
+	}
+
+	j.mu.Lock()
+	j.status.Total = len(names)
+	j.mu.Unlock()
+	j.checkpoint()
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range j.status.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				outcome, err := backfillImage(dir, name)
+				j.record(name, outcome, err)
+			}
+		}()
+	}
+feed:
+	for _, name := range names {
+		j.mu.Lock()
+		seen := j.done[name]
+		j.mu.Unlock()
+		if seen {
+			continue
+		}
+		select {
+		case work <- name:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		j.finish(BackfillCancelled, nil)
+		return
+	}
+	j.finish(BackfillDone, nil)
+}
+
+// record counts one finished file and checkpoints every checkpointEvery.
+func (j *BackfillJob) record(name string, outcome backfillOutcome, err error) {
+	j.mu.Lock()
+	r := &j.status.Report
+	r.Scanned++
+	switch outcome {
+	case backfillSkipped:
+		r.Skipped++
+	case backfillEncoded:
+		r.Encoded++
+	case backfillFailed:
+		r.Failed++
+	}
+	if err != nil {
+		log.Error("backfill: %s: %v", name, err)
+		j.status.Errors = append(j.status.Errors, BackfillError{File: name, Error: err.Error()})
+	}
+	j.status.Processed++
+	j.done[name] = true
+	j.status.Done = append(j.status.Done, name)
+	j.dirty++
+	flush := j.dirty >= checkpointEvery
+	j.mu.Unlock()
+	if flush {
+		j.checkpoint()
+	}
+}
+
+func (j *BackfillJob) finish(state string, err error) {
+	now := time.Now()
+	j.mu.Lock()
+	j.status.State = state
+	j.status.FinishedAt = &now
+	if err != nil {
+		j.status.Error = err.Error()
+	}
+	r, id := j.status.Report, j.status.ID
+	j.mu.Unlock()
+	j.checkpoint()
+	log.Info("image backfill %s %s: scanned=%d encoded=%d skipped=%d failed=%d",
+		id, state, r.Scanned, r.Encoded, r.Skipped, r.Failed)
+}
+
+// checkpoint writes the job's status, Done list included, atomically.
+func (j *BackfillJob) checkpoint() {
+	j.writeMu.Lock()
+	defer j.writeMu.Unlock()
+	j.mu.Lock()
+	data, err := json.Marshal(j.status)
+	j.dirty = 0
+	j.mu.Unlock()
+	if err != nil {
+		log.Error("backfill: could not encode checkpoint: %v", err)
+		return
+	}
+	path, err := backfillCheckpointPath()
+	if err == nil {
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		log.Error("backfill: could not write checkpoint: %v", err)
+	}
+}
+
+// backfillCheckpointPath is ./images/jobs/backfill.json. It lives in a
+// subdirectory so listings of ./images never see it.
+func backfillCheckpointPath() (string, error) {
+	dir, err := imagesDir()
+	if err != nil {
+		return "", err
+	}
+	jobs := filepath.Join(dir, "jobs")
+	if err := os.MkdirAll(jobs, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating backfill jobs dir: %v", err)
+	}
+	return filepath.Join(jobs, "backfill.json"), nil
+}
+
+func readBackfillCheckpoint() (BackfillStatus, bool) {
+	path, err := backfillCheckpointPath()
+	if err != nil {
+		return BackfillStatus{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return BackfillStatus{}, false
+	}
+	var status BackfillStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		log.Error("backfill: ignoring unreadable checkpoint: %v", err)
+		return BackfillStatus{}, false
+	}
+	return status, true
+}
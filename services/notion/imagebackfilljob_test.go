@@ -0,0 +1,107 @@
+package notion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// backfillFixture writes n unsanitized PNG originals without sidecars, plus
+// one file that isn't an image.
+func backfillFixture(t *testing.T, n int) {
+	t.Helper()
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	if err := os.MkdirAll("images", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 16))); err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		if err := os.WriteFile(fmt.Sprintf("images/img%02d.png", i), buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile("images/broken.png", []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartBackfill_ProcessesConcurrentlyWithErrorDetails(t *testing.T) {
+	backfillFixture(t, 10)
+
+	job, err := StartBackfill(BackfillOptions{Workers: 3})
+	if err != nil {
+		t.Fatalf("StartBackfill: %v", err)
+	}
+	status := job.Wait()
+	if status.State != BackfillDone || status.Total != 11 || status.Processed != 11 || status.Workers != 3 {
+		t.Fatalf("status: %+v", status)
+	}
+	if status.Report.Scanned != 11 || status.Report.Failed != 1 {
+		t.Fatalf("report: %+v", status.Report)
+	}
+	if len(status.Errors) != 1 || status.Errors[0].File != "broken.png" {
+		t.Fatalf("errors: %+v", status.Errors)
+	}
+	for i := range 10 {
+		if meta, err := readImageMeta(fmt.Sprintf("img%02d", i)); err != nil || !meta.Sanitized {
+			t.Fatalf("img%02d not backfilled: %+v, %v", i, meta, err)
+		}
+	}
+
+	// The checkpoint records the finished run for the status endpoint.
+	last, ok := LastBackfill()
+	if !ok || last.State != BackfillDone || last.ID != status.ID {
+		t.Fatalf("LastBackfill: %+v, %v", last, ok)
+	}
+}
+
+func TestResumeBackfill_SkipsFilesAlreadyDone(t *testing.T) {
+	backfillFixture(t, 4)
+
+	// A run that crashed after doing img00 and img01.
+	crashed := BackfillStatus{
+		ID:        "crashed",
+		State:     BackfillRunning,
+		Workers:   2,
+		Processed: 2,
+		Report:    BackfillReport{Scanned: 2, Encoded: 2},
+		Done:      []string{"img00.png", "img01.png"},
+	}
+	data, _ := json.Marshal(crashed)
+	if err := os.MkdirAll("images/jobs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("images", "jobs", "backfill.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := ResumeBackfill()
+	if err != nil || job == nil {
+		t.Fatalf("ResumeBackfill: %v, %v", job, err)
+	}
+	status := job.Wait()
+	if status.ID != "crashed" || status.State != BackfillDone {
+		t.Fatalf("status: %+v", status)
+	}
+	// 2 from before the crash plus img02, img03 and broken.png.
+	if status.Report.Scanned != 5 || status.Processed != 5 {
+		t.Fatalf("resumed counters: %+v", status)
+	}
+	if fileExists("images/img00.meta.json") || !fileExists("images/img02.meta.json") {
+		t.Fatal("resume should skip done files and process the rest")
+	}
+
+	// Nothing left to resume once it finished.
+	if job, err := ResumeBackfill(); job != nil || err != nil {
+		t.Fatalf("second resume: %v, %v", job, err)
+	}
+}
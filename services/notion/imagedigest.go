@@ -0,0 +1,222 @@
+package notion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Images are stored content-addressed: files are named by the digest of the
+// sanitized bytes, so the same photo used in several posts (or re-uploaded
+// under a new block) is stored and encoded once, and its URLs never change
+// content. A small ref file per block/entry ID points at the digest.
+//
+// Images stored before this were keyed by block ID; imageKey falls back to
+// that so they keep rendering.
+
+// digestLength is how many hex characters of the SHA-256 are kept (128 bits).
+const digestLength = 32
+
+// imageDigest returns the storage key for sanitized image bytes.
+func imageDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])[:digestLength]
+}
+
+// isDigest reports whether key has the shape of an imageDigest, as opposed
+// to a block or entry ID keying a file stored before content addressing.
+func isDigest(key string) bool {
+	if len(key) != digestLength {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// ImageCacheControl returns the Cache-Control for the stored image file
+// name. Files of a digest (the original, its copies and variants) never
+// change, so caches keep them forever; files stored before content
+// addressing are named by block or entry ID and are replaced in place, so
+// they get an hour.
+func ImageCacheControl(name string) string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if i := strings.LastIndexByte(base, '-'); i > 0 {
+		if _, err := strconv.Atoi(base[i+1:]); err == nil {
+			base = base[:i] // <id>-<width> variant
+		}
+	}
+	if isDigest(base) {
+		return "public, max-age=31536000, immutable"
+	}
+	return "public, max-age=3600"
+}
+
+// ImageRef maps a Notion block or entry ID to the stored image it uses.
+// Lives at ./images/refs/<id>.json.
+type ImageRef struct {
+	Digest string `json:"digest"`
+	// Source is the download URL without its query string. Notion's signed
+	// S3 URLs change on every fetch but keep the same path for the same
+	// upload, so a matching path means the stored copy is still current.
+	Source string `json:"source,omitempty"`
+}
+
+// imageRefsDir returns an absolute path to ./images/refs, creating it if
+// needed.
+func imageRefsDir() (string, error) {
+	dir, err := imagesDir()
+	if err != nil {
+		return "", err
+	}
+	refs := filepath.Join(dir, "refs")
+	if err := os.MkdirAll(refs, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating image refs dir: %v", err)
+	}
+	return refs, nil
+}
+
+// readImageRef loads the ref for id. A missing ref returns ok == false.
+func readImageRef(id string) (ref ImageRef, ok bool) {
+	dir, err := imageRefsDir()
+	if err != nil {
+		return ImageRef{}, false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return ImageRef{}, false
+	}
+	if err := json.Unmarshal(data, &ref); err != nil || ref.Digest == "" {
+		return ImageRef{}, false
+	}
+	return ref, true
+}
+
+// writeImageRef records that id uses ref.Digest.
+func writeImageRef(id string, ref ImageRef) error {
+	dir, err := imageRefsDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, id+".json"), data, 0o644)
+}
+
+// repointImageRefs rewrites every ref using digest from to use to instead.
+func repointImageRefs(from, to string) error {
+	dir, err := imageRefsDir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if ref, ok := readImageRef(id); ok && ref.Digest == from {
+			ref.Digest = to
+			if err := writeImageRef(id, ref); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// imageKey returns the file key for a block or entry ID: its digest when it
+// has a ref, else the ID itself (images stored before content addressing).
+func imageKey(id string) string {
+	if ref, ok := readImageRef(id); ok {
+		return ref.Digest
+	}
+	return id
+}
+
+// sourceKey strips the query string and fragment from a download URL.
+func sourceKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
+
+// storedImageURL returns the URL of the stored copy of sourceURL for id if
+// the ref records the same source and the files are still on disk, so the
+// caller can skip downloading it again.
+func storedImageURL(id, sourceURL string) (string, bool) {
+	ref, ok := readImageRef(id)
+	if !ok || ref.Source == "" || ref.Source != sourceKey(sourceURL) {
+		return "", false
+	}
+	return previousImageURL(id)
+}
+
+// previousImageURL returns the URL of whatever image is stored for id,
+// whatever its source. Used to keep serving it when a new download fails.
+func previousImageURL(id string) (string, bool) {
+	ref, ok := readImageRef(id)
+	if !ok {
+		return "", false
+	}
+	meta, err := readImageMeta(ref.Digest)
+	if err != nil || meta.FallbackExt == "" {
+		return "", false
+	}
+	dir, err := imagesDir()
+	if err != nil || !fileExists(filepath.Join(dir, ref.Digest+"."+meta.FallbackExt)) {
+		return "", false
+	}
+	return imageURLFor(ref.Digest, meta.primaryExt()), true
+}
+
+// digestLocks serialises work on one digest, so two blocks using the same
+// new image don't encode it into the same files concurrently. An entry
+// lives only while someone holds or waits for it, so the map doesn't grow
+// with every image and transform ever stored. Backfill takes a block ID's
+// lock and then its digest's, so keys can't share a mutex (as lock stripes
+// would) without risking a deadlock.
+var digestLocks = struct {
+	sync.Mutex
+	m map[string]*digestLock
+}{m: make(map[string]*digestLock)}
+
+type digestLock struct {
+	sync.Mutex
+	refs int // holders and waiters, guarded by digestLocks
+}
+
+func lockDigest(digest string) (unlock func()) {
+	digestLocks.Lock()
+	l, ok := digestLocks.m[digest]
+	if !ok {
+		l = &digestLock{}
+		digestLocks.m[digest] = l
+	}
+	l.refs++
+	digestLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		digestLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(digestLocks.m, digest)
+		}
+		digestLocks.Unlock()
+	}
+}
@@ -0,0 +1,107 @@
+package notion
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStoreImageBytes_DeduplicatesByDigest(t *testing.T) {
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+
+	pngBytes := tinyImageBytes(t, func(w *bytes.Buffer, img image.Image) error { return png.Encode(w, img) })
+	urlA, _, err := storeImageBytes("block-a", "https://s3.example/ws/file-1/photo.png?X-Amz-Signature=one", pngBytes)
+	if err != nil {
+		t.Fatalf("storeImageBytes: %v", err)
+	}
+	urlB, _, err := storeImageBytes("block-b", "https://s3.example/ws/file-2/photo.png?X-Amz-Signature=two", pngBytes)
+	if err != nil {
+		t.Fatalf("storeImageBytes: %v", err)
+	}
+
+	digest := imageDigest(pngBytes)
+	if want := "/images/" + digest + ".png"; urlA != want || urlB != want {
+		t.Fatalf("urls: got %s and %s, want both %s", urlA, urlB, want)
+	}
+	if imageKey("block-a") != digest || imageKey("block-b") != digest {
+		t.Fatal("both blocks should map to the same digest")
+	}
+	originals, _ := filepath.Glob("images/*.png")
+	if len(originals) != 1 {
+		t.Fatalf("expected one stored original, got %v", originals)
+	}
+	// Unmapped IDs keep resolving to themselves (pre-digest storage).
+	if imageKey("legacy-block") != "legacy-block" {
+		t.Fatal("legacy key should fall back to the block ID")
+	}
+}
+
+func TestStoredImageURL_SkipsUnchangedSource(t *testing.T) {
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+
+	pngBytes := tinyImageBytes(t, func(w *bytes.Buffer, img image.Image) error { return png.Encode(w, img) })
+	url, _, err := storeImageBytes("block", "https://s3.example/ws/file-1/photo.png?X-Amz-Signature=one", pngBytes)
+	if err != nil {
+		t.Fatalf("storeImageBytes: %v", err)
+	}
+
+	// Re-signed URL for the same upload: reuse the stored copy.
+	got, ok := storedImageURL("block", "https://s3.example/ws/file-1/photo.png?X-Amz-Signature=fresh")
+	if !ok || got != url {
+		t.Fatalf("storedImageURL: got %q, %v; want %q", got, ok, url)
+	}
+	// The image was replaced in Notion: different path, download again.
+	if _, ok := storedImageURL("block", "https://s3.example/ws/file-9/photo.png?X-Amz-Signature=x"); ok {
+		t.Fatal("a new upload must not reuse the old copy")
+	}
+	// Files gone (e.g. audit cleanup): download again.
+	if err := os.Remove(filepath.Join("images", imageDigest(pngBytes)+".png")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storedImageURL("block", "https://s3.example/ws/file-1/photo.png?X-Amz-Signature=fresh"); ok {
+		t.Fatal("missing original must not be reused")
+	}
+}
+
+func TestLockDigest_SerialisesAndForgetsKeys(t *testing.T) {
+	var wg sync.WaitGroup
+	var inside, overlapped atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := lockDigest("abc")
+			if inside.Add(1) > 1 {
+				overlapped.Store(1)
+			}
+			time.Sleep(time.Millisecond)
+			inside.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if overlapped.Load() != 0 {
+		t.Fatal("two holders of the same digest lock at once")
+	}
+
+	// Nested locks on different keys, as backfill takes them.
+	unlockID := lockDigest("block-id")
+	lockDigest("digest")()
+	unlockID()
+
+	digestLocks.Lock()
+	defer digestLocks.Unlock()
+	if len(digestLocks.m) != 0 {
+		t.Fatalf("locks kept after unlock: %v", digestLocks.m)
+	}
+}
@@ -0,0 +1,185 @@
+package notion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrImageTooLarge means the response exceeded ImageDownloader.MaxBytes.
+	ErrImageTooLarge = errors.New("image too large")
+	// ErrNotAnImage means the response wasn't one of the accepted image
+	// types, e.g. an S3 error document served with a 200.
+	ErrNotAnImage = errors.New("response is not an image")
+)
+
+// DownloadStatusError is a non-2xx response. URL has its query string
+// stripped: Notion's signed S3 URLs carry credentials there.
+type DownloadStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *DownloadStatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// ImageDownloader fetches remote images with a timeout, a size cap and a
+// content check, retrying transient failures (network errors, 429 and 5xx).
+// The zero value is usable; unset fields take the defaults below.
+type ImageDownloader struct {
+	// Client defaults to one with a 30s timeout per attempt.
+	Client *http.Client
+	// MaxBytes defaults to 25 MiB.
+	MaxBytes int64
+	// Attempts is the total number of tries, default 3.
+	Attempts int
+	// Backoff is the wait before the first retry, doubled after each one.
+	// Default 500ms. A Retry-After header (in seconds) wins, up to 30s.
+	Backoff time.Duration
+	// Types are the accepted sniffed MIME types, default PNG, JPEG, GIF and
+	// WebP: what storeImageBytes can sanitize.
+	Types []string
+}
+
+// imageDownloader is shared by Notion image blocks and reading-list covers.
+var imageDownloader = &ImageDownloader{}
+
+var defaultDownloadClient = &http.Client{Timeout: 30 * time.Second}
+
+const (
+	defaultMaxImageBytes = 25 << 20
+	maxRetryAfter        = 30 * time.Second
+)
+
+// Get downloads url and returns its body once it is a complete image of an
+// accepted type. Cancelling ctx stops the download and any wait between
+// retries.
+func (d *ImageDownloader) Get(ctx context.Context, url string) ([]byte, error) {
+	attempts := d.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		var body []byte
+		var retryAfter time.Duration
+		body, retryAfter, err = d.get(ctx, url)
+		if err == nil {
+			return body, nil
+		}
+		if attempt >= attempts || ctx.Err() != nil || !retryable(err) {
+			break
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = min(retryAfter, maxRetryAfter)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("downloading %s: %w", sourceKey(url), ctx.Err())
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+	return nil, fmt.Errorf("downloading %s: %w", sourceKey(url), err)
+}
+
+// get makes one attempt. retryAfter is the server's Retry-After, if any.
+func (d *ImageDownloader) get(ctx context.Context, url string) (body []byte, retryAfter time.Duration, err error) {
+	client := d.Client
+	if client == nil {
+		client = defaultDownloadClient
+	}
+	maxBytes := d.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxImageBytes
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return nil, retryAfter, &DownloadStatusError{URL: sourceKey(url), StatusCode: resp.StatusCode}
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, 0, fmt.Errorf("%w: %d bytes, limit %d", ErrImageTooLarge, resp.ContentLength, maxBytes)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !imageContentTypeHeader(ct) {
+		return nil, 0, fmt.Errorf("%w: served as %s", ErrNotAnImage, ct)
+	}
+
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		// Connection dropped mid-body: worth another try.
+		return nil, 0, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, 0, fmt.Errorf("%w: more than %d bytes", ErrImageTooLarge, maxBytes)
+	}
+	if resp.ContentLength >= 0 && int64(len(body)) != resp.ContentLength {
+		return nil, 0, fmt.Errorf("truncated body: got %d of %d bytes", len(body), resp.ContentLength)
+	}
+
+	types := d.Types
+	if len(types) == 0 {
+		types = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+	}
+	if sniffed := http.DetectContentType(body); !slices.Contains(types, sniffed) {
+		return nil, 0, fmt.Errorf("%w: content is %s", ErrNotAnImage, sniffed)
+	}
+	return body, 0, nil
+}
+
+// imageContentTypeHeader accepts image/* plus the generic binary types S3
+// and CDNs use for uploads without a recorded type; the body is sniffed
+// either way.
+func imageContentTypeHeader(ct string) bool {
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/octet-stream", "binary/octet-stream":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "image/")
+}
+
+// retryable reports whether another attempt could succeed: network errors
+// and truncated bodies, 408, 429 and 5xx. Other 4xx (an expired signed URL
+// is a 403) and bad content won't change on retry.
+func retryable(err error) bool {
+	if errors.Is(err, ErrImageTooLarge) || errors.Is(err, ErrNotAnImage) {
+		return false
+	}
+	var statusErr *DownloadStatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package notion
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pngFixture(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// downloadServer serves handler and counts requests.
+func downloadServer(t *testing.T, handler func(w http.ResponseWriter, n int)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, int(calls.Add(1)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestImageDownloader_Get(t *testing.T) {
+	img := pngFixture(t)
+	s3Error := `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code></Error>`
+	d := &ImageDownloader{Backoff: time.Millisecond, MaxBytes: 1 << 10}
+
+	cases := []struct {
+		name    string
+		handler func(w http.ResponseWriter, n int)
+		wantErr error
+		calls   int32
+	}{
+		{"ok with a generic binary type", func(w http.ResponseWriter, n int) {
+			w.Header().Set("Content-Type", "binary/octet-stream")
+			w.Write(img)
+		}, nil, 1},
+		{"retries transient failures", func(w http.ResponseWriter, n int) {
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(img)
+		}, nil, 3},
+		{"gives up after the attempts", func(w http.ResponseWriter, n int) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, &DownloadStatusError{}, 3},
+		{"no retry on an expired link", func(w http.ResponseWriter, n int) {
+			w.WriteHeader(http.StatusForbidden)
+		}, &DownloadStatusError{}, 1},
+		{"S3 error document with a 200", func(w http.ResponseWriter, n int) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(s3Error))
+		}, ErrNotAnImage, 1},
+		{"non-image body under an image type", func(w http.ResponseWriter, n int) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(s3Error))
+		}, ErrNotAnImage, 1},
+		{"declared too large", func(w http.ResponseWriter, n int) {
+			w.Header().Set("Content-Length", "4096")
+			w.Write(make([]byte, 4096))
+		}, ErrImageTooLarge, 1},
+		{"streamed too large", func(w http.ResponseWriter, n int) {
+			w.Write(img)
+			w.(http.Flusher).Flush() // chunked: no Content-Length
+			w.Write(make([]byte, 2048))
+		}, ErrImageTooLarge, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, calls := downloadServer(t, tc.handler)
+			body, err := d.Get(context.Background(), srv.URL+"/img.png?X-Amz-Signature=secret")
+			switch want := tc.wantErr.(type) {
+			case nil:
+				if err != nil || !bytes.Equal(body, img) {
+					t.Fatalf("got %d bytes, %v", len(body), err)
+				}
+			case *DownloadStatusError:
+				var statusErr *DownloadStatusError
+				if !errors.As(err, &statusErr) {
+					t.Fatalf("want a status error, got %v", err)
+				}
+				if strings.Contains(err.Error(), "secret") {
+					t.Fatalf("signed query leaked into the error: %v", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Fatalf("want %v, got %v", want, err)
+				}
+			}
+			if got := calls.Load(); got != tc.calls {
+				t.Fatalf("requests: got %d, want %d", got, tc.calls)
+			}
+		})
+	}
+}
+
+func TestImageDownloader_Timeout(t *testing.T) {
+	srv, calls := downloadServer(t, func(w http.ResponseWriter, n int) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	d := &ImageDownloader{Client: &http.Client{Timeout: 20 * time.Millisecond}, Attempts: 2, Backoff: time.Millisecond}
+	if _, err := d.Get(context.Background(), srv.URL); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("timeouts should be retried: %d requests", calls.Load())
+	}
+}
+
+func TestImageDownloader_CancelStopsRetryWait(t *testing.T) {
+	srv, calls := downloadServer(t, func(w http.ResponseWriter, n int) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := (&ImageDownloader{}).Get(ctx, srv.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want the context error, got %v", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Fatalf("waited %v for Retry-After after the context ended", waited)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("requests: %d", calls.Load())
+	}
+}
+
+func TestDownloadAndStoreImage_KeepsGoodImageOnBadResponse(t *testing.T) {
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+	defer func(d *ImageDownloader) { imageDownloader = d }(imageDownloader)
+	imageDownloader = &ImageDownloader{Backoff: time.Millisecond}
+
+	img := pngFixture(t)
+	broken := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte("<Error><Code>AccessDenied</Code></Error>"))
+			return
+		}
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	good, err := downloadAndStoreImage(context.Background(), "block", srv.URL+"/v1/photo.png")
+	if err != nil {
+		t.Fatalf("first store: %v", err)
+	}
+	broken = true
+	// The image was replaced in Notion, but the new link returns an error.
+	got, err := downloadAndStoreImage(context.Background(), "block", srv.URL+"/v2/photo.png")
+	if err != nil || got != good {
+		t.Fatalf("want the previous image %s, got %s, %v", good, got, err)
+	}
+	if imageKey("block") != imageDigest(img) {
+		t.Fatal("ref should still point at the good image")
+	}
+	if _, err := downloadAndStoreImage(context.Background(), "new-block", srv.URL+"/v2/other.png"); !errors.Is(err, ErrNotAnImage) {
+		t.Fatalf("nothing to fall back to: want ErrNotAnImage, got %v", err)
+	}
+}
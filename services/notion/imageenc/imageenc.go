@@ -1,31 +1,207 @@
-// Package imageenc wraps the `cwebp` binary and stdlib image decoders so the
-// rest of the codebase can stay free of cgo and WebP-specific Go deps.
+// Package imageenc wraps the `cwebp`/`avifenc` binaries and stdlib image
+// decoders so the rest of the codebase can stay free of cgo and
+// format-specific Go deps.
 package imageenc
 
 import (
 	"fmt"
+	"htmx-blog/metrics"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-// Quality is the fixed cwebp -q value. 85 matches the design decision.
-const Quality = 85
+// Encoder produces one derived image format from a source file.
+type Encoder interface {
+	// Format is the file extension (no dot) and the ImageMeta format key.
+	Format() string
+	// MIMEType is the <source type> value for the format.
+	MIMEType() string
+	// Available reports whether the encoder can run on this host.
+	Available() bool
+	// Encode writes srcPath to dstPath in the encoder's format, scaled down
+	// to width pixels wide (aspect ratio kept). width <= 0 keeps the size.
+	Encode(srcPath, dstPath string, width int) error
+}
 
-// EncodeWebP shells out to `cwebp -q 85 -quiet -o dstPath srcPath`.
-// Returns an error with combined stderr when cwebp is missing or exits non-zero.
-func EncodeWebP(srcPath, dstPath string) error {
-	cmd := exec.Command("cwebp", "-q", fmt.Sprintf("%d", Quality), "-quiet", "-o", dstPath, srcPath)
+var (
+	// WebP encodes with cwebp.
+	WebP Encoder = cwebpEncoder{}
+	// AVIF encodes with avifenc.
+	AVIF Encoder = avifEncoder{}
+)
+
+// Encoders lists every known encoder in <picture> preference order: smallest
+// output first, so browsers that support it pick AVIF over WebP.
+func Encoders() []Encoder {
+	return []Encoder{AVIF, WebP}
+}
+
+var (
+	encodeDuration = metrics.NewHistogram("blog_image_encode_duration_seconds",
+		"Image encode time by output format.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}, "format")
+	encodeFailures = metrics.NewCounter("blog_image_encode_failures_total",
+		"Failed image encodes by output format.", "format")
+)
+
+// timed runs one encode to format and records how long it took.
+func timed(format string, encode func() error) error {
+	start := time.Now()
+	err := encode()
+	encodeDuration.Observe(time.Since(start).Seconds(), format)
+	if err != nil {
+		encodeFailures.Inc(format)
+	}
+	return err
+}
+
+type cwebpEncoder struct{}
+
+func (cwebpEncoder) Format() string   { return "webp" }
+func (cwebpEncoder) MIMEType() string { return "image/webp" }
+func (cwebpEncoder) Available() bool  { return Available() }
+
+func (e cwebpEncoder) Encode(srcPath, dstPath string, width int) error {
+	return e.encodeQuality(srcPath, dstPath, width, Quality)
+}
+
+// encodeQuality is the one place cwebp is run: `cwebp -q quality -quiet
+// [-resize width 0] -o dstPath srcPath`. Returns an error with the combined
+// output when cwebp is missing or exits non-zero.
+func (cwebpEncoder) encodeQuality(srcPath, dstPath string, width, quality int) error {
+	args := []string{"-q", fmt.Sprintf("%d", quality), "-quiet"}
+	if width > 0 {
+		args = append(args, "-resize", fmt.Sprintf("%d", width), "0")
+	}
+	return timed("webp", func() error {
+		out, err := exec.Command("cwebp", append(args, "-o", dstPath, srcPath)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("cwebp %s -> %s: %w (output: %s)", strings.Join(args, " "), dstPath, err, string(out))
+		}
+		return nil
+	})
+}
+
+// qualityEncoder is implemented by encoders whose quality can be chosen per
+// call.
+type qualityEncoder interface {
+	encodeQuality(srcPath, dstPath string, width, quality int) error
+}
+
+// EncodeQuality is enc.Encode at quality (1-100) instead of the encoder's
+// default. Encoders without a quality setting (PNG) ignore it, as does
+// quality <= 0.
+func EncodeQuality(enc Encoder, srcPath, dstPath string, width, quality int) error {
+	if qe, ok := enc.(qualityEncoder); ok && quality > 0 {
+		return qe.encodeQuality(srcPath, dstPath, width, quality)
+	}
+	return enc.Encode(srcPath, dstPath, width)
+}
+
+// AVIFSpeed is the avifenc -s value; 6 is a reasonable size/CPU trade-off for
+// a one-off encode per image.
+const AVIFSpeed = 6
+
+type avifEncoder struct{}
+
+func (avifEncoder) Format() string   { return "avif" }
+func (avifEncoder) MIMEType() string { return "image/avif" }
+
+func (avifEncoder) Available() bool {
+	_, err := exec.LookPath("avifenc")
+	return err == nil
+}
+
+// Encode shells out to avifenc. avifenc can't resize and only reads PNG/JPEG,
+// so anything else (GIF, WebP input, or a width) goes through a scaled PNG
+// written next to dstPath first.
+func (e avifEncoder) Encode(srcPath, dstPath string, width int) error {
+	return e.encodeQuality(srcPath, dstPath, width, Quality)
+}
+
+func (e avifEncoder) encodeQuality(srcPath, dstPath string, width, quality int) error {
+	return timed("avif", func() error {
+		return e.encode(srcPath, dstPath, width, quality)
+	})
+}
+
+func (avifEncoder) encode(srcPath, dstPath string, width, quality int) error {
+	input := srcPath
+	ext := filepath.Ext(srcPath)
+	if width > 0 || (ext != ".png" && ext != ".jpg" && ext != ".jpeg") {
+		tmp := dstPath + ".src.png"
+		if err := (nativeEncoder{format: "png"}).encode(srcPath, tmp, width, JPEGQuality); err != nil {
+			return fmt.Errorf("avif: preparing %s: %w", srcPath, err)
+		}
+		defer os.Remove(tmp)
+		input = tmp
+	}
+	cmd := exec.Command("avifenc", "-q", fmt.Sprintf("%d", quality), "-s", fmt.Sprintf("%d", AVIFSpeed),
+		"--ignore-exif", "--ignore-xmp", input, dstPath)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("cwebp %s -> %s: %w (output: %s)", srcPath, dstPath, err, string(out))
+		return fmt.Errorf("avifenc %s -> %s: %w (output: %s)", srcPath, dstPath, err, string(out))
 	}
 	return nil
 }
 
+// scaleDown averages every source pixel that falls into each destination
+// pixel (a box filter). Good enough for downscaling photos; no upscaling.
+func scaleDown(src image.Image, w, h int) image.Image {
+	if h < 1 {
+		h = 1
+	}
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			// Averages are premultiplied; un-premultiply into NRGBA.
+			if a == 0 {
+				continue
+			}
+			dst.Pix[i+0] = uint8(r * 0xff / a)
+			dst.Pix[i+1] = uint8(g * 0xff / a)
+			dst.Pix[i+2] = uint8(bl * 0xff / a)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// Quality is the fixed cwebp -q value. 85 matches the design decision.
+const Quality = 85
+
+// EncodeWebP encodes srcPath to WebP at dstPath at Quality.
+func EncodeWebP(srcPath, dstPath string) error {
+	return WebP.Encode(srcPath, dstPath, 0)
+}
+
+// EncodeWebPWidth is EncodeWebP scaled down to width pixels wide, keeping the
+// aspect ratio. Callers should not ask for a width larger than the source;
+// cwebp would upscale.
+func EncodeWebPWidth(srcPath, dstPath string, width int) error {
+	return WebP.Encode(srcPath, dstPath, width)
+}
+
 // ReadDimensions returns the intrinsic pixel width and height of an image file.
 // Supports PNG, JPEG, GIF via stdlib decoders registered via blank imports.
 func ReadDimensions(srcPath string) (int, int, error) {
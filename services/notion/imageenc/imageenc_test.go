@@ -2,6 +2,8 @@ package imageenc
 
 import (
 	"bytes"
+	"encoding/base64"
+	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
@@ -9,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -97,3 +100,132 @@ func TestEncodeWebP_MissingBinary(t *testing.T) {
 	}
 }
 
+
+func TestEncodeWebPWidth_Downscales(t *testing.T) {
+	if _, err := exec.LookPath("cwebp"); err != nil {
+		t.Skip("cwebp not on PATH; skipping")
+	}
+	dir := t.TempDir()
+	img := sampleImage()
+	src := writeFixture(t, dir, "src.png", func(f *os.File) error { return png.Encode(f, img) })
+	dst := filepath.Join(dir, "src-32.webp")
+	if err := EncodeWebPWidth(src, dst, 32); err != nil {
+		t.Fatalf("EncodeWebPWidth: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("read dst: %v", err)
+	}
+	if len(data) < 12 || !bytes.Equal(data[8:12], []byte("WEBP")) {
+		t.Fatalf("dst missing WebP magic bytes: %q", data[:min(12, len(data))])
+	}
+}
+
+func TestAVIFEncoder_Encode(t *testing.T) {
+	if !AVIF.Available() {
+		t.Skip("avifenc not on PATH; skipping")
+	}
+	dir := t.TempDir()
+	img := sampleImage()
+	src := writeFixture(t, dir, "src.png", func(f *os.File) error { return png.Encode(f, img) })
+	for _, width := range []int{0, 32} {
+		dst := filepath.Join(dir, fmt.Sprintf("src-%d.avif", width))
+		if err := AVIF.Encode(src, dst, width); err != nil {
+			t.Fatalf("Encode(width=%d): %v", width, err)
+		}
+		data, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("read dst: %v", err)
+		}
+		// ISO-BMFF "ftyp" box at offset 4.
+		if len(data) < 12 || !bytes.Equal(data[4:8], []byte("ftyp")) {
+			t.Fatalf("dst missing ftyp box: %q", data[:min(12, len(data))])
+		}
+		if _, err := os.Stat(dst + ".src.png"); !os.IsNotExist(err) {
+			t.Fatalf("temp PNG left behind: %v", err)
+		}
+	}
+}
+
+func TestEncoders_PreferenceOrder(t *testing.T) {
+	var formats []string
+	for _, enc := range Encoders() {
+		formats = append(formats, enc.Format())
+	}
+	if len(formats) != 2 || formats[0] != "avif" || formats[1] != "webp" {
+		t.Fatalf("encoder order: %v", formats)
+	}
+}
+
+func TestNativeEncoder_Downscales(t *testing.T) {
+	dir := t.TempDir()
+	src := writeFixture(t, dir, "src.jpg", func(f *os.File) error {
+		return jpeg.Encode(f, sampleImage(), &jpeg.Options{Quality: 90})
+	})
+	enc, ok := Native("jpg")
+	if !ok {
+		t.Fatal("expected a native jpg encoder")
+	}
+	dst := filepath.Join(dir, "scaled.jpg")
+	if err := enc.Encode(src, dst, 16); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	w, h, err := ReadDimensions(dst)
+	if err != nil {
+		t.Fatalf("ReadDimensions: %v", err)
+	}
+	if w != 16 || h != 12 {
+		t.Fatalf("dims: want 16x12, got %dx%d", w, h)
+	}
+	if _, ok := Native("gif"); ok {
+		t.Fatal("gif has no native encoder")
+	}
+}
+
+func TestMakePlaceholder(t *testing.T) {
+	dir := t.TempDir()
+	// Mostly blue with a thin red strip: dominant colour is the blue.
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := range 100 {
+		for x := range 200 {
+			c := color.RGBA{R: 20, G: 40, B: 220, A: 255}
+			if y >= 90 {
+				c = color.RGBA{R: 230, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	src := writeFixture(t, dir, "sky.png", func(f *os.File) error { return png.Encode(f, img) })
+
+	p, err := MakePlaceholder(src)
+	if err != nil {
+		t.Fatalf("MakePlaceholder: %v", err)
+	}
+	if p.DominantColor != "#1428dc" {
+		t.Fatalf("dominant colour: got %s", p.DominantColor)
+	}
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(p.LQIP, prefix) || len(p.LQIP) > 1024 {
+		t.Fatalf("lqip: %d bytes, %.40q", len(p.LQIP), p.LQIP)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p.LQIP, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != PlaceholderWidth || cfg.Height != 8 {
+		t.Fatalf("thumb dims: %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestMakePlaceholder_Transparent(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20)) // fully transparent
+	src := writeFixture(t, dir, "logo.png", func(f *os.File) error { return png.Encode(f, img) })
+	if _, err := MakePlaceholder(src); err != ErrTransparent {
+		t.Fatalf("want ErrTransparent, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package imageenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+)
+
+// SanitizeJPEGQuality is used when a JPEG has to be re-encoded to bake in its
+// EXIF orientation. Unrotated JPEGs are stripped losslessly instead.
+const SanitizeJPEGQuality = 90
+
+// SanitizeVersion goes up whenever Sanitize starts removing something it used
+// to keep, so originals sanitized by an older version are done again.
+// Version 2 drops pictures appended after a JPEG's EOI.
+const SanitizeVersion = 2
+
+// ErrUnsupportedFormat is returned by Sanitize for data it can't vouch for.
+var ErrUnsupportedFormat = errors.New("imageenc: unsupported image format")
+
+// Sanitize removes EXIF (including GPS), XMP, IPTC and text metadata from a
+// JPEG, PNG or WebP image. A JPEG whose EXIF orientation isn't "normal" is
+// decoded, rotated upright and re-encoded, since the orientation tag goes
+// away with the rest of the EXIF block; rotated reports when that happened.
+// Other inputs are rewritten losslessly. GIFs carry no EXIF and pass through.
+func Sanitize(data []byte) (out []byte, rotated bool, err error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return sanitizeJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		out, err := stripPNG(data)
+		return out, false, err
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		out, err := stripWebP(data)
+		return out, false, err
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return data, false, nil
+	}
+	return nil, false, ErrUnsupportedFormat
+}
+
+func sanitizeJPEG(data []byte) ([]byte, bool, error) {
+	orientation := jpegOrientation(data)
+	if orientation <= 1 || orientation > 8 {
+		out, err := stripJPEG(data)
+		return out, false, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	var buf bytes.Buffer
+	// image/jpeg writes no APPn segments, so this drops all metadata too.
+	if err := jpeg.Encode(&buf, orient(img, orientation), &jpeg.Options{Quality: SanitizeJPEGQuality}); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// jpegSegments calls fn for each marker segment before the scan data, with
+// the segment's full bytes (marker included). It returns the offset of the
+// SOS marker, where entropy-coded data begins.
+func jpegSegments(data []byte, fn func(marker byte, payload, segment []byte)) (int, error) {
+	i := 2 // past SOI
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return 0, errors.New("imageenc: malformed JPEG marker")
+		}
+		// Skip fill bytes.
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			break
+		}
+		marker := data[i+1]
+		if marker == 0xDA { // SOS
+			return i, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			fn(marker, nil, data[i:i+2])
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if n < 2 || i+2+n > len(data) {
+			break
+		}
+		fn(marker, data[i+4:i+2+n], data[i:i+2+n])
+		i += 2 + n
+	}
+	return 0, errors.New("imageenc: truncated JPEG")
+}
+
+// stripJPEG drops APP1 (EXIF/XMP), APP13 (IPTC) and COM segments, keeping
+// JFIF, ICC (APP2) and Adobe (APP14) so colours render the same. Everything
+// after the image's EOI goes too, along with the APP2 MPF index that points
+// at it: phone cameras append secondary JPEGs there (previews, depth maps),
+// each with its own EXIF and GPS.
+func stripJPEG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	sos, err := jpegSegments(data, func(marker byte, payload, segment []byte) {
+		switch marker {
+		case 0xE1, 0xED, 0xFE:
+			return
+		case 0xE2:
+			if bytes.HasPrefix(payload, []byte("MPF\x00")) {
+				return
+			}
+		}
+		out = append(out, segment...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, data[sos:jpegEnd(data, sos)]...), nil
+}
+
+// jpegEnd returns the offset just past the EOI marker ending the image whose
+// first scan starts at sos, skipping the marker segments between the scans
+// of a progressive JPEG. Without an EOI it is len(data).
+func jpegEnd(data []byte, sos int) int {
+	i := sos
+	for i+1 < len(data) {
+		if data[i] != 0xFF {
+			i++
+			continue
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xD9:
+			return i + 2
+		case marker == 0xFF:
+			// Fill byte before a marker.
+			i++
+		case marker == 0x00, marker >= 0xD0 && marker <= 0xD7:
+			// Stuffed 0xFF or restart marker inside scan data.
+			i += 2
+		default:
+			// A segment between scans (SOS, DHT, DQT, DRI...): skip its
+			// payload, which may hold any bytes.
+			if i+4 > len(data) {
+				return len(data)
+			}
+			i += 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		}
+	}
+	return len(data)
+}
+
+// jpegOrientation returns the EXIF orientation (1-8), or 0 if there is none.
+func jpegOrientation(data []byte) int {
+	orientation := 0
+	_, _ = jpegSegments(data, func(marker byte, payload, _ []byte) {
+		if marker == 0xE1 && orientation == 0 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			orientation = exifOrientation(payload[6:])
+		}
+	})
+	return orientation
+}
+
+// exifOrientation reads tag 0x0112 from IFD0 of a TIFF-structured EXIF block.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for k := 0; k < count; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 0
+}
+
+// orient returns img transformed so that EXIF orientation o displays upright.
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG drops the eXIf, tEXt, zTXt, iTXt and tIME chunks. Other chunks are
+// copied byte-for-byte, CRCs included.
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errors.New("imageenc: truncated PNG chunk")
+		}
+		n := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, errors.New("imageenc: truncated PNG chunk")
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks of a RIFF/WebP file and clears
+// their flags in the VP8X header.
+func stripWebP(data []byte) ([]byte, error) {
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errors.New("imageenc: truncated WebP chunk")
+		}
+		n := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + n + n%2 // chunks are padded to even length
+		if n < 0 || end > len(data) {
+			return nil, errors.New("imageenc: truncated WebP chunk")
+		}
+		switch fourcc := string(data[i : i+4]); fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if n > 0 {
+				out[start+8] &^= 0x08 | 0x04 // EXIF, XMP flags
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
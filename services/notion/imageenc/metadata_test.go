@@ -0,0 +1,263 @@
+package imageenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// exifAPP1 builds an APP1 segment with a little-endian TIFF block holding
+// IFD0 {Orientation, GPSInfo pointer} and a GPS IFD with a latitude ref, which
+// is enough for "GPS" bytes to be findable in the output if stripping fails.
+func exifAPP1(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	tiff.WriteString("II")
+	binary.Write(&tiff, le, uint16(42))
+	binary.Write(&tiff, le, uint32(8))
+	// IFD0 at 8: 2 entries.
+	binary.Write(&tiff, le, uint16(2))
+	binary.Write(&tiff, le, [6]uint16{0x0112, 3, 1, 0, orientation, 0})
+	binary.Write(&tiff, le, [4]uint16{0x8825, 4, 1, 0})
+	binary.Write(&tiff, le, uint32(8+2+24+4)) // GPS IFD right after IFD0
+	binary.Write(&tiff, le, uint32(0))        // no IFD1
+	// GPS IFD: GPSLatitudeRef = "N".
+	binary.Write(&tiff, le, uint16(1))
+	binary.Write(&tiff, le, [4]uint16{0x0001, 2, 2, 0})
+	tiff.WriteString("N\x00\x00\x00")
+	binary.Write(&tiff, le, uint32(0))
+	tiff.WriteString("GPSMARKER")
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// jpegWithEXIF encodes a w x h JPEG whose left half is red and right half
+// blue, with an EXIF segment inserted after SOI.
+func jpegWithEXIF(t *testing.T, w, h int, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, exifAPP1(orientation)...)
+	return append(out, data[2:]...)
+}
+
+func TestSanitize_JPEGStripsEXIFLosslessly(t *testing.T) {
+	data := jpegWithEXIF(t, 64, 32, 1)
+	if jpegOrientation(data) != 1 {
+		t.Fatalf("fixture orientation: %d", jpegOrientation(data))
+	}
+	out, rotated, err := Sanitize(data)
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+	if rotated {
+		t.Fatal("orientation 1 should not rotate")
+	}
+	if bytes.Contains(out, []byte("Exif")) || bytes.Contains(out, []byte("GPSMARKER")) {
+		t.Fatal("EXIF/GPS survived sanitizing")
+	}
+	// Scan data is untouched: output is the input minus the APP1 segment.
+	if want := len(data) - len(exifAPP1(1)); len(out) != want {
+		t.Fatalf("len: want %d, got %d", want, len(out))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("sanitized JPEG doesn't decode: %v", err)
+	}
+}
+
+func TestSanitize_JPEGDropsAppendedPictures(t *testing.T) {
+	primary := jpegWithEXIF(t, 64, 32, 1)
+	// An MPF index after the EXIF, and a second JPEG with its own GPS EXIF
+	// appended after EOI, as phone cameras write them.
+	mpfPayload := []byte("MPF\x00II\x2a\x00\x08\x00\x00\x00")
+	mpf := append([]byte{0xFF, 0xE2, 0, byte(len(mpfPayload) + 2)}, mpfPayload...)
+	data := append([]byte{0xFF, 0xD8}, mpf...)
+	data = append(data, primary[2:]...)
+	data = append(data, jpegWithEXIF(t, 16, 16, 1)...)
+
+	out, _, err := Sanitize(data)
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+	if bytes.Contains(out, []byte("GPSMARKER")) || bytes.Contains(out, []byte("MPF\x00")) {
+		t.Fatal("appended picture or its index survived sanitizing")
+	}
+	if want := len(primary) - len(exifAPP1(1)); len(out) != want || !bytes.HasSuffix(out, []byte{0xFF, 0xD9}) {
+		t.Fatalf("want the primary image only (%d bytes ending in EOI), got %d", want, len(out))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("sanitized JPEG doesn't decode: %v", err)
+	}
+}
+
+func TestJPEGEnd_SkipsSegmentsBetweenScans(t *testing.T) {
+	data := []byte{
+		0xFF, 0xDA, 0x00, 0x03, 0x01, // SOS
+		0x12, 0xFF, 0x00, 0x34, 0xFF, 0xD0, 0x56, // scan: stuffed byte, RST0
+		0xFF, 0xC4, 0x00, 0x05, 0xFF, 0xD9, 0x00, // DHT whose payload looks like EOI
+		0xFF, 0xDA, 0x00, 0x03, 0x01, 0x78, // second scan
+		0xFF, 0xFF, 0xD9, // fill byte, EOI
+		0xFF, 0xD8, 0xAA, // appended picture
+	}
+	if got, want := jpegEnd(data, 0), len(data)-3; got != want {
+		t.Fatalf("jpegEnd: got %d, want %d", got, want)
+	}
+	if got := jpegEnd(data[:10], 0); got != 10 {
+		t.Fatalf("without EOI: got %d", got)
+	}
+}
+
+func TestSanitize_JPEGAutoRotates(t *testing.T) {
+	// Orientation 6: stored sideways, display rotated 90° clockwise.
+	out, rotated, err := Sanitize(jpegWithEXIF(t, 64, 32, 6))
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+	if !rotated {
+		t.Fatal("expected rotation")
+	}
+	if bytes.Contains(out, []byte("GPSMARKER")) {
+		t.Fatal("GPS survived re-encode")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 64 {
+		t.Fatalf("dims: want 32x64, got %dx%d", b.Dx(), b.Dy())
+	}
+	// Red (stored left) ends up on top after a clockwise turn.
+	if r, _, bl, _ := img.At(16, 8).RGBA(); r < bl {
+		t.Fatalf("top should be red, got r=%d b=%d", r>>8, bl>>8)
+	}
+	if r, _, bl, _ := img.At(16, 56).RGBA(); bl < r {
+		t.Fatalf("bottom should be blue, got r=%d b=%d", r>>8, bl>>8)
+	}
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	var c bytes.Buffer
+	binary.Write(&c, binary.BigEndian, uint32(len(data)))
+	c.WriteString(typ)
+	c.Write(data)
+	binary.Write(&c, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+	return c.Bytes()
+}
+
+func TestSanitize_PNGDropsTextAndEXIFChunks(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sampleImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Insert after IHDR (8-byte signature + 25-byte IHDR chunk).
+	var withMeta []byte
+	withMeta = append(withMeta, data[:33]...)
+	withMeta = append(withMeta, pngChunk("eXIf", []byte("MM\x00\x2aGPSMARKER"))...)
+	withMeta = append(withMeta, pngChunk("tEXt", []byte("Comment\x00taken at home"))...)
+	withMeta = append(withMeta, data[33:]...)
+
+	out, _, err := Sanitize(withMeta)
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("expected exactly the original PNG back")
+	}
+}
+
+func TestSanitize_WebPDropsEXIFAndXMP(t *testing.T) {
+	chunk := func(fourcc string, data []byte) []byte {
+		var c bytes.Buffer
+		c.WriteString(fourcc)
+		binary.Write(&c, binary.LittleEndian, uint32(len(data)))
+		c.Write(data)
+		if len(data)%2 == 1 {
+			c.WriteByte(0)
+		}
+		return c.Bytes()
+	}
+	var body []byte
+	body = append(body, "WEBP"...)
+	body = append(body, chunk("VP8X", []byte{0x08 | 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	body = append(body, chunk("VP8L", []byte{0x2f, 1, 2})...)
+	body = append(body, chunk("EXIF", []byte("GPSMARKER"))...)
+	body = append(body, chunk("XMP ", []byte("<x/>"))...)
+	data := append([]byte("RIFF\x00\x00\x00\x00"), body...)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+
+	out, _, err := Sanitize(data)
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+	if bytes.Contains(out, []byte("GPSMARKER")) || bytes.Contains(out, []byte("XMP ")) {
+		t.Fatal("metadata chunks survived")
+	}
+	if flags := out[20]; flags&(0x08|0x04) != 0 {
+		t.Fatalf("VP8X flags not cleared: %#x", flags)
+	}
+	if size := binary.LittleEndian.Uint32(out[4:8]); int(size) != len(out)-8 {
+		t.Fatalf("RIFF size %d, want %d", size, len(out)-8)
+	}
+}
+
+func TestSanitize_Unsupported(t *testing.T) {
+	if _, _, err := Sanitize([]byte("\x00\x00\x00\x18ftypheic")); err != ErrUnsupportedFormat {
+		t.Fatalf("want ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestOrient_AllOrientationsMapCorners(t *testing.T) {
+	// 2x1 source: (0,0) red, (1,0) blue.
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	cases := []struct {
+		o      int
+		w, h   int
+		redAt  image.Point
+		blueAt image.Point
+	}{
+		{1, 2, 1, image.Pt(0, 0), image.Pt(1, 0)},
+		{2, 2, 1, image.Pt(1, 0), image.Pt(0, 0)},
+		{3, 2, 1, image.Pt(1, 0), image.Pt(0, 0)},
+		{4, 2, 1, image.Pt(0, 0), image.Pt(1, 0)},
+		{5, 1, 2, image.Pt(0, 0), image.Pt(0, 1)},
+		{6, 1, 2, image.Pt(0, 0), image.Pt(0, 1)},
+		{7, 1, 2, image.Pt(0, 1), image.Pt(0, 0)},
+		{8, 1, 2, image.Pt(0, 1), image.Pt(0, 0)},
+	}
+	for _, tc := range cases {
+		got := orient(src, tc.o)
+		if b := got.Bounds(); b.Dx() != tc.w || b.Dy() != tc.h {
+			t.Fatalf("o=%d dims %dx%d, want %dx%d", tc.o, b.Dx(), b.Dy(), tc.w, tc.h)
+		}
+		if got.At(tc.redAt.X, tc.redAt.Y) != red || got.At(tc.blueAt.X, tc.blueAt.Y) != blue {
+			t.Fatalf("o=%d: red/blue not at %v/%v", tc.o, tc.redAt, tc.blueAt)
+		}
+	}
+}
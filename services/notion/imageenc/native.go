@@ -0,0 +1,83 @@
+package imageenc
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+)
+
+// JPEGQuality is the quality used for pure-Go JPEG variants.
+const JPEGQuality = 82
+
+// nativeEncoder re-encodes JPEG or PNG with the standard library. It is the
+// fallback when no external encoder is installed: no format conversion, but
+// downscaled variants still spare phones the full-resolution file.
+type nativeEncoder struct {
+	format string
+}
+
+// Native returns the pure-Go encoder for format ("jpg" or "png"). ok is false
+// for formats the standard library can't write.
+func Native(format string) (enc Encoder, ok bool) {
+	switch format {
+	case "jpg", "png":
+		return nativeEncoder{format: format}, true
+	}
+	return nil, false
+}
+
+func (e nativeEncoder) Format() string { return e.format }
+
+func (e nativeEncoder) MIMEType() string {
+	if e.format == "png" {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+func (nativeEncoder) Available() bool { return true }
+
+// Encode decodes srcPath, box-scales it down to width (if narrower) and
+// writes it in the encoder's format. Output carries no metadata.
+func (e nativeEncoder) Encode(srcPath, dstPath string, width int) error {
+	return e.encodeQuality(srcPath, dstPath, width, JPEGQuality)
+}
+
+func (e nativeEncoder) encodeQuality(srcPath, dstPath string, width, quality int) error {
+	return timed(e.format, func() error {
+		return e.encode(srcPath, dstPath, width, quality)
+	})
+}
+
+// encode is encodeQuality without the metrics, for intermediate files.
+func (e nativeEncoder) encode(srcPath, dstPath string, width, quality int) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", srcPath, err)
+	}
+	if b := img.Bounds(); width > 0 && width < b.Dx() {
+		img = scaleDown(img, width, (b.Dy()*width+b.Dx()/2)/b.Dx())
+	}
+	out, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if e.format == "png" {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(out, img)
+	} else {
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(dstPath)
+		return fmt.Errorf("encode %s: %w", dstPath, err)
+	}
+	return out.Close()
+}
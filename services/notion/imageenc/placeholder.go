@@ -0,0 +1,84 @@
+package imageenc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+)
+
+// PlaceholderWidth is the width of the LQIP thumbnail. 16px keeps the data
+// URI well under 1KB while still showing the rough shape of a photo.
+const PlaceholderWidth = 16
+
+// Placeholder is a tiny stand-in shown while the real image loads.
+type Placeholder struct {
+	// LQIP is a data: URI of a PlaceholderWidth-wide PNG thumbnail.
+	LQIP string
+	// DominantColor is the most common colour as "#rrggbb".
+	DominantColor string
+}
+
+// ErrTransparent is returned by MakePlaceholder for images with an alpha
+// channel in use: a coloured backdrop would show through them.
+var ErrTransparent = errors.New("imageenc: image has transparency")
+
+// MakePlaceholder decodes srcPath and returns its LQIP and dominant colour.
+func MakePlaceholder(srcPath string) (Placeholder, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return Placeholder{}, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return Placeholder{}, err
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		return Placeholder{}, ErrTransparent
+	}
+
+	b := img.Bounds()
+	thumb := img
+	if b.Dx() > PlaceholderWidth {
+		thumb = scaleDown(img, PlaceholderWidth, (b.Dy()*PlaceholderWidth+b.Dx()/2)/b.Dx())
+	}
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, thumb); err != nil {
+		return Placeholder{}, err
+	}
+	return Placeholder{
+		LQIP:          "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		DominantColor: dominantColor(thumb),
+	}, nil
+}
+
+// dominantColor buckets pixels by their top 3 bits per channel (512 buckets)
+// and returns the mean colour of the fullest bucket, so a photo of a blue sky
+// over a thin strip of sand comes out blue rather than a muddy average.
+func dominantColor(img image.Image) string {
+	type bucket struct{ r, g, b, n uint64 }
+	var buckets [512]bucket
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			r8, g8, b8 := r>>8, g>>8, bl>>8
+			k := &buckets[(r8>>5)<<6|(g8>>5)<<3|b8>>5]
+			k.r, k.g, k.b, k.n = k.r+uint64(r8), k.g+uint64(g8), k.b+uint64(b8), k.n+1
+		}
+	}
+	best := buckets[0]
+	for _, k := range buckets[1:] {
+		if k.n > best.n {
+			best = k
+		}
+	}
+	if best.n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
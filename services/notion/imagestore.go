@@ -1,13 +1,19 @@
 package notion
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	log "htmx-blog/logging"
 	"htmx-blog/services/notion/imageenc"
+	"image"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
 // ImageMeta is the sidecar written alongside every stored image.
@@ -17,6 +23,349 @@ type ImageMeta struct {
 	Height      int    `json:"height"`
 	FallbackExt string `json:"fallbackExt"` // "png" / "jpg" / "gif" / etc, no dot
 	HasWebP     bool   `json:"hasWebP"`
+	// Formats lists the derived full-size formats on disk at <id>.<format>
+	// ("avif", "webp"). Sidecars written before AVIF only have HasWebP;
+	// HasFormat treats the two as one.
+	Formats []string `json:"formats,omitempty"`
+	// Variants are downscaled copies at <id>-<width>.<format>, narrowest
+	// first. Only widths smaller than the original are produced.
+	Variants []ImageVariant `json:"variants,omitempty"`
+	// Sanitized is set once the original has had its EXIF/XMP metadata
+	// stripped and orientation applied, and SanitizedVersion records the
+	// imageenc.SanitizeVersion that did it. Backfill sanitizes older files.
+	Sanitized        bool `json:"sanitized,omitempty"`
+	SanitizedVersion int  `json:"sanitizedVersion,omitempty"`
+	// LQIP is a data: URI thumbnail and DominantColor a "#rrggbb" backdrop,
+	// shown behind the image while it loads. Empty for transparent images.
+	LQIP          string `json:"lqip,omitempty"`
+	DominantColor string `json:"dominantColor,omitempty"`
+	// NoPlaceholder records that the image can't have one (transparency or
+	// an undecodable format), so backfill doesn't retry it.
+	NoPlaceholder bool `json:"noPlaceholder,omitempty"`
+	// Published names the ImageStore the files were last uploaded to.
+	// Unset while images are served straight from ./images.
+	Published string `json:"published,omitempty"`
+}
+
+// needsSanitizing reports whether the original hasn't been sanitized by the
+// current imageenc.SanitizeVersion.
+func (m ImageMeta) needsSanitizing() bool {
+	return !m.Sanitized || m.SanitizedVersion < imageenc.SanitizeVersion
+}
+
+// markSanitized records that the original is clean by the current rules.
+func (m *ImageMeta) markSanitized() {
+	m.Sanitized, m.SanitizedVersion = true, imageenc.SanitizeVersion
+}
+
+// needsPlaceholder reports whether a placeholder should still be computed.
+func (m ImageMeta) needsPlaceholder() bool {
+	return m.LQIP == "" && !m.NoPlaceholder
+}
+
+// setPlaceholder computes the LQIP and dominant colour of origPath into
+// meta. Failures are logged; transparent images are marked so they aren't
+// retried.
+func setPlaceholder(id, origPath string, meta *ImageMeta) {
+	p, err := imageenc.MakePlaceholder(origPath)
+	if errors.Is(err, imageenc.ErrTransparent) || errors.Is(err, image.ErrFormat) {
+		// Transparent, or a format Go can't decode (WebP originals).
+		meta.NoPlaceholder = true
+		return
+	}
+	if err != nil {
+		log.Error("could not compute placeholder for %s: %v", id, err)
+		return
+	}
+	meta.LQIP, meta.DominantColor = p.LQIP, p.DominantColor
+}
+
+// PlaceholderStyle is the inline style painting the dominant colour and the
+// blurred LQIP behind an image until it loads. Both values are generated by
+// imageenc (base64 and hex digits only), so they are safe to mark as CSS.
+func (m ImageMeta) PlaceholderStyle() template.CSS {
+	if m.LQIP == "" || m.DominantColor == "" {
+		return ""
+	}
+	return template.CSS(fmt.Sprintf("background-color:%s;background-image:url('%s');background-size:cover;background-repeat:no-repeat", m.DominantColor, m.LQIP))
+}
+
+// ImageVariant is one downscaled copy of a stored image.
+type ImageVariant struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Formats lists the formats this width exists in. Empty means WebP only
+	// (sidecars written before AVIF).
+	Formats []string `json:"formats,omitempty"`
+}
+
+// HasFormat reports whether the full-size image exists in format.
+func (m ImageMeta) HasFormat(format string) bool {
+	if format == "webp" && m.HasWebP {
+		return true
+	}
+	return slices.Contains(m.Formats, format)
+}
+
+// addFormat records format as present, keeping HasWebP in sync.
+func (m *ImageMeta) addFormat(format string) {
+	if format == "webp" {
+		m.HasWebP = true
+	}
+	if !slices.Contains(m.Formats, format) {
+		m.Formats = append(m.Formats, format)
+	}
+}
+
+func (v ImageVariant) hasFormat(format string) bool {
+	if len(v.Formats) == 0 {
+		return format == "webp"
+	}
+	return slices.Contains(v.Formats, format)
+}
+
+// VariantWidths are the srcset widths generated for every stored image. 960
+// covers the article column at 1x/retina phones; 1600 covers it at 2x.
+var VariantWidths = []int{480, 960, 1600}
+
+// imageSizes is the sizes attribute for post images: full viewport width on
+// small screens, capped at the max-w-3xl (48rem) article column.
+const imageSizes = "(max-width: 48rem) 100vw, 48rem"
+
+// variantName is the file name of the variant of id at width in format.
+func variantName(id string, width int, format string) string {
+	return fmt.Sprintf("%s-%d.%s", id, width, format)
+}
+
+// variantWidths returns the VariantWidths narrower than the original.
+func (m ImageMeta) variantWidths() []int {
+	var widths []int
+	if m.Width == 0 || m.Height == 0 {
+		return widths
+	}
+	for _, w := range VariantWidths {
+		if w < m.Width {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
+
+// encodeFormats runs every available encoder whose output meta doesn't
+// already record: a full-size copy at <id>.<format> plus one per variant
+// width. Results are recorded on meta. Returns how many formats were newly
+// encoded and how many failed at full size. An original already in an
+// encoder's format counts as that format without re-encoding.
+func encodeFormats(dir, id, origPath string, meta *ImageMeta) (encoded, failed int) {
+	for _, enc := range imageenc.Encoders() {
+		format := enc.Format()
+		if meta.FallbackExt == format {
+			meta.addFormat(format)
+		}
+		if meta.HasFormat(format) && !missingVariants(*meta, format) {
+			continue
+		}
+		if !enc.Available() {
+			continue
+		}
+		if !meta.HasFormat(format) {
+			dst := filepath.Join(dir, id+"."+format)
+			if err := enc.Encode(origPath, dst, 0); err != nil {
+				log.Error("%s encode failed for %s: %v", format, id, err)
+				failed++
+				continue
+			}
+			meta.addFormat(format)
+			encoded++
+		}
+		writeVariants(dir, id, origPath, meta, enc)
+	}
+	if enc, ok := fallbackEncoder(*meta); ok {
+		writeVariants(dir, id, origPath, meta, enc)
+	}
+	return encoded, failed
+}
+
+// fallbackEncoder returns the pure-Go encoder for the original's format when
+// there is no WebP copy to serve, so readers without cwebp on the server
+// still get downscaled JPEG/PNG variants.
+func fallbackEncoder(meta ImageMeta) (imageenc.Encoder, bool) {
+	if meta.HasFormat("webp") {
+		return nil, false
+	}
+	return imageenc.Native(meta.FallbackExt)
+}
+
+// writeVariants encodes a copy of origPath at each variant width missing in
+// enc's format and records the ones that succeed on meta. Needs the
+// original's dimensions; without them nothing is generated.
+func writeVariants(dir, id, origPath string, meta *ImageMeta, enc imageenc.Encoder) {
+	format := enc.Format()
+	for _, width := range meta.variantWidths() {
+		i := slices.IndexFunc(meta.Variants, func(v ImageVariant) bool { return v.Width == width })
+		if i >= 0 && meta.Variants[i].hasFormat(format) {
+			continue
+		}
+		dst := filepath.Join(dir, variantName(id, width, format))
+		if err := enc.Encode(origPath, dst, width); err != nil {
+			log.Error("%s variant %d encode failed for %s: %v", format, width, id, err)
+			continue
+		}
+		if i < 0 {
+			meta.Variants = append(meta.Variants, ImageVariant{
+				Width:   width,
+				Height:  (meta.Height*width + meta.Width/2) / meta.Width,
+				Formats: []string{format},
+			})
+			continue
+		}
+		v := &meta.Variants[i]
+		if len(v.Formats) == 0 {
+			// Pre-AVIF variant: make its implicit WebP explicit first.
+			v.Formats = []string{"webp"}
+		}
+		v.Formats = append(v.Formats, format)
+	}
+	slices.SortFunc(meta.Variants, func(a, b ImageVariant) int { return a.Width - b.Width })
+}
+
+// missingVariants reports whether meta lacks a variant width in format.
+func missingVariants(meta ImageMeta, format string) bool {
+	for _, width := range meta.variantWidths() {
+		i := slices.IndexFunc(meta.Variants, func(v ImageVariant) bool { return v.Width == width })
+		if i < 0 || !meta.Variants[i].hasFormat(format) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsEncoding reports whether any available encoder has work left for meta.
+func needsEncoding(meta ImageMeta) bool {
+	for _, enc := range imageenc.Encoders() {
+		format := enc.Format()
+		if meta.FallbackExt == format && !missingVariants(meta, format) {
+			continue
+		}
+		if enc.Available() && (!meta.HasFormat(format) || missingVariants(meta, format)) {
+			return true
+		}
+	}
+	if enc, ok := fallbackEncoder(meta); ok && missingVariants(meta, enc.Format()) {
+		return true
+	}
+	return false
+}
+
+// sanitizeFile strips metadata from the original of id at path. An original
+// that was already clean is only marked on meta. Otherwise the clean bytes
+// are stored as a new content-addressed image (see storeImageBytes), id's
+// ref is pointed at it and id's own files are deleted, locally and from the
+// store. When id is itself a digest, sanitized by an older SanitizeVersion,
+// every ref that uses it is pointed at the new digest instead. They were served as immutable, so rewriting them in place would
+// leave cached copies with the old metadata and could hand a reader a
+// half-written file. moved reports that id's files are gone.
+func sanitizeFile(dir, id, path string, meta *ImageMeta) (moved bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	clean, _, err := imageenc.Sanitize(data)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(clean, data) {
+		meta.markSanitized()
+		return false, nil
+	}
+	if isDigest(id) {
+		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+		digest, _, err := storeSanitized(dir, ext, clean)
+		if err != nil {
+			return false, err
+		}
+		if err := repointImageRefs(id, digest); err != nil {
+			// Refs still naming id would lose their image.
+			return false, err
+		}
+	} else {
+		ref, _ := readImageRef(id)
+		if _, _, err := storeImageBytes(id, ref.Source, data); err != nil {
+			return false, err
+		}
+	}
+	removeImage(dir, id, *meta)
+	return true, nil
+}
+
+// removeImage deletes the files and sidecar of the image stored under key,
+// unpublishing them from the store they were uploaded to. Failures are
+// logged: the image is already replaced and the audit catches leftovers.
+func removeImage(dir, key string, meta ImageMeta) {
+	published := meta.Published != "" && meta.Published == currentImageStore().String()
+	for _, name := range imageFiles(key, meta) {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			log.Error("error removing %s: %v", name, err)
+		}
+		if published {
+			if err := unpublishImageFile(name); err != nil {
+				log.Error("error unpublishing %s: %v", name, err)
+			}
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, key+".meta.json")); err != nil && !os.IsNotExist(err) {
+		log.Error("error removing sidecar for %s: %v", key, err)
+	}
+}
+
+// SrcSet returns a srcset value listing the variants of id in format plus
+// the full-size copy, or "" when there is nothing to choose between. format
+// may be the original's own extension for pure-Go fallback variants.
+func (m ImageMeta) SrcSet(id, format string) string {
+	if !m.HasFormat(format) && format != m.FallbackExt {
+		return ""
+	}
+	var candidates []string
+	for _, v := range m.Variants {
+		if v.hasFormat(format) {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", imageURL(variantName(id, v.Width, format)), v.Width))
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	if m.Width > 0 {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", imageURLFor(id, format), m.Width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// pictureSource is one <source> of a <picture>.
+type pictureSource struct {
+	Type   string
+	SrcSet string
+	// Sizes is set only when SrcSet has width descriptors.
+	Sizes string
+}
+
+// PictureSources returns the <source> elements for id in encoder preference
+// order (AVIF before WebP), skipping formats that weren't produced.
+func (m ImageMeta) PictureSources(id string) []pictureSource {
+	var sources []pictureSource
+	for _, enc := range imageenc.Encoders() {
+		format := enc.Format()
+		if !m.HasFormat(format) {
+			continue
+		}
+		src := pictureSource{Type: enc.MIMEType(), SrcSet: m.SrcSet(id, format)}
+		if src.SrcSet != "" {
+			src.Sizes = imageSizes
+		} else {
+			src.SrcSet = imageURLFor(id, format)
+		}
+		sources = append(sources, src)
+	}
+	return sources
 }
 
 // imagesDir returns an absolute path to ./images, creating it if needed.
@@ -49,13 +398,17 @@ func extFromContentType(ct string) string {
 	return ""
 }
 
-// storeImageBytes writes the original bytes under <id>.<ext>, encodes a WebP
-// sibling at <id>.webp when possible, and writes an <id>.meta.json sidecar.
+// storeImageBytes sanitizes body and stores it content-addressed: the
+// original under <digest>.<ext>, AVIF and WebP siblings at <digest>.<format>
+// plus narrower <digest>-<width>.<format> variants when possible, and a
+// <digest>.meta.json sidecar. Bytes already stored are not written or
+// encoded again. A ref maps id to the digest, recording source (the download
+// URL) so unchanged images can skip the download next time.
 // Returns the URL that templates should link to (WebP when available, else
-// the original) and the written meta for callers that need dimensions.
+// the original) and the meta for callers that need dimensions.
 //
 // Encode failures are logged and absorbed; the fallback path is always viable.
-func storeImageBytes(id string, body []byte) (string, ImageMeta, error) {
+func storeImageBytes(id, source string, body []byte) (string, ImageMeta, error) {
 	dir, err := imagesDir()
 	if err != nil {
 		return "", ImageMeta{}, err
@@ -68,60 +421,107 @@ func storeImageBytes(id string, body []byte) (string, ImageMeta, error) {
 	ct := http.DetectContentType(sniff)
 	ext := extFromContentType(ct)
 	if ext == "" {
-		// Unrecognized image — keep the old .png convention so nothing breaks,
-		// but flag it loudly. Rendering path will still show the plain img.
-		log.Error("unrecognized image content-type %q for id %s; storing as .png", ct, id)
-		ext = "png"
+		// Unrecognized image (HEIC, TIFF, ...): we can't strip its metadata,
+		// so it isn't stored and the block keeps its Notion URL.
+		return "", ImageMeta{}, fmt.Errorf("unrecognized image content-type %q for id %s", ct, id)
+	}
+
+	// Strip EXIF (GPS included) before anything touches disk; the original is
+	// served as the <img> fallback. Images we can't sanitize aren't stored.
+	body, _, err = imageenc.Sanitize(body)
+	if err != nil {
+		return "", ImageMeta{}, fmt.Errorf("error stripping image metadata for %s: %v", id, err)
+	}
+
+	digest, meta, err := storeSanitized(dir, ext, body)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+
+	ref := ImageRef{Digest: digest}
+	if source != "" {
+		ref.Source = sourceKey(source)
+	}
+	if err := writeImageRef(id, ref); err != nil {
+		log.Error("error writing image ref for %s: %v", id, err)
+	}
+	return imageURLFor(digest, meta.primaryExt()), meta, nil
+}
+
+// storeSanitized stores already sanitized bytes under their digest, writing,
+// encoding and publishing them unless they are stored already, and returns
+// the digest and its sidecar.
+func storeSanitized(dir, ext string, body []byte) (string, ImageMeta, error) {
+	digest := imageDigest(body)
+	unlock := lockDigest(digest)
+	defer unlock()
+
+	origPath := filepath.Join(dir, digest+"."+ext)
+	meta, merr := readImageMeta(digest)
+	if merr != nil || meta.FallbackExt != ext || !fileExists(origPath) {
+		var err error
+		if meta, err = writeImage(dir, digest, ext, body); err != nil {
+			return "", ImageMeta{}, err
+		}
 	}
+	// The file holds exactly these bytes, clean by the current rules.
+	meta.markSanitized()
+	if err := publishImage(dir, digest, &meta); err != nil {
+		// Linking to files the store doesn't have would break the page;
+		// the block keeps its Notion URL until a later refresh or backfill.
+		return "", ImageMeta{}, err
+	}
+	if err := writeImageMeta(dir, digest, meta); err != nil {
+		log.Error("error writing image meta sidecar for %s: %v", digest, err)
+	}
+	return digest, meta, nil
+}
 
-	origPath := filepath.Join(dir, id+"."+ext)
+// writeImage writes a new original under key and derives everything else
+// from it: dimensions, placeholder and encoded copies. The caller writes the
+// sidecar once the files are published.
+func writeImage(dir, key, ext string, body []byte) (ImageMeta, error) {
+	origPath := filepath.Join(dir, key+"."+ext)
 	if err := os.WriteFile(origPath, body, 0o755); err != nil {
-		return "", ImageMeta{}, fmt.Errorf("error writing image to file: %v", err)
+		return ImageMeta{}, fmt.Errorf("error writing image to file: %v", err)
 	}
 
 	meta := ImageMeta{FallbackExt: ext}
+	meta.markSanitized()
 
 	// Dimensions: read from whatever we just wrote. Best-effort.
 	if w, h, derr := imageenc.ReadDimensions(origPath); derr == nil {
 		meta.Width, meta.Height = w, h
 	} else {
-		log.Error("could not read image dimensions for %s: %v", id, derr)
+		log.Error("could not read image dimensions for %s: %v", key, derr)
 	}
 
-	// WebP encode: best-effort. If already webp, skip (original IS the webp).
-	if ext == "webp" {
-		meta.HasWebP = true
-	} else {
-		webpPath := filepath.Join(dir, id+".webp")
-		if err := imageenc.EncodeWebP(origPath, webpPath); err != nil {
-			log.Error("webp encode failed for %s: %v", id, err)
-		} else {
-			meta.HasWebP = true
-		}
-	}
+	setPlaceholder(key, origPath, &meta)
 
-	// Write sidecar.
-	metaPath := filepath.Join(dir, id+".meta.json")
-	if metaBytes, merr := json.Marshal(meta); merr == nil {
-		if werr := os.WriteFile(metaPath, metaBytes, 0o644); werr != nil {
-			log.Error("error writing image meta sidecar for %s: %v", id, werr)
-		}
-	}
+	// Derived formats (AVIF, WebP) and their width variants: best-effort,
+	// each encoder runs only if its binary is installed. Without WebP, the
+	// variants are pure-Go re-encodes of the original format.
+	encodeFormats(dir, key, origPath, &meta)
+	return meta, nil
+}
 
-	// Decide the primary URL: webp if we have one, else the fallback.
-	primaryExt := ext
-	if meta.HasWebP && ext != "webp" {
-		primaryExt = "webp"
+// primaryExt is the format block URLs point at: WebP if we have one, else
+// the original.
+func (m ImageMeta) primaryExt() string {
+	if m.HasWebP {
+		return "webp"
 	}
-	return imageURLFor(id, primaryExt), meta, nil
+	return m.FallbackExt
 }
 
-// imageURLFor returns the absolute URL in prod, root-relative in dev.
+// imageURLFor returns the public URL of <id>.<ext> in the current ImageStore.
 func imageURLFor(id, ext string) string {
-	if os.Getenv("DEV") == "true" {
-		return "/images/" + id + "." + ext
-	}
-	return "https://cloud.shaikzhafir.com/images/" + id + "." + ext
+	return imageURL(id + "." + ext)
+}
+
+// imageURL is imageURLFor for a file name under ./images.
+func imageURL(name string) string {
+	return currentImageStore().URL(name)
 }
 
 // readImageMeta loads the sidecar for id. Returns a zero ImageMeta and nil
@@ -10,6 +10,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -34,6 +36,16 @@ func tinyImageBytes(t *testing.T, enc func(w *bytes.Buffer, img image.Image) err
 	return buf.Bytes()
 }
 
+// storedKey returns the digest the ref for id points at.
+func storedKey(t *testing.T, id string) string {
+	t.Helper()
+	ref, ok := readImageRef(id)
+	if !ok {
+		t.Fatalf("no image ref for %s", id)
+	}
+	return ref.Digest
+}
+
 // chdirTo relocates the process cwd for the test since storeImageBytes
 // writes under "./images". Restores cwd on cleanup.
 func chdirTo(t *testing.T, dir string) {
@@ -57,12 +69,13 @@ func TestStoreImageBytes_PNG(t *testing.T) {
 	t.Setenv("DEV", "true")
 
 	pngBytes := tinyImageBytes(t, func(w *bytes.Buffer, img image.Image) error { return png.Encode(w, img) })
-	url, meta, err := storeImageBytes("test-png-id", pngBytes)
+	url, meta, err := storeImageBytes("test-png-id", "", pngBytes)
 	if err != nil {
 		t.Fatalf("storeImageBytes: %v", err)
 	}
-	if url != "/images/test-png-id.webp" {
-		t.Fatalf("url: want /images/test-png-id.webp, got %s", url)
+	key := storedKey(t, "test-png-id")
+	if url != "/images/"+key+".webp" {
+		t.Fatalf("url: want /images/<digest>.webp, got %s", url)
 	}
 	if meta.FallbackExt != "png" {
 		t.Fatalf("fallbackExt: want png, got %s", meta.FallbackExt)
@@ -75,14 +88,14 @@ func TestStoreImageBytes_PNG(t *testing.T) {
 	}
 
 	// Both files should exist on disk.
-	for _, p := range []string{"images/test-png-id.png", "images/test-png-id.webp", "images/test-png-id.meta.json"} {
+	for _, p := range []string{"images/" + key + ".png", "images/" + key + ".webp", "images/" + key + ".meta.json"} {
 		if _, err := os.Stat(p); err != nil {
 			t.Fatalf("expected %s: %v", p, err)
 		}
 	}
 
 	// Sidecar should round-trip.
-	data, err := os.ReadFile("images/test-png-id.meta.json")
+	data, err := os.ReadFile("images/" + key + ".meta.json")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,7 +103,7 @@ func TestStoreImageBytes_PNG(t *testing.T) {
 	if err := json.Unmarshal(data, &got); err != nil {
 		t.Fatal(err)
 	}
-	if got != meta {
+	if !reflect.DeepEqual(got, meta) {
 		t.Fatalf("sidecar mismatch: %+v vs %+v", got, meta)
 	}
 }
@@ -105,17 +118,18 @@ func TestStoreImageBytes_JPEG(t *testing.T) {
 	jpegBytes := tinyImageBytes(t, func(w *bytes.Buffer, img image.Image) error {
 		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
 	})
-	url, meta, err := storeImageBytes("test-jpg-id", jpegBytes)
+	url, meta, err := storeImageBytes("test-jpg-id", "", jpegBytes)
 	if err != nil {
 		t.Fatalf("storeImageBytes: %v", err)
 	}
-	if url != "/images/test-jpg-id.webp" {
+	key := storedKey(t, "test-jpg-id")
+	if url != "/images/"+key+".webp" {
 		t.Fatalf("url: got %s", url)
 	}
 	if meta.FallbackExt != "jpg" {
 		t.Fatalf("fallbackExt: want jpg, got %s", meta.FallbackExt)
 	}
-	for _, p := range []string{"images/test-jpg-id.jpg", "images/test-jpg-id.webp"} {
+	for _, p := range []string{"images/" + key + ".jpg", "images/" + key + ".webp"} {
 		if _, err := os.Stat(p); err != nil {
 			t.Fatalf("expected %s: %v", p, err)
 		}
@@ -130,12 +144,13 @@ func TestStoreImageBytes_EncodeFailureFallsBack(t *testing.T) {
 	t.Setenv("DEV", "true")
 
 	pngBytes := tinyImageBytes(t, func(w *bytes.Buffer, img image.Image) error { return png.Encode(w, img) })
-	url, meta, err := storeImageBytes("fallback-id", pngBytes)
+	url, meta, err := storeImageBytes("fallback-id", "", pngBytes)
 	if err != nil {
 		t.Fatalf("storeImageBytes: %v", err)
 	}
-	if url != "/images/fallback-id.png" {
-		t.Fatalf("url: want /images/fallback-id.png, got %s", url)
+	key := storedKey(t, "fallback-id")
+	if url != "/images/"+key+".png" {
+		t.Fatalf("url: want /images/<digest>.png, got %s", url)
 	}
 	if meta.HasWebP {
 		t.Fatal("expected HasWebP=false when cwebp missing")
@@ -148,7 +163,7 @@ func TestStoreImageBytes_EncodeFailureFallsBack(t *testing.T) {
 		t.Fatalf("dims: got %dx%d", meta.Width, meta.Height)
 	}
 	// No .webp file expected.
-	if _, err := os.Stat("images/fallback-id.webp"); !os.IsNotExist(err) {
+	if _, err := os.Stat("images/" + key + ".webp"); !os.IsNotExist(err) {
 		t.Fatalf("unexpected .webp: err=%v", err)
 	}
 }
@@ -159,7 +174,7 @@ func TestReadImageMeta_MissingReturnsZero(t *testing.T) {
 	if err != nil {
 		t.Fatalf("readImageMeta: %v", err)
 	}
-	if meta != (ImageMeta{}) {
+	if !reflect.DeepEqual(meta, ImageMeta{}) {
 		t.Fatalf("expected zero meta, got %+v", meta)
 	}
 }
@@ -177,3 +192,262 @@ func TestFixture_SizeSanity(t *testing.T) {
 	// Avoid unused-filepath-import lint.
 	_ = filepath.Join("", "")
 }
+
+func TestImageMeta_SrcSet(t *testing.T) {
+	t.Setenv("DEV", "true")
+	// Variants without Formats are pre-AVIF sidecars: WebP only.
+	meta := ImageMeta{
+		Width: 2000, Height: 1000, FallbackExt: "jpg", HasWebP: true,
+		Variants: []ImageVariant{{Width: 480, Height: 240}, {Width: 960, Height: 480}},
+	}
+	want := "/images/x-480.webp 480w, /images/x-960.webp 960w, /images/x.webp 2000w"
+	if got := meta.SrcSet("x", "webp"); got != want {
+		t.Fatalf("srcset:\n got %s\nwant %s", got, want)
+	}
+	if got := meta.SrcSet("x", "avif"); got != "" {
+		t.Fatalf("expected no avif srcset, got %q", got)
+	}
+
+	// No variants (small image or pre-variant sidecar): plain <source>.
+	if got := (ImageMeta{Width: 300, HasWebP: true}).SrcSet("x", "webp"); got != "" {
+		t.Fatalf("expected empty srcset, got %q", got)
+	}
+}
+
+func TestImageMeta_PictureSourcesOrder(t *testing.T) {
+	t.Setenv("DEV", "true")
+	meta := ImageMeta{
+		Width: 1000, Height: 500, FallbackExt: "png", HasWebP: true,
+		Formats: []string{"webp", "avif"},
+		Variants: []ImageVariant{
+			{Width: 480, Height: 240, Formats: []string{"webp", "avif"}},
+			{Width: 960, Height: 480, Formats: []string{"webp"}},
+		},
+	}
+	got := meta.PictureSources("x")
+	want := []pictureSource{
+		{Type: "image/avif", SrcSet: "/images/x-480.avif 480w, /images/x.avif 1000w", Sizes: imageSizes},
+		{Type: "image/webp", SrcSet: "/images/x-480.webp 480w, /images/x-960.webp 960w, /images/x.webp 1000w", Sizes: imageSizes},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("sources:\n got %+v\nwant %+v", got, want)
+	}
+
+	// Full-size only: plain URL, no sizes.
+	small := ImageMeta{Width: 300, Height: 200, FallbackExt: "png", Formats: []string{"avif"}}
+	want = []pictureSource{{Type: "image/avif", SrcSet: "/images/y.avif"}}
+	if got := small.PictureSources("y"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sources: got %+v, want %+v", got, want)
+	}
+}
+
+func TestImageMeta_HasFormatLegacySidecar(t *testing.T) {
+	var meta ImageMeta
+	if err := json.Unmarshal([]byte(`{"width":10,"height":10,"fallbackExt":"png","hasWebP":true}`), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.HasFormat("webp") || meta.HasFormat("avif") {
+		t.Fatalf("legacy sidecar formats: %+v", meta)
+	}
+	meta.addFormat("avif")
+	if !meta.HasFormat("avif") || !meta.HasWebP {
+		t.Fatalf("after addFormat: %+v", meta)
+	}
+}
+
+func TestStoreImageBytes_Variants(t *testing.T) {
+	if _, err := exec.LookPath("cwebp"); err != nil {
+		t.Skip("cwebp not on PATH; skipping")
+	}
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+
+	// 1000px wide: 480 and 960 variants, no 1600 (never upscale).
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	_, meta, err := storeImageBytes("wide-id", "", buf.Bytes())
+	if err != nil {
+		t.Fatalf("storeImageBytes: %v", err)
+	}
+	key := storedKey(t, "wide-id")
+	if len(meta.Variants) != 2 {
+		t.Fatalf("variants: want 480 and 960, got %+v", meta.Variants)
+	}
+	for i, want := range []ImageVariant{{Width: 480, Height: 240}, {Width: 960, Height: 480}} {
+		got := meta.Variants[i]
+		if got.Width != want.Width || got.Height != want.Height || !got.hasFormat("webp") {
+			t.Fatalf("variant %d: got %+v, want %dx%d webp", i, got, want.Width, want.Height)
+		}
+	}
+	for _, p := range []string{"images/" + key + "-480.webp", "images/" + key + "-960.webp"} {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("expected %s: %v", p, err)
+		}
+	}
+	if _, err := os.Stat("images/" + key + "-1600.webp"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected 1600 variant: err=%v", err)
+	}
+}
+
+func TestStoreImageBytes_PureGoFallbackStripsEXIFAndResizes(t *testing.T) {
+	// No cwebp/avifenc: originals are still sanitized and get JPEG variants.
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1000, 600)), nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00II\x2a\x00\x08\x00\x00\x00\x00\x00GPSMARKER")
+	seg := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	withEXIF := append(append([]byte{0xFF, 0xD8}, seg...), buf.Bytes()[2:]...)
+
+	url, meta, err := storeImageBytes("travel-id", "", withEXIF)
+	if err != nil {
+		t.Fatalf("storeImageBytes: %v", err)
+	}
+	key := storedKey(t, "travel-id")
+	if url != "/images/"+key+".jpg" {
+		t.Fatalf("url: got %s", url)
+	}
+	if !meta.Sanitized || meta.HasWebP {
+		t.Fatalf("meta: %+v", meta)
+	}
+	// All-black image: black backdrop plus a blur-up thumbnail.
+	if meta.DominantColor != "#000000" || !strings.HasPrefix(meta.LQIP, "data:image/png;base64,") {
+		t.Fatalf("placeholder: %q %.30q", meta.DominantColor, meta.LQIP)
+	}
+	if style := string(meta.PlaceholderStyle()); !strings.Contains(style, "background-color:#000000") {
+		t.Fatalf("style: %s", style)
+	}
+	stored, err := os.ReadFile("images/" + key + ".jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("GPSMARKER")) {
+		t.Fatal("EXIF written to disk")
+	}
+	want := "/images/" + key + "-480.jpg 480w, /images/" + key + "-960.jpg 960w, /images/" + key + ".jpg 1000w"
+	if got := meta.SrcSet(key, "jpg"); got != want {
+		t.Fatalf("srcset:\n got %s\nwant %s", got, want)
+	}
+	if _, err := os.Stat("images/" + key + "-480.jpg"); err != nil {
+		t.Fatalf("expected 480 variant: %v", err)
+	}
+
+	// Backfill must not mistake the JPEG variants for originals.
+	report, err := BackfillImages()
+	if err != nil {
+		t.Fatalf("BackfillImages: %v", err)
+	}
+	if report.Scanned != 1 || report.Skipped != 1 {
+		t.Fatalf("backfill report: %+v", report)
+	}
+}
+
+func TestBackfill_MovesUnsanitizedLegacyImageToNewDigest(t *testing.T) {
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+	if err := os.MkdirAll("images", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 300)), nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00II\x2a\x00\x08\x00\x00\x00\x00\x00GPSMARKER")
+	seg := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	withEXIF := append(append([]byte{0xFF, 0xD8}, seg...), buf.Bytes()[2:]...)
+	// Stored by block ID before content addressing and sanitizing.
+	if err := os.WriteFile("images/legacy-block.jpg", withEXIF, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("images/legacy-block.meta.json", []byte(`{"width":400,"height":300,"fallbackExt":"jpg"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BackfillImages(); err != nil {
+		t.Fatalf("BackfillImages: %v", err)
+	}
+	key := storedKey(t, "legacy-block")
+	if key == "legacy-block" {
+		t.Fatal("ref not pointed at a digest")
+	}
+	stored, err := os.ReadFile("images/" + key + ".jpg")
+	if err != nil || bytes.Contains(stored, []byte("GPSMARKER")) {
+		t.Fatalf("clean copy under digest: %v, has EXIF %v", err, bytes.Contains(stored, []byte("GPSMARKER")))
+	}
+	if meta, err := readImageMeta(key); err != nil || !meta.Sanitized || meta.Width != 400 {
+		t.Fatalf("digest meta: %+v, %v", meta, err)
+	}
+	for _, name := range []string{"legacy-block.jpg", "legacy-block.meta.json"} {
+		if _, err := os.Stat("images/" + name); !os.IsNotExist(err) {
+			t.Fatalf("%s still served: %v", name, err)
+		}
+	}
+}
+
+func TestBackfill_ResanitizesOriginalsFromOlderVersions(t *testing.T) {
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+	if err := os.MkdirAll("images", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 300)), nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00II\x2a\x00\x08\x00\x00\x00\x00\x00GPSMARKER")
+	seg := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	appended := append(append([]byte{0xFF, 0xD8}, seg...), buf.Bytes()[2:]...)
+	// Sanitized before pictures appended after EOI were dropped.
+	old := append(append([]byte{}, buf.Bytes()...), appended...)
+	oldKey := imageDigest(old)
+	if err := os.WriteFile("images/"+oldKey+".jpg", old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("images/"+oldKey+".meta.json", []byte(`{"width":400,"height":300,"fallbackExt":"jpg","sanitized":true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"block-a", "block-b"} {
+		if err := writeImageRef(id, ImageRef{Digest: oldKey}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := BackfillImages(); err != nil {
+		t.Fatalf("BackfillImages: %v", err)
+	}
+	key := storedKey(t, "block-a")
+	if key == oldKey || storedKey(t, "block-b") != key {
+		t.Fatalf("refs not moved together: %s, %s", key, storedKey(t, "block-b"))
+	}
+	stored, err := os.ReadFile("images/" + key + ".jpg")
+	if err != nil || !bytes.Equal(stored, buf.Bytes()) {
+		t.Fatalf("want only the primary picture under the new digest: %v", err)
+	}
+	if meta, err := readImageMeta(key); err != nil || meta.needsSanitizing() {
+		t.Fatalf("new meta: %+v, %v", meta, err)
+	}
+	if _, err := os.Stat("images/" + oldKey + ".jpg"); !os.IsNotExist(err) {
+		t.Fatalf("old digest still served: %v", err)
+	}
+}
+
+func TestStoreImageBytes_RejectsUnknownFormat(t *testing.T) {
+	chdirTo(t, t.TempDir())
+	if _, _, err := storeImageBytes("heic-id", "", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")); err == nil {
+		t.Fatal("expected an error for an image we can't sanitize")
+	}
+	if _, err := os.Stat("images/heic-id.png"); !os.IsNotExist(err) {
+		t.Fatalf("unsanitized image written: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package notion
+
+import (
+	"fmt"
+	"htmx-blog/services/notion/imageenc"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ImageStore is where stored images are published and served from.
+//
+// ./images stays the working copy either way: encoders, sidecars, refs and
+// the audit all work on local files. Once an image's files are written they
+// are Put to the store, and every URL handed to templates comes from
+// ImageStore.URL, so images can be served by a CDN or object store instead
+// of the web host.
+type ImageStore interface {
+	// Put publishes the local file at path under name, replacing any
+	// existing object.
+	Put(name, path string) error
+	// Delete removes name. Deleting a missing object is not an error.
+	Delete(name string) error
+	// URL is the public URL for name.
+	URL(name string) string
+	// String identifies the target; it is recorded on sidecars once an
+	// image has been published there.
+	String() string
+}
+
+// LocalStore serves images from a directory on this host. With Dir set to
+// ./images (the default) Put and Delete have nothing to do.
+type LocalStore struct {
+	Dir string
+	// BaseURL is the public prefix the directory is served under, e.g.
+	// "/images" or "https://cdn.example.com/images".
+	BaseURL string
+}
+
+func (s LocalStore) Put(name, path string) error {
+	dst := filepath.Join(s.Dir, name)
+	if samePath(dst, path) {
+		return nil
+	}
+	if err := os.MkdirAll(s.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(s.Dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s LocalStore) Delete(name string) error {
+	path := filepath.Join(s.Dir, name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s LocalStore) URL(name string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + name
+}
+
+func (s LocalStore) String() string {
+	return "file://" + s.Dir
+}
+
+// inPlace reports whether s is the working copy itself.
+func (s LocalStore) inPlace() bool {
+	dir, err := imagesDir()
+	return err == nil && samePath(s.Dir, dir)
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+var (
+	imageStoreMu sync.RWMutex
+	imageStore   ImageStore
+)
+
+// SetImageStore sets where images are published. Without it images are
+// served from ./images under defaultImageBaseURL.
+func SetImageStore(s ImageStore) {
+	imageStoreMu.Lock()
+	defer imageStoreMu.Unlock()
+	imageStore = s
+}
+
+// currentImageStore returns the configured store, or the local default.
+func currentImageStore() ImageStore {
+	imageStoreMu.RLock()
+	defer imageStoreMu.RUnlock()
+	if imageStore != nil {
+		return imageStore
+	}
+	return LocalStore{Dir: "./images", BaseURL: defaultImageBaseURL()}
+}
+
+// defaultImageBaseURL is IMAGE_BASE_URL if set, else the dev server's own
+// /images/ route, else the production file host.
+func defaultImageBaseURL() string {
+	if base := os.Getenv("IMAGE_BASE_URL"); base != "" {
+		return base
+	}
+	if os.Getenv("DEV") == "true" {
+		return "/images"
+	}
+	return "https://cloud.shaikzhafir.com/images"
+}
+
+// ImageStoreFromEnv builds the store selected by IMAGE_STORE:
+//
+//	local (default)  ./images, or IMAGE_DIR, served under IMAGE_BASE_URL
+//	s3               an S3-compatible bucket: S3_ENDPOINT, S3_BUCKET,
+//	                 S3_REGION, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY,
+//	                 optional S3_PREFIX; served under IMAGE_BASE_URL if set
+//	                 (a CDN in front of the bucket), else the bucket URL
+func ImageStoreFromEnv() (ImageStore, error) {
+	switch kind := os.Getenv("IMAGE_STORE"); kind {
+	case "", "local":
+		dir := os.Getenv("IMAGE_DIR")
+		if dir == "" {
+			dir = "./images"
+		}
+		return LocalStore{Dir: dir, BaseURL: defaultImageBaseURL()}, nil
+	case "s3":
+		s := &S3Store{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          os.Getenv("S3_REGION"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:          os.Getenv("S3_PREFIX"),
+			BaseURL:         os.Getenv("IMAGE_BASE_URL"),
+		}
+		if s.Endpoint == "" || s.Bucket == "" || s.AccessKeyID == "" || s.SecretAccessKey == "" {
+			return nil, fmt.Errorf("IMAGE_STORE=s3 needs S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_STORE %q (want local or s3)", kind)
+	}
+}
+
+// imageFiles lists the files of the image stored under key that visitors
+// can request: the original, full-size encoded copies and width variants.
+func imageFiles(key string, meta ImageMeta) []string {
+	files := []string{key + "." + meta.FallbackExt}
+	for _, enc := range imageenc.Encoders() {
+		if format := enc.Format(); meta.HasFormat(format) && format != meta.FallbackExt {
+			files = append(files, key+"."+format)
+		}
+	}
+	for _, v := range meta.Variants {
+		formats := v.Formats
+		if len(formats) == 0 {
+			formats = []string{"webp"}
+		}
+		for _, format := range formats {
+			files = append(files, variantName(key, v.Width, format))
+		}
+	}
+	return files
+}
+
+// servedInPlace reports whether store is ./images itself, so there is
+// nothing to upload or delete.
+func servedInPlace(store ImageStore) bool {
+	local, ok := store.(LocalStore)
+	return ok && local.inPlace()
+}
+
+// needsPublishing reports whether meta's files haven't been uploaded to the
+// current ImageStore yet.
+func needsPublishing(meta ImageMeta) bool {
+	store := currentImageStore()
+	return meta.FallbackExt != "" && !servedInPlace(store) && meta.Published != store.String()
+}
+
+// publishImage uploads the files of the image under key to the current
+// store and records it on meta. Nothing happens when the store is ./images
+// itself or meta already records this store.
+func publishImage(dir, key string, meta *ImageMeta) error {
+	if !needsPublishing(*meta) {
+		return nil
+	}
+	store := currentImageStore()
+	for _, name := range imageFiles(key, *meta) {
+		if err := store.Put(name, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("publishing %s to %s: %w", name, store, err)
+		}
+	}
+	meta.Published = store.String()
+	return nil
+}
+
+// unpublishImageFile removes name from the current store when it isn't
+// ./images itself.
+func unpublishImageFile(name string) error {
+	store := currentImageStore()
+	if servedInPlace(store) {
+		return nil
+	}
+	return store.Delete(name)
+}
@@ -0,0 +1,233 @@
+package notion
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Store publishes images to an S3-compatible bucket (AWS S3, MinIO,
+// Cloudflare R2, ...) using path-style requests signed with AWS Signature
+// Version 4.
+type S3Store struct {
+	// Endpoint is the scheme and host, e.g. "https://s3.eu-west-1.amazonaws.com"
+	// or "http://localhost:9000".
+	Endpoint        string
+	Bucket          string
+	Region          string // defaults to us-east-1, which MinIO accepts
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every object key, e.g. "images/".
+	Prefix string
+	// BaseURL is the public prefix objects are served under (a CDN in front
+	// of the bucket). Empty means the bucket's own URL.
+	BaseURL string
+	// Client defaults to one with a 30s timeout.
+	Client *http.Client
+
+	now func() time.Time // for tests
+}
+
+var defaultS3Client = &http.Client{Timeout: 30 * time.Second}
+
+func (s *S3Store) Put(name, path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(name), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", imageContentType(name))
+	req.Header.Set("Cache-Control", ImageCacheControl(name))
+	return s.do(req, body, http.StatusOK)
+}
+
+func (s *S3Store) Delete(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+}
+
+func (s *S3Store) URL(name string) string {
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/") + "/" + name
+	}
+	return s.objectURL(name)
+}
+
+func (s *S3Store) String() string {
+	return "s3:" + strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + s.Prefix
+}
+
+func (s *S3Store) objectURL(name string) string {
+	return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + s.Prefix + name
+}
+
+// do signs and sends req, failing unless the status is one of ok.
+func (s *S3Store) do(req *http.Request, body []byte, ok ...int) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	signV4(req, body, s.AccessKeyID, s.SecretAccessKey, region, "s3", now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = defaultS3Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+}
+
+// imageContentType is the MIME type for a stored image file name.
+func imageContentType(name string) string {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".avif":
+		return "image/avif"
+	case ".webp":
+		return "image/webp"
+	default:
+		if ct := mime.TypeByExtension(ext); ct != "" {
+			return ct
+		}
+		return "application/octet-stream"
+	}
+}
+
+const amzDateFormat = "20060102T150405Z"
+
+// signV4 adds the x-amz-date, x-amz-content-sha256 and Authorization headers
+// for AWS Signature Version 4. Every header already on req is signed, plus
+// host.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region, service string, t time.Time) {
+	amzDate := t.Format(amzDateFormat)
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalURI URI-encodes each path segment once, as S3 expects.
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if unescaped, err := url.PathUnescape(seg); err == nil {
+			seg = unescaped
+		}
+		segments[i] = awsURIEncode(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEncode(k)+"="+awsURIEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode percent-encodes everything but the RFC 3986 unreserved
+// characters.
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,224 @@
+package notion
+
+import (
+	"errors"
+	"fmt"
+	"htmx-blog/services/notion/imageenc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The /images/{id} transform endpoint only produces these, so a crawler
+// can't fill the disk cache with arbitrary sizes.
+var (
+	TransformWidths    = []int{320, 480, 640, 960, 1280, 1600, 1920}
+	TransformQualities = []int{50, 65, 75, 85}
+	// TransformFormats are the formats that can be asked for by name.
+	TransformFormats = []string{"avif", "webp", "jpg", "png"}
+)
+
+var (
+	// ErrImageNotFound is returned by TransformImage for unknown IDs.
+	ErrImageNotFound = errors.New("image not found")
+	// ErrFormatUnavailable means the requested format's encoder isn't
+	// installed on this host.
+	ErrFormatUnavailable = errors.New("image format unavailable")
+)
+
+// TransformOptions is a parsed, allow-listed transform request.
+type TransformOptions struct {
+	// Width is one of TransformWidths, or 0 for the original width.
+	Width int
+	// Format is one of TransformFormats, or "" to pick from Accept.
+	Format string
+	// Quality is one of TransformQualities, or 0 for the encoder default.
+	Quality int
+}
+
+// ParseTransformOptions reads w, format and q from query, rejecting
+// anything outside the allow-lists.
+func ParseTransformOptions(query url.Values) (TransformOptions, error) {
+	var opts TransformOptions
+	if v := query.Get("w"); v != "" {
+		w, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(TransformWidths, w) {
+			return opts, fmt.Errorf("w must be one of %v", TransformWidths)
+		}
+		opts.Width = w
+	}
+	if v := query.Get("q"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(TransformQualities, q) {
+			return opts, fmt.Errorf("q must be one of %v", TransformQualities)
+		}
+		opts.Quality = q
+	}
+	if v := strings.ToLower(query.Get("format")); v != "" && v != "auto" {
+		if v == "jpeg" {
+			v = "jpg"
+		}
+		if !slices.Contains(TransformFormats, v) {
+			return opts, fmt.Errorf("format must be one of %v or auto", TransformFormats)
+		}
+		opts.Format = v
+	}
+	return opts, nil
+}
+
+// TransformedImage is a file ready to serve.
+type TransformedImage struct {
+	Path        string
+	ContentType string
+	// Immutable is set when the ID was a content digest, so the response
+	// can never change. Block IDs resolve through a ref that moves when the
+	// image is replaced in Notion, and files stored before content
+	// addressing are named by block or entry ID and get overwritten.
+	Immutable bool
+	// Negotiated is set when the format was picked from Accept, so the
+	// response must Vary on it.
+	Negotiated bool
+}
+
+// transformSlots bounds concurrent encodes from the endpoint; cwebp and
+// avifenc are CPU-heavy and requests shouldn't starve the server.
+var transformSlots = make(chan struct{}, 2)
+
+// TransformImage returns the image id (a digest, block ID or entry ID)
+// transformed per opts, encoding and caching it under ./images/cache on
+// first request. accept is the request's Accept header, used when
+// opts.Format is empty.
+func TransformImage(id string, opts TransformOptions, accept string) (TransformedImage, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return TransformedImage{}, ErrImageNotFound
+	}
+	dir, err := imagesDir()
+	if err != nil {
+		return TransformedImage{}, err
+	}
+	key := imageKey(id)
+	meta, err := readImageMeta(key)
+	if err != nil {
+		return TransformedImage{}, err
+	}
+	origPath := filepath.Join(dir, key+"."+meta.FallbackExt)
+	if meta.FallbackExt == "" || !fileExists(origPath) {
+		return TransformedImage{}, ErrImageNotFound
+	}
+	result := TransformedImage{Immutable: key == id && isDigest(id), Negotiated: opts.Format == ""}
+
+	width := opts.Width
+	if width >= meta.Width && meta.Width > 0 {
+		width = 0 // never upscale
+	}
+	format := opts.Format
+	if format == "" {
+		format = negotiateFormat(accept, meta.FallbackExt)
+	}
+
+	// The original itself answers the request: no resize, same format,
+	// default quality.
+	if width == 0 && opts.Quality == 0 && format == meta.FallbackExt {
+		result.Path, result.ContentType = origPath, imageContentType(origPath)
+		return result, nil
+	}
+	if _, err := transformEncoder(format); err != nil && opts.Format == "" {
+		// A GIF or WebP original needing a resize we can't write in its
+		// own format.
+		format = "png"
+	}
+	enc, err := transformEncoder(format)
+	if err != nil {
+		return TransformedImage{}, err
+	}
+	result.ContentType = enc.MIMEType()
+
+	cacheDir := filepath.Join(dir, "cache")
+	name := transformCacheName(key, width, opts.Quality, format)
+	path := filepath.Join(cacheDir, name)
+	result.Path = path
+	if fileExists(path) {
+		return result, nil
+	}
+
+	unlock := lockDigest("transform:" + name)
+	defer unlock()
+	if fileExists(path) {
+		return result, nil
+	}
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		return TransformedImage{}, fmt.Errorf("error creating image cache dir: %v", err)
+	}
+	transformSlots <- struct{}{}
+	defer func() { <-transformSlots }()
+
+	// Encode to a temporary name so a crash or concurrent reader never
+	// sees a partial file at the cached path.
+	tmp := filepath.Join(cacheDir, ".tmp-"+name)
+	if err := imageenc.EncodeQuality(enc, origPath, tmp, width, opts.Quality); err != nil {
+		os.Remove(tmp)
+		return TransformedImage{}, fmt.Errorf("transforming %s: %w", id, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return TransformedImage{}, err
+	}
+	return result, nil
+}
+
+// transformCacheName is the cache file name for a transform; quality 0 is
+// the encoder default.
+func transformCacheName(key string, width, quality int, format string) string {
+	return fmt.Sprintf("%s-w%d-q%d.%s", key, width, quality, format)
+}
+
+// negotiateFormat picks the smallest format the client accepts and this
+// host can encode, falling back to the original's format.
+func negotiateFormat(accept, original string) string {
+	for _, enc := range imageenc.Encoders() {
+		if enc.Available() && acceptsType(accept, enc.MIMEType()) {
+			return enc.Format()
+		}
+	}
+	return original
+}
+
+// acceptsType reports whether the Accept header lists mime with a non-zero
+// q. Wildcards don't count: browsers send */* without being able to decode
+// AVIF.
+func acceptsType(accept, mime string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mime) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// transformEncoder returns an available encoder for format.
+func transformEncoder(format string) (imageenc.Encoder, error) {
+	for _, enc := range imageenc.Encoders() {
+		if enc.Format() == format {
+			if !enc.Available() {
+				return nil, fmt.Errorf("%w: %s", ErrFormatUnavailable, format)
+			}
+			return enc, nil
+		}
+	}
+	if enc, ok := imageenc.Native(format); ok {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrFormatUnavailable, format)
+}
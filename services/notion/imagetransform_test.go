@@ -0,0 +1,151 @@
+package notion
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTransformOptions(t *testing.T) {
+	opts, err := ParseTransformOptions(url.Values{"w": {"480"}, "q": {"65"}, "format": {"JPEG"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts != (TransformOptions{Width: 480, Quality: 65, Format: "jpg"}) {
+		t.Fatalf("opts: %+v", opts)
+	}
+	if opts, err := ParseTransformOptions(url.Values{"format": {"auto"}}); err != nil || opts.Format != "" {
+		t.Fatalf("auto: %+v, %v", opts, err)
+	}
+	for _, q := range []url.Values{
+		{"w": {"500"}},
+		{"w": {"-1"}},
+		{"q": {"100"}},
+		{"format": {"bmp"}},
+	} {
+		if _, err := ParseTransformOptions(q); err == nil {
+			t.Fatalf("%v should be rejected", q)
+		}
+	}
+}
+
+func TestAcceptsType(t *testing.T) {
+	chrome := "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	if !acceptsType(chrome, "image/avif") || !acceptsType(chrome, "image/webp") {
+		t.Fatal("chrome accepts avif and webp")
+	}
+	if acceptsType("image/*,*/*", "image/webp") {
+		t.Fatal("wildcards must not count")
+	}
+	if acceptsType("image/webp;q=0, image/png", "image/webp") {
+		t.Fatal("q=0 refuses the type")
+	}
+}
+
+func TestTransformImage(t *testing.T) {
+	t.Setenv("PATH", "") // pure-Go encoders only
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1200, 600))); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storeImageBytes("block", "", buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	key := storedKey(t, "block")
+
+	img, err := TransformImage(key, TransformOptions{Width: 480, Format: "jpg", Quality: 65}, "")
+	if err != nil {
+		t.Fatalf("TransformImage: %v", err)
+	}
+	if img.ContentType != "image/jpeg" || !img.Immutable || img.Negotiated {
+		t.Fatalf("result: %+v", img)
+	}
+	if want, _ := filepath.Abs(filepath.Join("images", "cache", key+"-w480-q65.jpg")); img.Path != want {
+		t.Fatalf("path: got %s, want %s", img.Path, want)
+	}
+	f, err := os.Open(img.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := decoded.Bounds(); b.Dx() != 480 || b.Dy() != 240 {
+		t.Fatalf("dims: %dx%d", b.Dx(), b.Dy())
+	}
+
+	// Cached: served again without re-encoding.
+	info, _ := os.Stat(img.Path)
+	again, err := TransformImage(key, TransformOptions{Width: 480, Format: "jpg", Quality: 65}, "")
+	if err != nil || again.Path != img.Path {
+		t.Fatalf("second request: %+v, %v", again, err)
+	}
+	if info2, _ := os.Stat(again.Path); !info2.ModTime().Equal(info.ModTime()) {
+		t.Fatal("cached transform was re-encoded")
+	}
+
+	// By block ID: same image, but the ref can move, so not immutable.
+	byBlock, err := TransformImage("block", TransformOptions{Width: 480, Format: "jpg", Quality: 65}, "")
+	if err != nil || byBlock.Immutable || byBlock.Path != img.Path {
+		t.Fatalf("by block ID: %+v, %v", byBlock, err)
+	}
+
+	// No AVIF/WebP encoder here: Accept can't upgrade it, and without a
+	// resize (or an upscale request) the original is served as-is.
+	orig, err := TransformImage(key, TransformOptions{Width: 1920}, "image/avif,image/webp,*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(orig.Path) != key+".png" || orig.ContentType != "image/png" || !orig.Negotiated {
+		t.Fatalf("original: %+v", orig)
+	}
+
+	if _, err := TransformImage(key, TransformOptions{Format: "webp"}, ""); !errors.Is(err, ErrFormatUnavailable) {
+		t.Fatalf("webp without cwebp: %v", err)
+	}
+	for _, id := range []string{"missing", "../" + key, key + ".png"} {
+		if _, err := TransformImage(id, TransformOptions{}, ""); !errors.Is(err, ErrImageNotFound) {
+			t.Fatalf("%q: want ErrImageNotFound, got %v", id, err)
+		}
+	}
+}
+
+func TestTransformImage_LegacyIDIsNotImmutable(t *testing.T) {
+	t.Setenv("PATH", "")
+	chdirTo(t, t.TempDir())
+	t.Setenv("DEV", "true")
+	if err := os.MkdirAll("images", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 32))); err != nil {
+		t.Fatal(err)
+	}
+	// Stored by block ID before content addressing: no ref.
+	meta, err := writeImage("images", "legacy-block", "png", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeImageMeta("images", "legacy-block", meta); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := TransformImage("legacy-block", TransformOptions{Width: 32, Format: "jpg"}, "")
+	if err != nil {
+		t.Fatalf("TransformImage: %v", err)
+	}
+	if img.Immutable {
+		t.Fatal("a legacy block ID's image can be replaced, so it must not be immutable")
+	}
+}
@@ -2,11 +2,17 @@ package notion
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"html/template"
 	log "htmx-blog/logging"
+	"htmx-blog/metrics"
 	"htmx-blog/models"
+	"htmx-blog/tracing"
 	"io"
 	"net/http"
 	"os"
@@ -65,20 +71,39 @@ type Entry struct {
 }
 
 type SlugEntry struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	CreatedTime string `json:"created_time"`
-	Slug        string `json:"slug"`
+	ID             string    `json:"id"`
+	Title          string    `json:"title"`
+	CreatedTime    time.Time `json:"created_time"`
+	LastEditedTime time.Time `json:"last_edited_time"`
+	Slug           string    `json:"slug"`
+	Tags           []string  `json:"tags,omitempty"`
+	Description    string    `json:"description,omitempty"`
 }
 
 type ReadingNow struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	CreatedTime string `json:"created_time"`
-	Image       string `json:"image,omitempty"`
-	Comment     string `json:"comment,omitempty"`
-	Progress    string `json:"progress,omitempty"`
-	Author      string `json:"author,omitempty"`
+	ID             string    `json:"id"`
+	Title          string    `json:"title"`
+	CreatedTime    time.Time `json:"created_time"`
+	LastEditedTime time.Time `json:"last_edited_time"`
+	Image          string    `json:"image,omitempty"`
+	Comment        string    `json:"comment,omitempty"`
+	Progress       string    `json:"progress,omitempty"`
+	Author         string    `json:"author,omitempty"`
+}
+
+// parseEntryTimes parses a database row's RFC 3339 created/last-edited
+// timestamps. Failures are logged and leave the zero time, which list views
+// treat as undated.
+func parseEntryTimes(entry Entry) (created, lastEdited time.Time) {
+	created, err := time.Parse(time.RFC3339, entry.CreatedTime)
+	if err != nil {
+		log.Error("error parsing created time for %s: %v", entry.ID, err)
+	}
+	lastEdited, err = time.Parse(time.RFC3339, entry.LastEditedTime)
+	if err != nil && entry.LastEditedTime != "" {
+		log.Error("error parsing last edited time for %s: %v", entry.ID, err)
+	}
+	return created, lastEdited
 }
 
 type Properties struct {
@@ -88,6 +113,9 @@ type Properties struct {
 	Image    PropertyImage  `json:"image"`
 	Comment  Slug           `json:"comment"`
 	Progress PropertyNumber `json:"progress"`
+	Tags     MultiSelect    `json:"tags"`
+	// Description is an optional rich-text summary used as the post excerpt.
+	Description Slug `json:"description"`
 }
 
 type Name struct {
@@ -150,6 +178,28 @@ type PropertyImage struct {
 	} `json:"files"`
 }
 
+type MultiSelect struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	MultiSelect []struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	} `json:"multi_select"`
+}
+
+// Names returns the option names in the order Notion returned them.
+func (m MultiSelect) Names() []string {
+	if len(m.MultiSelect) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(m.MultiSelect))
+	for _, opt := range m.MultiSelect {
+		names = append(names, opt.Name)
+	}
+	return names
+}
+
 type PropertyNumber struct {
 	ID     string   `json:"id"`
 	Type   string   `json:"type"`
@@ -173,16 +223,55 @@ type QueryBlockChildrenResponse struct {
 }
 
 type NotionClient interface {
-	GetBlockChildren(blockID string) ([]json.RawMessage, error)
+	GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error)
 	GetBlock(blockID string) (models.Block, error)
-	GetPage(pageID string) (models.Page, error)
-	GetAllPosts(databaseID string, filter string) (map[string]string, error)
-	GetSlugEntries(databaseID string, filter string) ([]SlugEntry, error)
-	GetReadingNowEntries(datasourceID string, filter string) ([]ReadingNow, error)
+	GetPage(ctx context.Context, pageID string) (models.Page, error)
+	GetAllPosts(ctx context.Context, databaseID string, filter string) (map[string]string, error)
+	GetSlugEntries(ctx context.Context, databaseID string, filter string) ([]SlugEntry, error)
+	GetReadingNowEntries(ctx context.Context, datasourceID string, filter string) ([]ReadingNow, error)
 	GetDatabaseID() string
 	ParseAndWriteNotionBlock(writer io.Writer, rawBlock []byte, postType string) error
 }
 
+var (
+	notionRequests = metrics.NewCounter("blog_notion_api_requests_total",
+		"Notion API calls by operation.", "operation")
+	notionErrors = metrics.NewCounter("blog_notion_api_errors_total",
+		"Notion API calls that failed or got an error status, by operation.", "operation")
+)
+
+// doNotionRequest sends req to the Notion API and counts it under operation.
+// Transport errors and 4xx/5xx responses count as errors; the response is
+// returned as before either way.
+//
+// ctx only carries the trace the call's span joins: the request isn't
+// cancelled with it, so a fetch the reader gave up on still fills the cache.
+func doNotionRequest(ctx context.Context, operation string, req *http.Request) (*http.Response, error) {
+	_, span := tracing.Tracer().Start(ctx, "notion."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("notion.operation", operation),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+		),
+	)
+	defer span.End()
+
+	notionRequests.Inc(operation)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		notionErrors.Inc(operation)
+		tracing.RecordError(span, err)
+		return resp, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		notionErrors.Inc(operation)
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, err
+}
+
 // this should only be called by cache service to get the data
 // from notion and store it in JSON file cache
 func NewNotionClient() NotionClient {
@@ -206,7 +295,7 @@ func (nc *notionClient) GetBlock(blockID string) (models.Block, error) {
 }
 
 // GetBlockChildren implements NotionClient.
-func (nc *notionClient) GetBlockChildren(blockID string) ([]json.RawMessage, error) {
+func (nc *notionClient) GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
 	var body []byte
 	br := bytes.NewBuffer(body)
 	req, err := http.NewRequest("GET", "https://api.notion.com/v1/blocks/"+blockID+"/children", br)
@@ -216,7 +305,7 @@ func (nc *notionClient) GetBlockChildren(blockID string) ([]json.RawMessage, err
 	req.Header.Set("Authorization", "Bearer "+nc.NotionToken)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Notion-Version", "2022-06-28")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doNotionRequest(ctx, "get_block_children", req)
 	if err != nil {
 		return nil, err
 	}
@@ -225,7 +314,7 @@ func (nc *notionClient) GetBlockChildren(blockID string) ([]json.RawMessage, err
 	//check for rate limit
 	// todo add retry logic
 	if resp.StatusCode == http.StatusTooManyRequests {
-		log.Info("rate limit hit u greedy fucker!")
+		log.WarnContext(ctx, "Notion rate limit hit", "block", blockID)
 		return nil, err
 	}
 
@@ -238,7 +327,7 @@ func (nc *notionClient) GetBlockChildren(blockID string) ([]json.RawMessage, err
 }
 
 // GetPage implements NotionClient.
-func (nc *notionClient) GetPage(pageID string) (models.Page, error) {
+func (nc *notionClient) GetPage(ctx context.Context, pageID string) (models.Page, error) {
 	var body []byte
 	br := bytes.NewBuffer(body)
 	req, err := http.NewRequest("GET", "https://api.notion.com/v1/pages/"+pageID, br)
@@ -246,7 +335,7 @@ func (nc *notionClient) GetPage(pageID string) (models.Page, error) {
 		return models.Page{}, err
 	}
 	req.Header.Set("Authorization", "Bearer "+nc.NotionToken)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doNotionRequest(ctx, "get_page", req)
 	if err != nil {
 		return models.Page{}, err
 	}
@@ -259,7 +348,7 @@ func (nc *notionClient) GetPage(pageID string) (models.Page, error) {
 	return page, nil
 }
 
-func (nc *notionClient) GetAllPosts(databaseID string, filter string) (map[string]string, error) {
+func (nc *notionClient) GetAllPosts(ctx context.Context, databaseID string, filter string) (map[string]string, error) {
 	body, err := marshalBlogPostsQuery(filter, false)
 	if err != nil {
 		return nil, err
@@ -273,7 +362,7 @@ func (nc *notionClient) GetAllPosts(databaseID string, filter string) (map[strin
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Notion-Version", "2022-06-28")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doNotionRequest(ctx, "query_database", req)
 	if err != nil {
 		return nil, err
 	}
@@ -300,7 +389,7 @@ func (nc *notionClient) GetAllPosts(databaseID string, filter string) (map[strin
 	return posts, nil
 }
 
-func (nc *notionClient) GetSlugEntries(datasourceID string, filter string) ([]SlugEntry, error) {
+func (nc *notionClient) GetSlugEntries(ctx context.Context, datasourceID string, filter string) ([]SlugEntry, error) {
 	body, err := marshalBlogPostsQuery(filter, true)
 	if err != nil {
 		return nil, err
@@ -313,8 +402,8 @@ func (nc *notionClient) GetSlugEntries(datasourceID string, filter string) ([]Sl
 	req.Header.Set("Authorization", "Bearer "+nc.NotionToken)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Notion-Version", "2025-09-03")
-	log.Info("making request to notion for slug entries, %+v", req)
-	resp, err := http.DefaultClient.Do(req)
+	log.DebugContext(ctx, "querying Notion for slug entries", "datasource", datasourceID, "filter", filter)
+	resp, err := doNotionRequest(ctx, "query_slugs", req)
 	if err != nil {
 		return nil, err
 	}
@@ -335,19 +424,18 @@ func (nc *notionClient) GetSlugEntries(datasourceID string, filter string) ([]Sl
 			continue
 		}
 
-		parsedTime, err := time.Parse(time.RFC3339, entry.CreatedTime)
-		if err != nil {
-			log.Error("error parsing time: %v", err)
-		} else {
-			readableFormat := "January 2, 2006 at 15:04"
-			entry.CreatedTime = parsedTime.Format(readableFormat)
-		}
+		createdTime, lastEditedTime := parseEntryTimes(entry)
 
 		slugEntry := SlugEntry{
-			ID:          entry.ID,
-			Title:       entry.Properties.Name.Title[0].PlainText,
-			CreatedTime: entry.CreatedTime,
-			Slug:        entry.Properties.Slug.RichText[0].PlainText,
+			ID:             entry.ID,
+			Title:          entry.Properties.Name.Title[0].PlainText,
+			CreatedTime:    createdTime,
+			LastEditedTime: lastEditedTime,
+			Slug:           entry.Properties.Slug.RichText[0].PlainText,
+			Tags:           entry.Properties.Tags.Names(),
+		}
+		for _, rt := range entry.Properties.Description.RichText {
+			slugEntry.Description += rt.PlainText
 		}
 
 		// append to slice
@@ -357,7 +445,7 @@ func (nc *notionClient) GetSlugEntries(datasourceID string, filter string) ([]Sl
 	return slugEntries, nil
 }
 
-func (nc *notionClient) GetReadingNowEntries(datasourceID string, filter string) ([]ReadingNow, error) {
+func (nc *notionClient) GetReadingNowEntries(ctx context.Context, datasourceID string, filter string) ([]ReadingNow, error) {
 	bodyPayload := bytes.NewBuffer([]byte(fmt.Sprintf(`{
 		"filter": {
 			"property": "tags",
@@ -379,7 +467,7 @@ func (nc *notionClient) GetReadingNowEntries(datasourceID string, filter string)
 	req.Header.Set("Authorization", "Bearer "+nc.NotionToken)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Notion-Version", "2025-09-03")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doNotionRequest(ctx, "query_reading_now", req)
 	if err != nil {
 		return nil, err
 	}
@@ -397,18 +485,13 @@ func (nc *notionClient) GetReadingNowEntries(datasourceID string, filter string)
 			continue
 		}
 
-		parsedTime, err := time.Parse(time.RFC3339, entry.CreatedTime)
-		if err != nil {
-			log.Error("error parsing time: %v", err)
-		} else {
-			readableFormat := "January 2, 2006 at 15:04"
-			entry.CreatedTime = parsedTime.Format(readableFormat)
-		}
+		createdTime, lastEditedTime := parseEntryTimes(entry)
 
 		slugEntry := ReadingNow{
-			ID:          entry.ID,
-			Title:       entry.Properties.Name.Title[0].PlainText,
-			CreatedTime: entry.CreatedTime,
+			ID:             entry.ID,
+			Title:          entry.Properties.Name.Title[0].PlainText,
+			CreatedTime:    createdTime,
+			LastEditedTime: lastEditedTime,
 		}
 
 		// Handle author (rich_text field)
@@ -422,13 +505,13 @@ func (nc *notionClient) GetReadingNowEntries(datasourceID string, filter string)
 		// Handle image (files field)
 		if len(entry.Properties.Image.Files) > 0 {
 			imgFile := entry.Properties.Image.Files[0]
-			log.Info("entry %s has image file: type=%s external=%s file=%s", slugEntry.Title, imgFile.Type, imgFile.External.URL, imgFile.File.URL)
-			slugEntry.Image, err = convertAndStoreImage(entry)
+			log.Debug("entry %s has image file: type=%s external=%s file=%s", slugEntry.Title, imgFile.Type, imgFile.External.URL, imgFile.File.URL)
+			slugEntry.Image, err = convertAndStoreImage(ctx, entry)
 			if err != nil {
-				log.Error("error converting and storing image: %v", err)
+				log.ErrorContext(ctx, "converting and storing image", "entry", slugEntry.Title, "err", err)
 			}
 		} else {
-			log.Info("entry %s has no image files (property type=%s)", slugEntry.Title, entry.Properties.Image.Type)
+			log.Debug("entry %s has no image files (property type=%s)", slugEntry.Title, entry.Properties.Image.Type)
 		}
 		// Handle comment (rich_text field that might be empty)
 		if len(entry.Properties.Comment.RichText) > 0 {
@@ -440,7 +523,7 @@ func (nc *notionClient) GetReadingNowEntries(datasourceID string, filter string)
 	return readnowEntries, nil
 }
 
-func convertAndStoreImage(entry Entry) (string, error) {
+func convertAndStoreImage(ctx context.Context, entry Entry) (string, error) {
 	imageFile := entry.Properties.Image.Files[0]
 	sourceURL := imageFile.External.URL
 	if sourceURL == "" {
@@ -450,19 +533,15 @@ func convertAndStoreImage(entry Entry) (string, error) {
 		return "", fmt.Errorf("no image URL found for entry %s", entry.ID)
 	}
 
-	resp, err := http.Get(sourceURL)
-	if err != nil {
-		return "", fmt.Errorf("error downloading image: %v", err)
-	}
-	defer resp.Body.Close()
-
-	imageBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading image bytes: %v", err)
+	if url, ok := storedImageURL(entry.ID, sourceURL); ok {
+		return url, nil
 	}
 
-	url, _, err := storeImageBytes(entry.ID, imageBytes)
+	_, span := tracing.Start(ctx, "notion.download_image", attribute.String("notion.block_id", entry.ID))
+	defer span.End()
+	url, err := downloadAndStoreImage(ctx, entry.ID, sourceURL)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return "", err
 	}
 	return url, nil
@@ -476,6 +555,8 @@ type converter struct {
 	rawBlock []byte
 	writer   io.Writer
 	postType string
+	// anchorID is set when the page renderer assigned the block a heading anchor.
+	anchorID string
 }
 
 func extractLinkURL(link any) string {
@@ -574,6 +655,7 @@ func (c *converter) RenderHeading1() error {
 		return nil
 	}
 	block.Content = block.Heading1.Text[0].Text.Content
+	block.AnchorID = c.anchorID
 	err = tmpl.Execute(c.writer, block)
 	if err != nil {
 		return err
@@ -603,6 +685,7 @@ func (c *converter) RenderHeading2() error {
 		return nil
 	}
 	block.Content = block.Heading2.Text[0].Text.Content
+	block.AnchorID = c.anchorID
 	err = tmpl.Execute(c.writer, block)
 	if err != nil {
 		return err
@@ -632,6 +715,7 @@ func (c *converter) RenderHeading3() error {
 		return nil
 	}
 	block.Content = block.Heading3.Text[0].Text.Content
+	block.AnchorID = c.anchorID
 	err = tmpl.Execute(c.writer, block)
 	if err != nil {
 		return err
@@ -734,36 +818,41 @@ func (c *converter) RenderImage() error {
 	}
 
 	// Meta sidecar is best-effort: missing/unreadable → no dimensions, no
-	// <picture> element (HasWebP stays false), template degrades to a plain
-	// <img> pointing at whatever URL the block already stored.
-	meta, _ := readImageMeta(block.ID)
+	// derived formats, template degrades to a plain <img> pointing at
+	// whatever URL the block already stored.
+	key := imageKey(block.ID)
+	meta, _ := readImageMeta(key)
 
 	renderData := struct {
-		WebpURL     string
 		FallbackURL string
+		Sources     []pictureSource
+		// ImgSrcSet lists pure-Go variants of the original, when there are any.
+		ImgSrcSet string
+		Sizes     string
+		// Placeholder paints the dominant colour and LQIP behind the image.
+		Placeholder template.CSS
 		Width       int
 		Height      int
-		HasWebP     bool
 		PostType    string
 	}{
-		PostType: c.postType,
-		Width:    meta.Width,
-		Height:   meta.Height,
-	}
-
-	if meta.HasWebP {
-		renderData.HasWebP = true
-		renderData.WebpURL = imageURLFor(block.ID, "webp")
-		if meta.FallbackExt != "" && meta.FallbackExt != "webp" {
-			renderData.FallbackURL = imageURLFor(block.ID, meta.FallbackExt)
-		} else {
-			renderData.FallbackURL = renderData.WebpURL
+		PostType:    c.postType,
+		Width:       meta.Width,
+		Height:      meta.Height,
+		Sources:     meta.PictureSources(key),
+		Placeholder: meta.PlaceholderStyle(),
+		FallbackURL: block.Image.File.URL,
+	}
+	if len(renderData.Sources) > 0 && meta.FallbackExt != "" {
+		renderData.FallbackURL = imageURLFor(key, meta.FallbackExt)
+	}
+	if meta.FallbackExt != "" {
+		if renderData.ImgSrcSet = meta.SrcSet(key, meta.FallbackExt); renderData.ImgSrcSet != "" {
+			renderData.FallbackURL = imageURLFor(key, meta.FallbackExt)
+			renderData.Sizes = imageSizes
 		}
-	} else {
-		renderData.FallbackURL = block.Image.File.URL
 	}
 
-	log.Info("rendering image block with post type: %s", c.postType)
+	log.Debug("rendering image block with post type: %s", c.postType)
 	return tmpl.Execute(c.writer, renderData)
 }
 
@@ -847,29 +936,28 @@ func (nc *notionClient) ParseAndWriteNotionBlock(writer io.Writer, rawBlock []by
 	}
 }
 
-// StoreNotionImage downloads the image for a Notion image block, writes the
-// original plus a WebP sibling and a metadata sidecar under ./images/, and
-// rewrites the block's URL to point at the optimised copy (falling back to
-// the original when WebP encoding isn't possible).
-func StoreNotionImage(rawBlocks []json.RawMessage, i int) error {
+// StoreNotionImage downloads the image for a Notion image block, stores it
+// content-addressed under ./images/ (see storeImageBytes), and rewrites the
+// block's URL to point at the optimised copy (falling back to the original
+// when WebP encoding isn't possible). Images already stored from the same
+// upload aren't downloaded again, and a failed download keeps the image
+// stored before.
+func StoreNotionImage(ctx context.Context, rawBlocks []json.RawMessage, i int) error {
 	var imageBlock models.Image
 	if err := json.Unmarshal(rawBlocks[i], &imageBlock); err != nil {
 		log.Error("error unmarshalling imageblock: %v", err)
 		return err
 	}
 	awsImageURL := imageBlock.Image.File.URL
-	resp, err := http.Get(awsImageURL)
-	if err != nil {
-		return fmt.Errorf("error downloading image from s3: %v", err)
+	if storedURL, ok := storedImageURL(imageBlock.ID, awsImageURL); ok {
+		imageBlock.Image.File.URL = storedURL
+		var err error
+		if rawBlocks[i], err = json.Marshal(imageBlock); err != nil {
+			return fmt.Errorf("error marshalling imageblock: %v", err)
+		}
+		return nil
 	}
-	defer resp.Body.Close()
-
-	imageBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading image bytes: %v", err)
-	}
-
-	newURL, _, err := storeImageBytes(imageBlock.ID, imageBytes)
+	newURL, err := downloadAndStoreImage(ctx, imageBlock.ID, awsImageURL)
 	if err != nil {
 		return err
 	}
@@ -881,3 +969,23 @@ func StoreNotionImage(rawBlocks []json.RawMessage, i int) error {
 	}
 	return nil
 }
+
+// downloadAndStoreImage fetches sourceURL with imageDownloader and stores it
+// for id. If the download or store fails but an earlier image is stored for
+// id, that image's URL is returned instead, so a bad response (an expired
+// link, an error page, a truncated body) never replaces a good image.
+func downloadAndStoreImage(ctx context.Context, id, sourceURL string) (string, error) {
+	body, err := imageDownloader.Get(ctx, sourceURL)
+	var url string
+	if err == nil {
+		url, _, err = storeImageBytes(id, sourceURL, body)
+	}
+	if err == nil {
+		return url, nil
+	}
+	if previous, ok := previousImageURL(id); ok {
+		log.Error("keeping previously stored image for %s: %v", id, err)
+		return previous, nil
+	}
+	return "", err
+}
@@ -0,0 +1,112 @@
+package notion
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"path/filepath"
+
+	"htmx-blog/models"
+	"htmx-blog/services/content"
+)
+
+// headingBlock is the subset of a heading_1/2/3 block needed to read its text.
+// The three heading types share the same rich-text shape under different keys.
+type headingBlock struct {
+	Type     string      `json:"type"`
+	Heading1 headingBody `json:"heading_1"`
+	Heading2 headingBody `json:"heading_2"`
+	Heading3 headingBody `json:"heading_3"`
+}
+
+type headingBody struct {
+	RichText []struct {
+		Text struct {
+			Content string `json:"content"`
+		} `json:"text"`
+	} `json:"rich_text"`
+}
+
+// headingOf returns the level and display text of a heading block. The text
+// matches what RenderHeading1/2/3 print (the first rich-text segment) so the
+// table of contents and anchors agree with the rendered heading.
+func headingOf(rawBlock []byte) (int, string, bool) {
+	var b headingBlock
+	if err := json.Unmarshal(rawBlock, &b); err != nil {
+		return 0, "", false
+	}
+	var level int
+	var body headingBody
+	switch b.Type {
+	case "heading_1":
+		level, body = 1, b.Heading1
+	case "heading_2":
+		level, body = 2, b.Heading2
+	case "heading_3":
+		level, body = 3, b.Heading3
+	default:
+		return 0, "", false
+	}
+	// Empty headings render nothing, so they get no anchor either.
+	if len(body.RichText) == 0 {
+		return 0, "", false
+	}
+	return level, body.RichText[0].Text.Content, true
+}
+
+// HeadingOf implements content.OutlineRenderer.
+func (r *notionBlockRenderer) HeadingOf(rawBlock []byte) (int, string, bool) {
+	return headingOf(rawBlock)
+}
+
+// IsTableOfContents implements content.OutlineRenderer. Notion's
+// table_of_contents block carries no data; its position is all that matters.
+func (r *notionBlockRenderer) IsTableOfContents(rawBlock []byte) bool {
+	var b models.Block
+	if err := json.Unmarshal(rawBlock, &b); err != nil {
+		return false
+	}
+	return b.Type == "table_of_contents"
+}
+
+// RenderHeading implements content.OutlineRenderer.
+func (r *notionBlockRenderer) RenderHeading(writer io.Writer, rawBlock []byte, postType, anchorID string) error {
+	c := &converter{
+		rawBlock: rawBlock,
+		writer:   writer,
+		postType: postType,
+		anchorID: anchorID,
+	}
+	level, _, ok := headingOf(rawBlock)
+	if !ok {
+		return nil
+	}
+	switch level {
+	case 1:
+		return c.RenderHeading1()
+	case 2:
+		return c.RenderHeading2()
+	default:
+		return c.RenderHeading3()
+	}
+}
+
+// RenderTableOfContents implements content.OutlineRenderer.
+func (r *notionBlockRenderer) RenderTableOfContents(writer io.Writer, headings []content.Heading, placement content.TOCPlacement) error {
+	templatePath, err := filepath.Abs("./templates/notion/blocks/table_of_contents.html")
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.ParseFiles(templatePath)
+	if err != nil {
+		return err
+	}
+	renderData := struct {
+		Headings  []content.Heading
+		Placement string
+	}{
+		Headings:  headings,
+		Placement: string(placement),
+	}
+	return tmpl.Execute(writer, renderData)
+}
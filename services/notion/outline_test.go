@@ -0,0 +1,27 @@
+package notion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HeadingOf(t *testing.T) {
+	level, text, ok := headingOf([]byte(`{"type":"heading_2","heading_2":{"rich_text":[{"text":{"content":"Day one"}},{"text":{"content":" in Kyoto"}}]}}`))
+	assert.True(t, ok)
+	assert.Equal(t, 2, level)
+	assert.Equal(t, "Day one", text)
+
+	_, _, ok = headingOf([]byte(`{"type":"heading_1","heading_1":{"rich_text":[]}}`))
+	assert.False(t, ok, "empty headings render nothing and get no anchor")
+
+	_, _, ok = headingOf([]byte(`{"type":"paragraph"}`))
+	assert.False(t, ok)
+}
+
+func Test_NotionBlockRenderer_IsTableOfContents(t *testing.T) {
+	renderer := &notionBlockRenderer{client: newMockNotionClientForSource()}
+
+	assert.True(t, renderer.IsTableOfContents([]byte(`{"type":"table_of_contents","table_of_contents":{"color":"default"}}`)))
+	assert.False(t, renderer.IsTableOfContents([]byte(`{"type":"heading_1"}`)))
+}
@@ -31,12 +31,12 @@ func NewSourceWithClient(client NotionClient) content.Source {
 
 // GetBlockChildren implements content.Source
 func (ns *notionSource) GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
-	return ns.client.GetBlockChildren(blockID)
+	return ns.client.GetBlockChildren(ctx, blockID)
 }
 
 // GetPostEntries implements content.Source
 func (ns *notionSource) GetPostEntries(ctx context.Context, collectionID, filter string) ([]content.PostEntry, error) {
-	slugEntries, err := ns.client.GetSlugEntries(collectionID, filter)
+	slugEntries, err := ns.client.GetSlugEntries(ctx, collectionID, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +48,10 @@ func (ns *notionSource) GetPostEntries(ctx context.Context, collectionID, filter
 			ID:          se.ID,
 			Title:       se.Title,
 			CreatedTime: se.CreatedTime,
+			UpdatedTime: se.LastEditedTime,
 			Slug:        se.Slug,
+			Tags:        se.Tags,
+			Excerpt:     se.Description,
 		}
 	}
 	return entries, nil
@@ -56,7 +59,7 @@ func (ns *notionSource) GetPostEntries(ctx context.Context, collectionID, filter
 
 // GetReadingEntries implements content.Source
 func (ns *notionSource) GetReadingEntries(ctx context.Context, collectionID, filter string) ([]content.ReadingEntry, error) {
-	readingNowEntries, err := ns.client.GetReadingNowEntries(collectionID, filter)
+	readingNowEntries, err := ns.client.GetReadingNowEntries(ctx, collectionID, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -68,6 +71,7 @@ func (ns *notionSource) GetReadingEntries(ctx context.Context, collectionID, fil
 			ID:          rn.ID,
 			Title:       rn.Title,
 			CreatedTime: rn.CreatedTime,
+			UpdatedTime: rn.LastEditedTime,
 			Image:       rn.Image,
 			Comment:     rn.Comment,
 			Progress:    rn.Progress,
@@ -84,7 +88,7 @@ func (ns *notionSource) GetDefaultCollectionID() string {
 
 // ProcessBlockForStorage implements content.Source
 // For Notion, this handles downloading and storing images locally
-func (ns *notionSource) ProcessBlockForStorage(blocks []json.RawMessage, index int) error {
+func (ns *notionSource) ProcessBlockForStorage(ctx context.Context, blocks []json.RawMessage, index int) error {
 	var b models.Block
 	if err := json.Unmarshal(blocks[index], &b); err != nil {
 		return err
@@ -92,7 +96,7 @@ func (ns *notionSource) ProcessBlockForStorage(blocks []json.RawMessage, index i
 
 	// Only process image blocks
 	if b.Type == "image" {
-		if err := StoreNotionImage(blocks, index); err != nil {
+		if err := StoreNotionImage(ctx, blocks, index); err != nil {
 			log.Error("error storing notion image: %v", err)
 			return err
 		}
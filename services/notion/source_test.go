@@ -8,6 +8,7 @@ import (
 	"htmx-blog/services/content"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,8 +27,8 @@ func newMockNotionClientForSource() *mockNotionClientForSource {
 			json.RawMessage(`{"type":"paragraph","id":"123"}`),
 		},
 		slugEntries: []SlugEntry{
-			{ID: "1", Title: "Test Post", Slug: "test-post", CreatedTime: "2024-01-01"},
-			{ID: "2", Title: "Another Post", Slug: "another-post", CreatedTime: "2024-01-02"},
+			{ID: "1", Title: "Test Post", Slug: "test-post", CreatedTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), LastEditedTime: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+			{ID: "2", Title: "Another Post", Slug: "another-post", CreatedTime: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
 		},
 		readingNowEntries: []ReadingNow{
 			{ID: "1", Title: "Test Book", Author: "Test Author", Progress: "50"},
@@ -36,15 +37,15 @@ func newMockNotionClientForSource() *mockNotionClientForSource {
 	}
 }
 
-func (m *mockNotionClientForSource) GetBlockChildren(blockID string) ([]json.RawMessage, error) {
+func (m *mockNotionClientForSource) GetBlockChildren(ctx context.Context, blockID string) ([]json.RawMessage, error) {
 	return m.blockChildren, nil
 }
 
-func (m *mockNotionClientForSource) GetSlugEntries(databaseID string, filter string) ([]SlugEntry, error) {
+func (m *mockNotionClientForSource) GetSlugEntries(ctx context.Context, databaseID string, filter string) ([]SlugEntry, error) {
 	return m.slugEntries, nil
 }
 
-func (m *mockNotionClientForSource) GetReadingNowEntries(datasourceID string, filter string) ([]ReadingNow, error) {
+func (m *mockNotionClientForSource) GetReadingNowEntries(ctx context.Context, datasourceID string, filter string) ([]ReadingNow, error) {
 	return m.readingNowEntries, nil
 }
 
@@ -56,11 +57,11 @@ func (m *mockNotionClientForSource) GetBlock(blockID string) (models.Block, erro
 	return models.Block{}, nil
 }
 
-func (m *mockNotionClientForSource) GetPage(pageID string) (models.Page, error) {
+func (m *mockNotionClientForSource) GetPage(ctx context.Context, pageID string) (models.Page, error) {
 	return models.Page{}, nil
 }
 
-func (m *mockNotionClientForSource) GetAllPosts(databaseID string, filter string) (map[string]string, error) {
+func (m *mockNotionClientForSource) GetAllPosts(ctx context.Context, databaseID string, filter string) (map[string]string, error) {
 	return nil, nil
 }
 
@@ -93,7 +94,8 @@ func Test_NotionSource_GetPostEntries(t *testing.T) {
 	assert.Equal(t, "1", entries[0].ID)
 	assert.Equal(t, "Test Post", entries[0].Title)
 	assert.Equal(t, "test-post", entries[0].Slug)
-	assert.Equal(t, "2024-01-01", entries[0].CreatedTime)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), entries[0].CreatedTime)
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), entries[0].UpdatedTime)
 
 	assert.Equal(t, "2", entries[1].ID)
 	assert.Equal(t, "Another Post", entries[1].Title)
@@ -133,7 +135,7 @@ func Test_NotionSource_ProcessBlockForStorage_NonImageBlock(t *testing.T) {
 	}
 
 	// Should not error for non-image blocks
-	err := source.ProcessBlockForStorage(blocks, 0)
+	err := source.ProcessBlockForStorage(context.Background(), blocks, 0)
 	assert.NoError(t, err)
 }
 
@@ -0,0 +1,63 @@
+package notion
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// textBlockTypes are the Notion block types whose rich text counts as
+// readable prose. Code is left out: it skews word counts and reading time and
+// never makes a good excerpt.
+var textBlockTypes = map[string]bool{
+	"paragraph":          true,
+	"heading_1":          true,
+	"heading_2":          true,
+	"heading_3":          true,
+	"bulleted_list_item": true,
+	"numbered_list_item": true,
+	"quote":              true,
+	"callout":            true,
+	"to_do":              true,
+	"toggle":             true,
+}
+
+// richTextBody is the shape shared by every rich-text block under its type key.
+type richTextBody struct {
+	RichText []struct {
+		PlainText string `json:"plain_text"`
+		Text      struct {
+			Content string `json:"content"`
+		} `json:"text"`
+	} `json:"rich_text"`
+}
+
+// blockPlainText returns the concatenated plain text of a Notion block and
+// whether the block is a paragraph.
+func blockPlainText(rawBlock []byte) (string, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rawBlock, &fields); err != nil {
+		return "", false
+	}
+	var blockType string
+	if err := json.Unmarshal(fields["type"], &blockType); err != nil || !textBlockTypes[blockType] {
+		return "", false
+	}
+	var body richTextBody
+	if err := json.Unmarshal(fields[blockType], &body); err != nil {
+		return "", false
+	}
+	var text strings.Builder
+	for _, rt := range body.RichText {
+		segment := rt.PlainText
+		if segment == "" {
+			segment = rt.Text.Content
+		}
+		text.WriteString(segment)
+	}
+	return text.String(), blockType == "paragraph"
+}
+
+// ExtractText implements content.TextExtractor
+func (ns *notionSource) ExtractText(rawBlock []byte) (string, bool) {
+	return blockPlainText(rawBlock)
+}
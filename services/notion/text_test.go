@@ -0,0 +1,23 @@
+package notion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BlockPlainText(t *testing.T) {
+	text, paragraph := blockPlainText([]byte(`{"type":"paragraph","paragraph":{"rich_text":[{"plain_text":"Hello "},{"plain_text":"","text":{"content":"world"}}]}}`))
+	assert.Equal(t, "Hello world", text)
+	assert.True(t, paragraph)
+
+	text, paragraph = blockPlainText([]byte(`{"type":"heading_2","heading_2":{"rich_text":[{"plain_text":"Day one"}]}}`))
+	assert.Equal(t, "Day one", text)
+	assert.False(t, paragraph)
+
+	text, _ = blockPlainText([]byte(`{"type":"code","code":{"rich_text":[{"plain_text":"fmt.Println()"}]}}`))
+	assert.Empty(t, text, "code is excluded from readable text")
+
+	text, _ = blockPlainText([]byte(`{"type":"image","image":{"file":{"url":"x"}}}`))
+	assert.Empty(t, text)
+}
@@ -0,0 +1,159 @@
+package visitors
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Names for bots recognised by their headers rather than a pattern.
+const (
+	botNoUserAgent      = "(no user agent)"
+	botHeadless         = "Headless Chrome"
+	botNoAcceptLanguage = "(no Accept-Language)"
+)
+
+//go:embed bots.txt
+var defaultBotPatterns string
+
+// botPattern names the bot whose user agent matches re.
+type botPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// botClassifier tells bots from browsers. Bots are counted by name instead
+// of as visitors.
+type botClassifier struct {
+	// browsers are user agents a pattern would wrongly match.
+	browsers []*regexp.Regexp
+	patterns []botPattern
+}
+
+type botStats struct {
+	Name     string `json:"name"`
+	Requests int    `json:"requests"`
+}
+
+// newBotClassifier loads the patterns in path, in the format of bots.txt, or
+// the built-in list when path is empty.
+func newBotClassifier(path string) (*botClassifier, error) {
+	if path == "" {
+		return parseBotPatterns(strings.NewReader(defaultBotPatterns))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open bot patterns %s: %w", path, err)
+	}
+	defer f.Close()
+	c, err := parseBotPatterns(f)
+	if err != nil {
+		return nil, fmt.Errorf("read bot patterns %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// parseBotPatterns reads `Name: pattern` and `!pattern` lines; see bots.txt.
+func parseBotPatterns(r io.Reader) (*botClassifier, error) {
+	c := &botClassifier{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if browser, ok := strings.CutPrefix(line, "!"); ok {
+			re, err := regexp.Compile("(?i)" + strings.TrimSpace(browser))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			c.browsers = append(c.browsers, re)
+			continue
+		}
+		name, pattern, ok := strings.Cut(line, ":")
+		name, pattern = strings.TrimSpace(name), strings.TrimSpace(pattern)
+		if !ok || name == "" || pattern == "" {
+			return nil, fmt.Errorf("line %d: want `Name: pattern`, got %q", n, line)
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		c.patterns = append(c.patterns, botPattern{name: name, re: re})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// classify returns the name of the bot that sent r, or "" for a browser.
+// Besides the user agent patterns, a request is a bot if it has no user
+// agent, announces a headless browser in its client hints, or has no
+// Accept-Language: every browser sends one when navigating.
+func (c *botClassifier) classify(r *http.Request) string {
+	ua := r.UserAgent()
+	if strings.TrimSpace(ua) == "" {
+		return botNoUserAgent
+	}
+	if name := c.match(ua); name != "" {
+		return name
+	}
+	if strings.Contains(strings.ToLower(r.Header.Get("Sec-CH-UA")), "headless") {
+		return botHeadless
+	}
+	if strings.TrimSpace(r.Header.Get("Accept-Language")) == "" {
+		return botNoAcceptLanguage
+	}
+	return ""
+}
+
+// match returns the name of the first pattern matching userAgent, unless it
+// is a known browser.
+func (c *botClassifier) match(userAgent string) string {
+	for _, re := range c.browsers {
+		if re.MatchString(userAgent) {
+			return ""
+		}
+	}
+	for _, p := range c.patterns {
+		if p.re.MatchString(userAgent) {
+			return p.name
+		}
+	}
+	return ""
+}
+
+// trackBot counts a request from the named bot towards today's bot traffic.
+func (t *tracker) trackBot(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.prepare(); err != nil {
+		return err
+	}
+	t.day.sources.Bots[name]++
+	t.dirty = true
+	return nil
+}
+
+// sortedBots lists bot counts, most requests first.
+func sortedBots(counts map[string]int) []botStats {
+	bots := []botStats{}
+	for name, n := range counts {
+		bots = append(bots, botStats{Name: name, Requests: n})
+	}
+	slices.SortFunc(bots, func(a, b botStats) int {
+		if a.Requests != b.Requests {
+			return b.Requests - a.Requests
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return bots
+}
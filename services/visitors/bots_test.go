@@ -0,0 +1,179 @@
+package visitors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBotClassifierRealUserAgents(t *testing.T) {
+	bots, err := newBotClassifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	browsers := []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+		firefoxUA,
+		safariUA,
+		"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Linux; Android 10; CUBOT_X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.6312.99 Mobile Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 12; CUBOT KINGKONG 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [FBAN/FBIOS;FBAV/459.0.0.44.107;FBBV/586125722;FBDV/iPhone15,2;FBMD/iPhone;FBSN/iOS;FBSV/17.4;FBSS/3;FBCR/;FBID/phone;FBLC/en_US;FBOP/80]",
+		"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Mobile Safari/537.36 [Pinterest/Android]",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 OPR/110.0.0.0",
+	}
+	for _, ua := range browsers {
+		if name := bots.match(ua); name != "" {
+			t.Errorf("browser classified as %s: %s", name, ua)
+		}
+	}
+
+	cases := []struct{ ua, want string }{
+		{"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.118 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "Googlebot"},
+		{"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", "Bingbot"},
+		{"Mozilla/5.0 (compatible; YandexBot/3.0; +http://yandex.com/bots)", "YandexBot"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Safari/605.1.15 (Applebot/0.1; +http://www.apple.com/go/applebot)", "Applebot"},
+		{"DuckDuckBot/1.1; (+http://duckduckgo.com/duckduckbot.html)", "DuckDuckBot"},
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)", "GPTBot"},
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; ClaudeBot/1.0; +claudebot@anthropic.com)", "ClaudeBot"},
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko); compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)", "PerplexityBot"},
+		{"CCBot/2.0 (https://commoncrawl.org/faq/)", "CCBot"},
+		{"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)", "AhrefsBot"},
+		{"Mozilla/5.0 (compatible; SemrushBot/7~bl; +http://www.semrush.com/bot.html)", "SemrushBot"},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", "Facebook"},
+		{"Twitterbot/1.0", "Twitterbot"},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", "Slack"},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", "Discordbot"},
+		{"TelegramBot (like TwitterBot)", "TelegramBot"},
+		{"WhatsApp/2.23.20.0 A", "WhatsApp"},
+		{"Mastodon/4.2.8 (http.rb/5.1.1; +https://mastodon.social/)", "Mastodon"},
+		{"Mozilla/5.0 (compatible; Bluesky Cardyb/1.1; +mailto:support@bsky.app)", "Bluesky"},
+		{"Feedly/1.0 (+https://feedly.com/poller.html; 12 subscribers; )", "Feedly"},
+		{"Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)", "UptimeRobot"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/124.0.6367.60 Safari/537.36", "Headless Chrome"},
+		{"Mozilla/5.0 (Linux; Android 11; moto g power (2022)) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Mobile Safari/537.36 Chrome-Lighthouse", "Lighthouse"},
+		{"curl/8.5.0", "curl"},
+		{"Wget/1.21.4", "Wget"},
+		{"python-requests/2.31.0", "Python"},
+		{"Go-http-client/1.1", "Go"},
+		{"okhttp/4.12.0", "Java"},
+		{"Mozilla/5.0 zgrab/0.x", "zgrab"},
+		{"Mozilla/5.0 (compatible; CensysInspect/1.1; +https://about.censys.io/)", "Censys"},
+		{"Mozilla/5.0 (compatible; SomeNewCrawler/0.3)", "Other bot"},
+		{"acme-linkchecker-bot/1.0", "Other bot"},
+	}
+	for _, tc := range cases {
+		if got := bots.match(tc.ua); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.ua, got, tc.want)
+		}
+	}
+}
+
+func TestBotClassifierChecksHeaders(t *testing.T) {
+	bots, err := newBotClassifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := bots.classify(browserRequest("/")); name != "" {
+		t.Fatalf("browser classified as %s", name)
+	}
+
+	noUA := browserRequest("/")
+	noUA.Header.Del("User-Agent")
+	if name := bots.classify(noUA); name != botNoUserAgent {
+		t.Fatalf("no user agent: got %q", name)
+	}
+
+	headless := browserRequest("/")
+	headless.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36")
+	headless.Header.Set("Sec-CH-UA", `"Chromium";v="124", "HeadlessChrome";v="124", "Not-A.Brand";v="99"`)
+	if name := bots.classify(headless); name != botHeadless {
+		t.Fatalf("headless client hints: got %q", name)
+	}
+
+	noLanguage := browserRequest("/")
+	noLanguage.Header.Del("Accept-Language")
+	if name := bots.classify(noLanguage); name != botNoAcceptLanguage {
+		t.Fatalf("no Accept-Language: got %q", name)
+	}
+
+	// A named crawler keeps its name whatever else it leaves out.
+	googlebot := httptest.NewRequest(http.MethodGet, "/", nil)
+	googlebot.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	if name := bots.classify(googlebot); name != "Googlebot" {
+		t.Fatalf("googlebot: got %q", name)
+	}
+}
+
+func TestParseBotPatterns(t *testing.T) {
+	bots, err := parseBotPatterns(strings.NewReader(`
+# comment
+!friendlybot
+Friendly: friend
+Other: bot
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ua, want := range map[string]string{
+		"FriendlyBot/1.0": "",
+		"FriendFetcher":   "Friendly",
+		"AnyBot/2":        "Other",
+		firefoxUA:         "",
+	} {
+		if got := bots.match(ua); got != want {
+			t.Fatalf("%s: got %q, want %q", ua, got, want)
+		}
+	}
+
+	for _, bad := range []string{"no colon here", "Name:", ": pattern", "Bad: (", "!("} {
+		if _, err := parseBotPatterns(strings.NewReader(bad)); err == nil {
+			t.Fatalf("%q: want an error", bad)
+		}
+	}
+}
+
+func TestMiddlewareCountsBotsByName(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	patterns := filepath.Join(t.TempDir(), "bots.txt")
+	if err := os.WriteFile(patterns, []byte("Examplebot: examplebot\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(dir, Options{BotPatterns: patterns}).(*tracker)
+	defer tracker.Close()
+	handler := pageSite(tracker)
+
+	for _, ua := range []string{"Examplebot/1.0", "Examplebot/1.0", ""} {
+		req := browserRequest("/")
+		req.Header.Set("User-Agent", ua)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if len(rec.Result().Cookies()) != 0 {
+			t.Fatalf("%q got a visitor cookie", ua)
+		}
+	}
+	view(t, handler, "a", "/")
+
+	_, resp := getHistory(t, tracker, "sources")
+	if resp.TodayUniqueVisitors != 1 {
+		t.Fatalf("bots counted as visitors: %+v", resp)
+	}
+	// The custom list's names, and the header checks, which always apply.
+	want := []botStats{{Name: "Examplebot", Requests: 2}, {Name: botNoUserAgent, Requests: 1}}
+	if !reflect.DeepEqual(resp.Sources.Bots, want) {
+		t.Fatalf("bots: got %+v, want %+v", resp.Sources.Bots, want)
+	}
+}
@@ -0,0 +1,108 @@
+package visitors
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// saltFile holds the current day's cookieless salt, so a restart doesn't
+// count everyone again. It is overwritten when the day changes; old salts
+// are never kept.
+const saltFile = "salt.json"
+
+// Mode selects how a visitor is recognised between requests.
+type Mode string
+
+const (
+	// ModeCookie identifies a visitor by a random ID in a year-long
+	// first-party cookie.
+	ModeCookie Mode = "cookie"
+	// ModeCookieless sets no cookie. A visitor is a hash of a daily salt, the
+	// site host, their IP address and user agent. The salt is random, rotated
+	// every day and the previous one discarded, so the hash can't be reversed
+	// or linked across days: the same reader on two days is two uniques.
+	ModeCookieless Mode = "cookieless"
+)
+
+// Options configure NewTracker. The zero value reads the environment.
+type Options struct {
+	// Mode defaults to VISITOR_TRACKING_MODE, then ModeCookie.
+	Mode Mode
+	// TrustProxy takes a cookieless visitor's IP from the last
+	// X-Forwarded-For entry, the one added by a reverse proxy in front of the
+	// server. Also enabled by VISITOR_TRUST_PROXY=true. Without it the
+	// connection's address is used.
+	TrustProxy bool
+	// BotPatterns is a file of user agent patterns, in the format of the
+	// built-in bots.txt, that replaces the built-in list. Defaults to
+	// VISITOR_BOT_PATTERNS.
+	BotPatterns string
+}
+
+// dailySalt is the salt for one day in cookieless mode.
+type dailySalt struct {
+	Date string `json:"date"`
+	Salt string `json:"salt"`
+}
+
+// cookielessID derives the visitor's ID for today from the daily salt.
+func (t *tracker) cookielessID(r *http.Request) (string, error) {
+	salt, err := t.currentSalt()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.New()
+	for _, part := range []string{salt, siteHost(r), clientIP(r, t.trustProxy), r.UserAgent()} {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// currentSalt returns today's salt, replacing an older day's.
+func (t *tracker) currentSalt() (string, error) {
+	t.saltMu.Lock()
+	defer t.saltMu.Unlock()
+
+	today := currentDay()
+	if t.salt.Date == "" {
+		if err := t.readJSON(saltFile, &t.salt); err != nil {
+			return "", err
+		}
+	}
+	if t.salt.Date == today && t.salt.Salt != "" {
+		return t.salt.Salt, nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate visitor salt: %w", err)
+	}
+	salt := dailySalt{Date: today, Salt: hex.EncodeToString(buf)}
+	// writeJSON's temp files are created 0600.
+	if err := t.writeJSON(saltFile, salt); err != nil {
+		return "", err
+	}
+	t.salt = salt
+	return salt.Salt, nil
+}
+
+// clientIP is the request's remote address or, behind a trusted proxy, the
+// address it forwarded.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
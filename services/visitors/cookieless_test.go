@@ -0,0 +1,159 @@
+package visitors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+	safariUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+)
+
+// cookielessVisit requests / from ip with userAgent and returns the response.
+func cookielessVisit(t *testing.T, handler http.Handler, ip, userAgent string, header http.Header) *http.Response {
+	t.Helper()
+	req := browserRequest("/")
+	req.RemoteAddr = ip + ":51234"
+	req.Header.Set("User-Agent", userAgent)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func todayVisitors(t *testing.T, tracker *tracker) int {
+	t.Helper()
+	resp, err := tracker.readStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.TodayUniqueVisitors
+}
+
+func TestCookielessModeCountsWithoutCookies(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	first := NewTracker(dir, Options{Mode: ModeCookieless}).(*tracker)
+	handler := pageSite(first)
+
+	for _, visit := range []struct{ ip, ua string }{
+		{"203.0.113.7", firefoxUA},
+		{"203.0.113.7", firefoxUA}, // same reader again
+		{"203.0.113.7", safariUA},  // another device on the same network
+		{"198.51.100.2", firefoxUA},
+	} {
+		res := cookielessVisit(t, handler, visit.ip, visit.ua, nil)
+		if len(res.Cookies()) != 0 || res.Header.Get("Set-Cookie") != "" {
+			t.Fatalf("cookieless mode set a cookie: %v", res.Cookies())
+		}
+	}
+	if got := todayVisitors(t, first); got != 3 {
+		t.Fatalf("want 3 uniques, got %d", got)
+	}
+
+	// A restart the same day keeps the salt, so the reader isn't new.
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewTracker(dir, Options{Mode: ModeCookieless}).(*tracker)
+	defer restarted.Close()
+	cookielessVisit(t, pageSite(restarted), "203.0.113.7", firefoxUA, nil)
+	if got := todayVisitors(t, restarted); got != 3 {
+		t.Fatalf("after restart: want 3 uniques, got %d", got)
+	}
+}
+
+func TestCookielessSaltRotatesDaily(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{Mode: ModeCookieless}).(*tracker)
+	defer tracker.Close()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set("User-Agent", firefoxUA)
+
+	first, err := tracker.cookielessID(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSalt := tracker.salt.Salt
+	setCurrentTime(t, time.Date(2026, 4, 25, 10, 0, 0, 0, time.Local))
+	second, err := tracker.cookielessID(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("the same reader must hash differently on another day")
+	}
+
+	var stored dailySalt
+	readJSONFile(t, filepath.Join(dir, saltFile), &stored)
+	if stored.Date != "2026-04-25" || stored.Salt == oldSalt {
+		t.Fatalf("yesterday's salt should be discarded: %+v", stored)
+	}
+	info, err := os.Stat(filepath.Join(dir, saltFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Fatalf("salt file readable by others: %v", perm)
+	}
+}
+
+func TestCookielessModeTrustsProxyOnlyWhenConfigured(t *testing.T) {
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	// Behind a reverse proxy every request comes from the proxy's address.
+	forwarded := func(ip string) http.Header {
+		return http.Header{"X-Forwarded-For": {"10.9.9.9, " + ip}}
+	}
+
+	direct := NewTracker(t.TempDir(), Options{Mode: ModeCookieless}).(*tracker)
+	defer direct.Close()
+	cookielessVisit(t, pageSite(direct), "127.0.0.1", firefoxUA, forwarded("203.0.113.7"))
+	cookielessVisit(t, pageSite(direct), "127.0.0.1", firefoxUA, forwarded("198.51.100.2"))
+	if got := todayVisitors(t, direct); got != 1 {
+		t.Fatalf("X-Forwarded-For must be ignored by default: %d uniques", got)
+	}
+
+	proxied := NewTracker(t.TempDir(), Options{Mode: ModeCookieless, TrustProxy: true}).(*tracker)
+	defer proxied.Close()
+	cookielessVisit(t, pageSite(proxied), "127.0.0.1", firefoxUA, forwarded("203.0.113.7"))
+	cookielessVisit(t, pageSite(proxied), "127.0.0.1", firefoxUA, forwarded("198.51.100.2"))
+	if got := todayVisitors(t, proxied); got != 2 {
+		t.Fatalf("want 2 uniques behind the proxy, got %d", got)
+	}
+}
+
+func TestNewTrackerMode(t *testing.T) {
+	cases := []struct {
+		opts Options
+		env  string
+		want Mode
+	}{
+		{Options{}, "", ModeCookie},
+		{Options{}, "cookieless", ModeCookieless},
+		{Options{Mode: ModeCookie}, "cookieless", ModeCookie},
+		{Options{}, "cookiless", ModeCookieless}, // typo: never fall back to cookies
+	}
+	for _, tc := range cases {
+		t.Setenv("VISITOR_TRACKING_MODE", tc.env)
+		tracker := NewTracker(t.TempDir(), tc.opts).(*tracker)
+		tracker.Close()
+		if tracker.mode != tc.want {
+			t.Fatalf("%+v with env %q: got %s, want %s", tc.opts, tc.env, tracker.mode, tc.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package visitors
+
+import (
+	"fmt"
+	log "htmx-blog/logging"
+	"htmx-blog/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	dashboardTopPages     = 10
+	dashboardTopReferrers = 10
+	dashboardTopCampaigns = 10
+	dashboardTopBots      = 10
+
+	chartWidth  = 720
+	chartHeight = 220
+	// chartAxisHeight is the strip under the bars for date labels.
+	chartAxisHeight = 24
+)
+
+// dashboardData is what the dashboard templates render.
+type dashboardData struct {
+	Stats     *statsResponse
+	History   *historyResponse
+	Chart     visitorChart
+	TopPages  []PageStats
+	Sources   *sourcesResponse
+	Records   allTimeRecords
+	Intervals []string
+}
+
+// allTimeRecords are the best periods across the whole history. Visitors in
+// a week or month are summed daily uniques, as in the history.
+type allTimeRecords struct {
+	BestDay   historyPoint
+	BestWeek  historyPoint
+	BestMonth historyPoint
+	Total     int
+	Days      int
+}
+
+// visitorChart is a bar chart of history points, laid out in SVG user units
+// so the template only has to draw it.
+type visitorChart struct {
+	Width, Height int
+	// Baseline is the y of the bars' bottom edge, LabelY that of the
+	// date labels under it.
+	Baseline float64
+	LabelY   float64
+	Max      int
+	Bars     []chartBar
+	Ticks    []chartTick
+}
+
+type chartBar struct {
+	X, Y, Width, Height float64
+	Point               historyPoint
+}
+
+type chartTick struct {
+	X     float64
+	Label string
+}
+
+// DashboardHandler serves an HTML dashboard of the visitor stats: a daily
+// (or weekly, monthly) visitors chart, top pages, top referrers and
+// campaigns, device classes, bots and all-time records. It takes the same
+// from, to and interval parameters as StatsHandler, defaulting to the last 30
+// days by day. htmx requests get just the dashboard body, for the range form.
+func (t *tracker) DashboardHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		today, _ := time.ParseInLocation(dateLayout, currentDay(), time.Local)
+		query, ranged, err := parseHistoryQuery(r.URL.Query(), today)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !ranged {
+			query, _, _ = parseHistoryQuery(url.Values{"interval": {intervalDaily}}, today)
+		}
+
+		data, err := t.dashboard(query)
+		if err != nil {
+			log.Error("error reading visitor dashboard: %v", err)
+			http.Error(w, "error reading visitor stats", http.StatusInternalServerError)
+			return
+		}
+
+		if strings.EqualFold(r.Header.Get("HX-Request"), "true") {
+			if err := utils.RenderPartial(w, "visitorDashboard", data, "./templates/partials/visitor-dashboard.html"); err != nil {
+				log.Error("error rendering visitor dashboard: %v", err)
+			}
+			return
+		}
+		utils.Render(w, map[string]interface{}{
+			"MetaTitle": "Visitor stats",
+			"Dashboard": data,
+		}, "./templates/pages/visitor-dashboard.html", "./templates/partials/visitor-dashboard.html")
+	}
+}
+
+// dashboard gathers everything the dashboard shows for query's range.
+func (t *tracker) dashboard(query historyQuery) (*dashboardData, error) {
+	stats, err := t.readStats()
+	if err != nil {
+		return nil, err
+	}
+	daily, err := t.dailyCounts()
+	if err != nil {
+		return nil, err
+	}
+	from, to := query.from.Format(dateLayout), query.to.Format(dateLayout)
+	pages, err := t.pageCounts(from, to)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := t.sourcesBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	sources.Referrers = sources.Referrers[:min(len(sources.Referrers), dashboardTopReferrers)]
+	sources.Campaigns = sources.Campaigns[:min(len(sources.Campaigns), dashboardTopCampaigns)]
+	sources.Bots = sources.Bots[:min(len(sources.Bots), dashboardTopBots)]
+
+	history := query.aggregate(daily)
+	return &dashboardData{
+		Stats:     stats,
+		History:   history,
+		Chart:     newVisitorChart(history.Points),
+		TopPages:  topPages(pages, "", dashboardTopPages),
+		Sources:   sources,
+		Records:   newAllTimeRecords(daily),
+		Intervals: []string{intervalDaily, intervalWeekly, intervalMonthly},
+	}, nil
+}
+
+// newVisitorChart lays out one bar per point, scaled to the busiest one,
+// with date labels under the first, middle and last bars.
+func newVisitorChart(points []historyPoint) visitorChart {
+	chart := visitorChart{
+		Width:    chartWidth,
+		Height:   chartHeight,
+		Baseline: chartHeight - chartAxisHeight,
+		LabelY:   chartHeight - 6,
+	}
+	if len(points) == 0 {
+		return chart
+	}
+	for _, p := range points {
+		chart.Max = max(chart.Max, p.Visitors)
+	}
+
+	slot := float64(chartWidth) / float64(len(points))
+	gap := min(slot*0.2, 4)
+	for i, p := range points {
+		height := 0.0
+		if chart.Max > 0 {
+			height = chart.Baseline * float64(p.Visitors) / float64(chart.Max)
+		}
+		chart.Bars = append(chart.Bars, chartBar{
+			X:      float64(i)*slot + gap/2,
+			Y:      chart.Baseline - height,
+			Width:  slot - gap,
+			Height: height,
+			Point:  p,
+		})
+	}
+
+	for _, i := range []int{0, len(points) / 2, len(points) - 1} {
+		if n := len(chart.Ticks); n > 0 && chart.Ticks[n-1].Label == points[i].Start {
+			continue
+		}
+		chart.Ticks = append(chart.Ticks, chartTick{X: float64(i)*slot + slot/2, Label: points[i].Start})
+	}
+	return chart
+}
+
+// newAllTimeRecords finds the best day, week and month in daily counts.
+func newAllTimeRecords(daily map[string]int) allTimeRecords {
+	var records allTimeRecords
+	weeks := make(map[string]*historyPoint)
+	months := make(map[string]*historyPoint)
+	for date, count := range daily {
+		day, err := time.ParseInLocation(dateLayout, date, time.Local)
+		if err != nil || count == 0 {
+			continue
+		}
+		records.Total += count
+		records.Days++
+		records.BestDay = better(records.BestDay, historyPoint{Start: date, End: date, Visitors: count})
+		addToBucket(weeks, day, intervalWeekly, count)
+		addToBucket(months, day, intervalMonthly, count)
+	}
+	for _, week := range weeks {
+		records.BestWeek = better(records.BestWeek, *week)
+	}
+	for _, month := range months {
+		records.BestMonth = better(records.BestMonth, *month)
+	}
+	return records
+}
+
+// addToBucket adds count to the interval bucket containing day.
+func addToBucket(buckets map[string]*historyPoint, day time.Time, interval string, count int) {
+	start := bucketStart(day, interval)
+	key := start.Format(dateLayout)
+	bucket, ok := buckets[key]
+	if !ok {
+		var end time.Time
+		if interval == intervalWeekly {
+			end = start.AddDate(0, 0, 6)
+		} else {
+			end = start.AddDate(0, 1, -1)
+		}
+		bucket = &historyPoint{Start: key, End: end.Format(dateLayout)}
+		buckets[key] = bucket
+	}
+	bucket.Visitors += count
+}
+
+// better returns the point with more visitors, the earlier one on a tie.
+func better(a, b historyPoint) historyPoint {
+	if b.Visitors > a.Visitors || (b.Visitors == a.Visitors && b.Visitors > 0 && b.Start < a.Start) {
+		return b
+	}
+	return a
+}
+
+// String labels a point for tooltips: its date, or its range.
+func (p historyPoint) String() string {
+	label := p.Start
+	if p.End != p.Start {
+		label = fmt.Sprintf("%s – %s", p.Start, p.End)
+	}
+	noun := "visitors"
+	if p.Visitors == 1 {
+		noun = "visitor"
+	}
+	return fmt.Sprintf("%s: %d %s", label, p.Visitors, noun)
+}
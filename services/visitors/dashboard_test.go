@@ -0,0 +1,78 @@
+package visitors
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewVisitorChartScalesToBusiestPoint(t *testing.T) {
+	chart := newVisitorChart([]historyPoint{
+		{Start: "2026-04-01", End: "2026-04-01", Visitors: 2},
+		{Start: "2026-04-02", End: "2026-04-02", Visitors: 0},
+		{Start: "2026-04-03", End: "2026-04-03", Visitors: 4},
+	})
+	if chart.Max != 4 || len(chart.Bars) != 3 {
+		t.Fatalf("chart: %+v", chart)
+	}
+	busiest, empty := chart.Bars[2], chart.Bars[1]
+	if busiest.Y != 0 || busiest.Height != chart.Baseline {
+		t.Fatalf("busiest bar should fill the plot: %+v", busiest)
+	}
+	if empty.Height != 0 || empty.Y != chart.Baseline {
+		t.Fatalf("empty bar should sit on the baseline: %+v", empty)
+	}
+	if half := chart.Bars[0]; half.Height != chart.Baseline/2 {
+		t.Fatalf("half bar: %+v", half)
+	}
+	for i, bar := range chart.Bars {
+		if bar.X < 0 || bar.X+bar.Width > float64(chart.Width) {
+			t.Fatalf("bar %d outside the chart: %+v", i, bar)
+		}
+	}
+	var labels []string
+	for _, tick := range chart.Ticks {
+		labels = append(labels, tick.Label)
+	}
+	if want := []string{"2026-04-01", "2026-04-02", "2026-04-03"}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("ticks: got %v, want %v", labels, want)
+	}
+}
+
+func TestNewVisitorChartWithoutVisitors(t *testing.T) {
+	chart := newVisitorChart([]historyPoint{{Start: "2026-04-01", End: "2026-04-01"}})
+	if chart.Max != 0 || len(chart.Bars) != 1 || chart.Bars[0].Height != 0 || len(chart.Ticks) != 1 {
+		t.Fatalf("chart: %+v", chart)
+	}
+	if chart := newVisitorChart(nil); len(chart.Bars) != 0 || len(chart.Ticks) != 0 {
+		t.Fatalf("empty chart: %+v", chart)
+	}
+}
+
+func TestNewAllTimeRecords(t *testing.T) {
+	records := newAllTimeRecords(map[string]int{
+		"2026-03-30": 5, // Monday
+		"2026-03-31": 5,
+		"2026-04-01": 7,
+		"2026-04-06": 8, // next Monday
+		"2026-04-07": 0,
+	})
+	want := allTimeRecords{
+		BestDay:   historyPoint{Start: "2026-04-06", End: "2026-04-06", Visitors: 8},
+		BestWeek:  historyPoint{Start: "2026-03-30", End: "2026-04-05", Visitors: 17},
+		BestMonth: historyPoint{Start: "2026-04-01", End: "2026-04-30", Visitors: 15},
+		Total:     25,
+		Days:      4,
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("records: got %+v, want %+v", records, want)
+	}
+}
+
+func TestHistoryPointString(t *testing.T) {
+	if got := (historyPoint{Start: "2026-04-01", End: "2026-04-01", Visitors: 1}).String(); got != "2026-04-01: 1 visitor" {
+		t.Fatalf("day: %q", got)
+	}
+	if got := (historyPoint{Start: "2026-03-30", End: "2026-04-05", Visitors: 3}).String(); got != "2026-03-30 – 2026-04-05: 3 visitors" {
+		t.Fatalf("week: %q", got)
+	}
+}
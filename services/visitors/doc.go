@@ -1,3 +1,11 @@
 // Package visitors tracks site-wide unique visitors with a first-party cookie
-// and a small file-backed daily store.
+// and a small file-backed daily store, plus per-page views and uniques and
+// aggregate traffic sources (referrer host, UTM campaign, device class).
+//
+// The current day is held in memory, so recording a visit is a few map
+// operations whatever the day's traffic. It is flushed to snapshot files
+// periodically and on Close; finished days are appended to history files,
+// which the stats handler aggregates for ?from=&to=&interval=, ?top= and
+// ?sources queries. The dashboard handler renders the same data as an HTML
+// page with server-side SVG charts.
 package visitors
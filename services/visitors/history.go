@@ -0,0 +1,231 @@
+package visitors
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	historyStatsFile = "history.jsonl"
+	// defaultHistoryDays is the range served when only interval is given.
+	defaultHistoryDays = 30
+	// maxHistoryDays bounds a single query.
+	maxHistoryDays = 3 * 366
+)
+
+// Aggregation intervals for ranged stats.
+const (
+	intervalDaily   = "daily"
+	intervalWeekly  = "weekly"
+	intervalMonthly = "monthly"
+)
+
+// dayStats is one finished day in the history store, one JSON object per
+// line. The file is only ever appended to; if a day appears twice (a crash
+// between appending and resetting current.json) the last line wins.
+type dayStats struct {
+	Date        string `json:"date"`
+	UniqueCount int    `json:"unique_count"`
+}
+
+type historyPoint struct {
+	// Start and End are the first and last day of the bucket within the
+	// requested range.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Visitors is the sum of daily unique visitors over the bucket, so a
+	// visitor seen on three days of a week counts three times.
+	Visitors int `json:"visitors"`
+}
+
+type historyResponse struct {
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Interval string         `json:"interval"`
+	Total    int            `json:"total"`
+	Points   []historyPoint `json:"points"`
+}
+
+// historyQuery is a validated ?from=&to=&interval= request.
+type historyQuery struct {
+	from, to time.Time
+	interval string
+}
+
+// parseHistoryQuery reads from, to (YYYY-MM-DD, inclusive) and interval
+// (daily, weekly, monthly). ok is false when none of them is set, so the
+// plain stats response is served.
+func parseHistoryQuery(values url.Values, today time.Time) (q historyQuery, ok bool, err error) {
+	from, to, interval := values.Get("from"), values.Get("to"), values.Get("interval")
+	if from == "" && to == "" && interval == "" {
+		return q, false, nil
+	}
+
+	q.interval = intervalDaily
+	if interval != "" {
+		q.interval = interval
+	}
+	switch q.interval {
+	case intervalDaily, intervalWeekly, intervalMonthly:
+	default:
+		return q, true, fmt.Errorf("interval must be daily, weekly or monthly")
+	}
+
+	q.to = today
+	if to != "" {
+		if q.to, err = time.ParseInLocation(dateLayout, to, time.Local); err != nil {
+			return q, true, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+	}
+	q.from = q.to.AddDate(0, 0, -(defaultHistoryDays - 1))
+	if from != "" {
+		if q.from, err = time.ParseInLocation(dateLayout, from, time.Local); err != nil {
+			return q, true, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+	}
+	if q.from.After(q.to) {
+		return q, true, errors.New("from must not be after to")
+	}
+	if daysBetween(q.from, q.to) >= maxHistoryDays {
+		return q, true, fmt.Errorf("range is limited to %d days", maxHistoryDays)
+	}
+	return q, true, nil
+}
+
+// aggregate buckets daily counts (by date string) over q's range. Days
+// without an entry count as zero.
+func (q historyQuery) aggregate(daily map[string]int) *historyResponse {
+	resp := &historyResponse{
+		From:     q.from.Format(dateLayout),
+		To:       q.to.Format(dateLayout),
+		Interval: q.interval,
+		Points:   []historyPoint{},
+	}
+	for day := q.from; !day.After(q.to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateLayout)
+		count := daily[date]
+		resp.Total += count
+
+		start := bucketStart(day, q.interval)
+		if start.Before(q.from) {
+			start = q.from
+		}
+		startDate := start.Format(dateLayout)
+		if n := len(resp.Points); n > 0 && resp.Points[n-1].Start == startDate {
+			resp.Points[n-1].End = date
+			resp.Points[n-1].Visitors += count
+			continue
+		}
+		resp.Points = append(resp.Points, historyPoint{Start: startDate, End: date, Visitors: count})
+	}
+	return resp
+}
+
+// bucketStart is the first day of the interval containing day: the day
+// itself, its ISO week's Monday, or the first of its month.
+func bucketStart(day time.Time, interval string) time.Time {
+	switch interval {
+	case intervalWeekly:
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		return day.AddDate(0, 0, -offset)
+	case intervalMonthly:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+	}
+	return day
+}
+
+func daysBetween(from, to time.Time) int {
+	// Dates are local midnights; rounding absorbs DST shifts.
+	return int(to.Sub(from).Round(24*time.Hour) / (24 * time.Hour))
+}
+
+// appendHistory appends a finished day to the history store. t.mu must be
+// held.
+func (t *tracker) appendHistory(day dayStats) error {
+	t.historyGen++
+	return t.appendLine(historyStatsFile, day)
+}
+
+// appendLine appends value as one JSON line to the named file and syncs it,
+// so a finished day survives a crash right after rolling over.
+func (t *tracker) appendLine(name string, value any) error {
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return fmt.Errorf("create visitor stats dir: %w", err)
+	}
+	line, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshal %s entry: %w", name, err)
+	}
+	path := filepath.Join(t.dir, name)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open history file %s: %w", path, err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("append history file %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync history file %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// visitorHistory is the visitor history as read from disk, kept until it is
+// next appended to.
+type visitorHistory struct {
+	gen  int
+	days map[string]int
+}
+
+// cachedHistory returns readHistory(), reusing the last read while the
+// history is still at generation gen. The returned map must not be
+// modified.
+func (t *tracker) cachedHistory(gen int) (map[string]int, error) {
+	t.historyMu.Lock()
+	defer t.historyMu.Unlock()
+	if c := t.history; c != nil && c.gen == gen {
+		return c.days, nil
+	}
+	days, err := t.readHistory()
+	if err != nil {
+		return nil, err
+	}
+	t.history = &visitorHistory{gen: gen, days: days}
+	return days, nil
+}
+
+// readHistory returns every day in the history store by date. A torn last
+// line (crash mid-append) is skipped.
+func (t *tracker) readHistory() (map[string]int, error) {
+	path := filepath.Join(t.dir, historyStatsFile)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]int{}, nil
+		}
+		return nil, fmt.Errorf("read history file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	days := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var day dayStats
+		if err := json.Unmarshal(scanner.Bytes(), &day); err != nil || day.Date == "" {
+			continue
+		}
+		days[day.Date] = day.UniqueCount
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history file %s: %w", path, err)
+	}
+	return days, nil
+}
@@ -0,0 +1,162 @@
+package visitors
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func visit(t *testing.T, handler http.Handler, visitorID string) {
+	t.Helper()
+	req := browserRequest("/")
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: visitorID})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestMiddlewareRollsFinishedDaysIntoHistory(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	visit(t, handler, "a")
+	visit(t, handler, "b")
+	setCurrentTime(t, time.Date(2026, 4, 25, 9, 0, 0, 0, time.Local))
+	visit(t, handler, "a")
+	// Nobody on the 26th.
+	setCurrentTime(t, time.Date(2026, 4, 27, 9, 0, 0, 0, time.Local))
+	visit(t, handler, "c")
+
+	history, err := tracker.readHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"2026-04-24": 2, "2026-04-25": 1}; !reflect.DeepEqual(history, want) {
+		t.Fatalf("history: got %v, want %v", history, want)
+	}
+}
+
+func TestReadHistoryLastLineWinsAndSkipsTornLine(t *testing.T) {
+	dir := t.TempDir()
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	data := `{"date":"2026-04-20","unique_count":3}
+{"date":"2026-04-21","unique_count":5}
+{"date":"2026-04-21","unique_count":6}
+{"date":"2026-04-22","uniq`
+	if err := os.WriteFile(filepath.Join(dir, historyStatsFile), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := tracker.readHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"2026-04-20": 3, "2026-04-21": 6}; !reflect.DeepEqual(history, want) {
+		t.Fatalf("history: got %v, want %v", history, want)
+	}
+}
+
+func historyFixture(t *testing.T) *tracker {
+	t.Helper()
+	dir := t.TempDir()
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	for _, day := range []dayStats{
+		{"2026-03-30", 1}, // Monday
+		{"2026-03-31", 2},
+		{"2026-04-01", 4},
+		{"2026-04-06", 8}, // next Monday
+	} {
+		if err := tracker.appendHistory(day); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2026-04-07 not rolled yet: nobody visited since.
+	writeJSONFile(t, dir, currentStatsFile, currentStats{Date: "2026-04-07", VisitorHashes: []string{"x", "y", "z"}})
+	return tracker
+}
+
+func getHistory(t *testing.T, tracker *tracker, query string) (int, statsResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	tracker.StatsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/visitors?"+query, nil))
+	var resp statsResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestStatsHandlerServesDailyRange(t *testing.T) {
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 8, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+	tracker := historyFixture(t)
+
+	code, resp := getHistory(t, tracker, "from=2026-03-31&to=2026-04-02")
+	if code != http.StatusOK || resp.History == nil {
+		t.Fatalf("status %d, %+v", code, resp)
+	}
+	want := []historyPoint{
+		{Start: "2026-03-31", End: "2026-03-31", Visitors: 2},
+		{Start: "2026-04-01", End: "2026-04-01", Visitors: 4},
+		{Start: "2026-04-02", End: "2026-04-02", Visitors: 0},
+	}
+	if !reflect.DeepEqual(resp.History.Points, want) || resp.History.Total != 6 || resp.History.Interval != intervalDaily {
+		t.Fatalf("history: %+v", resp.History)
+	}
+	// Today's fields are unchanged.
+	if resp.TodayDate != "2026-04-08" || resp.TodayUniqueVisitors != 0 {
+		t.Fatalf("today: %+v", resp)
+	}
+}
+
+func TestStatsHandlerServesWeeklyAndMonthlyAggregates(t *testing.T) {
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 8, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+	tracker := historyFixture(t)
+
+	_, resp := getHistory(t, tracker, "from=2026-03-31&to=2026-04-08&interval=weekly")
+	want := []historyPoint{
+		// Clipped to the range: the week starts on Monday the 30th.
+		{Start: "2026-03-31", End: "2026-04-05", Visitors: 6},
+		// Includes the unrolled day in current.json.
+		{Start: "2026-04-06", End: "2026-04-08", Visitors: 11},
+	}
+	if !reflect.DeepEqual(resp.History.Points, want) {
+		t.Fatalf("weekly: %+v", resp.History.Points)
+	}
+
+	_, resp = getHistory(t, tracker, "interval=monthly")
+	want = []historyPoint{
+		{Start: "2026-03-10", End: "2026-03-31", Visitors: 3},
+		{Start: "2026-04-01", End: "2026-04-08", Visitors: 15},
+	}
+	if resp.History.From != "2026-03-10" || resp.History.To != "2026-04-08" || !reflect.DeepEqual(resp.History.Points, want) {
+		t.Fatalf("monthly default range: %+v", resp.History)
+	}
+}
+
+func TestStatsHandlerRejectsBadRanges(t *testing.T) {
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 8, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+	tracker := historyFixture(t)
+
+	for _, query := range []string{
+		"from=2026-04-08&to=2026-04-01",
+		"from=08-04-2026",
+		"interval=hourly",
+		"from=2020-01-01&to=2026-01-01",
+	} {
+		if code, _ := getHistory(t, tracker, query); code != http.StatusBadRequest {
+			t.Fatalf("%s: want 400, got %d", query, code)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package visitors
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pagesStatsFile   = "pages.json"
+	pageHistoryFile  = "pages_history.jsonl"
+	defaultTopPages  = 10
+	maxTopPages      = 100
+	postContentRoute = "/notion/content/"
+	// maxPagesPerDay bounds the distinct paths counted in a day; views of
+	// further paths are counted under otherPagesPath.
+	maxPagesPerDay = 2000
+	otherPagesPath = "(other)"
+)
+
+// PostPathPrefix is the path prefix post page counts are kept under.
+const PostPathPrefix = "/notion/posts/"
+
+// PageStats is one page's counts over a period. Visitors is the sum of daily
+// unique visitors, like the site-wide history.
+type PageStats struct {
+	Path     string `json:"path"`
+	Views    int    `json:"views"`
+	Visitors int    `json:"visitors"`
+}
+
+// PageCounter reads per-page counts, e.g. for a popular posts partial.
+type PageCounter interface {
+	// TopPages returns up to limit pages whose path starts with prefix, most
+	// viewed first, over the last days days including today.
+	TopPages(prefix string, days, limit int) ([]PageStats, error)
+}
+
+// pageCounter is one page's counts for the current day.
+type pageCounter struct {
+	Views         int      `json:"views"`
+	VisitorHashes []string `json:"visitor_hashes"`
+}
+
+// currentPages is the current day's per-page counts.
+type currentPages struct {
+	Date  string                  `json:"date"`
+	Pages map[string]*pageCounter `json:"pages"`
+}
+
+// pageDayCount is a finished day's counts for one page in the history.
+type pageDayCount struct {
+	Views    int `json:"views"`
+	Visitors int `json:"visitors"`
+}
+
+// pageDay is one line of the page history: every page counted on a
+// finished day. As with history.jsonl, the last line for a date wins.
+type pageDay struct {
+	Date  string                  `json:"date"`
+	Pages map[string]pageDayCount `json:"pages"`
+}
+
+// PostPath is the path a post's counts are kept under.
+func PostPath(slug string) string {
+	return PostPathPrefix + slug
+}
+
+// pagePath normalises a request path for per-page counts: it is cleaned,
+// trailing slashes are dropped and a post's content fragment counts towards
+// the post page. Query strings (?type=, ?toc=) are never part of the path.
+func pagePath(p string) string {
+	p = path.Clean("/" + p)
+	if slug, ok := strings.CutPrefix(p, postContentRoute); ok && slug != "" {
+		return PostPath(slug)
+	}
+	return p
+}
+
+// trackPageView counts a view of p by the visitor with the given hash.
+func (t *tracker) trackPageView(p, hash string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.prepare(); err != nil {
+		return err
+	}
+	pages := t.day.pages
+	page, ok := pages[p]
+	if !ok {
+		if len(pages) >= maxPagesPerDay {
+			p = otherPagesPath
+		}
+		if page, ok = pages[p]; !ok {
+			page = &pageState{visitors: map[string]struct{}{}}
+			pages[p] = page
+		}
+	}
+	page.views++
+	page.visitors[hash] = struct{}{}
+	t.dirty = true
+	return nil
+}
+
+// finished converts the day's counters into a history entry.
+func (c *currentPages) finished() pageDay {
+	day := pageDay{Date: c.Date, Pages: make(map[string]pageDayCount, len(c.Pages))}
+	for p, counter := range c.Pages {
+		day.Pages[p] = pageDayCount{Views: counter.Views, Visitors: len(counter.VisitorHashes)}
+	}
+	return day
+}
+
+// TopPages implements PageCounter.
+func (t *tracker) TopPages(prefix string, days, limit int) ([]PageStats, error) {
+	if days < 1 {
+		days = 1
+	}
+	to, _ := time.ParseInLocation(dateLayout, currentDay(), time.Local)
+	from := to.AddDate(0, 0, -(days - 1))
+	counts, err := t.pageCounts(from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	return topPages(counts, prefix, limit), nil
+}
+
+// pageHistoryRange is a page history range as read from disk, kept until
+// the history is next appended to.
+type pageHistoryRange struct {
+	from, to string
+	gen      int
+	days     map[string]pageDay
+}
+
+// pageCounts sums per-page counts over the dates from to to, inclusive. Only
+// the in-memory day is read under t.mu; finished days come from the page
+// history, which is read from disk once per range and rollover rather than
+// on every call, so the popular posts fragment never holds up tracking.
+func (t *tracker) pageCounts(from, to string) (map[string]*PageStats, error) {
+	t.mu.Lock()
+	if err := t.load(); err != nil {
+		t.mu.Unlock()
+		return nil, err
+	}
+	gen := t.pageHistoryGen
+	// The in-memory day is today or, if it hasn't been rolled over yet, the
+	// last day with visitors.
+	var memory *pageDay
+	if date := t.day.date; date >= from && date <= to && len(t.day.pages) > 0 {
+		day := t.day.currentPages().finished()
+		memory = &day
+	}
+	t.mu.Unlock()
+
+	history, err := t.cachedPageHistory(from, to, gen)
+	if err != nil {
+		return nil, err
+	}
+	days := maps.Clone(history)
+	if memory != nil {
+		days[memory.Date] = *memory
+	}
+
+	counts := make(map[string]*PageStats)
+	for _, day := range days {
+		for p, c := range day.Pages {
+			stats, ok := counts[p]
+			if !ok {
+				stats = &PageStats{Path: p}
+				counts[p] = stats
+			}
+			stats.Views += c.Views
+			stats.Visitors += c.Visitors
+		}
+	}
+	return counts, nil
+}
+
+// topPages sorts the pages under prefix by views, then visitors, then path,
+// and returns at most limit of them.
+func topPages(counts map[string]*PageStats, prefix string, limit int) []PageStats {
+	top := []PageStats{}
+	for p, stats := range counts {
+		if strings.HasPrefix(p, prefix) {
+			top = append(top, *stats)
+		}
+	}
+	slices.SortFunc(top, func(a, b PageStats) int {
+		if a.Views != b.Views {
+			return b.Views - a.Views
+		}
+		if a.Visitors != b.Visitors {
+			return b.Visitors - a.Visitors
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+// parseTopPages reads ?top=N (the number of pages; empty for the default)
+// and ?prefix=. ok is false when top is not set.
+func parseTopPages(values url.Values) (limit int, prefix string, ok bool, err error) {
+	if !values.Has("top") {
+		return 0, "", false, nil
+	}
+	limit = defaultTopPages
+	if top := values.Get("top"); top != "" {
+		if limit, err = strconv.Atoi(top); err != nil || limit < 1 || limit > maxTopPages {
+			return 0, "", true, fmt.Errorf("top must be between 1 and %d", maxTopPages)
+		}
+	}
+	return limit, values.Get("prefix"), true, nil
+}
+
+func (t *tracker) readCurrentPages() (*currentPages, error) {
+	var pages currentPages
+	if err := t.readJSON(pagesStatsFile, &pages); err != nil {
+		return nil, err
+	}
+	if pages.Date == "" {
+		pages.Date = currentDay()
+	}
+	if pages.Pages == nil {
+		pages.Pages = map[string]*pageCounter{}
+	}
+	return &pages, nil
+}
+
+// cachedPageHistory returns readPageHistory(from, to), reusing the last
+// read while the history is still at generation gen. The returned map must
+// not be modified.
+func (t *tracker) cachedPageHistory(from, to string, gen int) (map[string]pageDay, error) {
+	t.pageHistoryMu.Lock()
+	defer t.pageHistoryMu.Unlock()
+	if c := t.pageHistory; c != nil && c.from == from && c.to == to && c.gen == gen {
+		return c.days, nil
+	}
+	days, err := t.readPageHistory(from, to)
+	if err != nil {
+		return nil, err
+	}
+	t.pageHistory = &pageHistoryRange{from: from, to: to, gen: gen, days: days}
+	return days, nil
+}
+
+// appendPageHistory appends a finished day to the page history. t.mu must be
+// held.
+func (t *tracker) appendPageHistory(day pageDay) error {
+	t.pageHistoryGen++
+	return t.appendLine(pageHistoryFile, day)
+}
+
+// readPageHistory returns the page history between from and to, inclusive,
+// by date. A torn last line is skipped.
+func (t *tracker) readPageHistory(from, to string) (map[string]pageDay, error) {
+	path := filepath.Join(t.dir, pageHistoryFile)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]pageDay{}, nil
+		}
+		return nil, fmt.Errorf("read page history file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	days := make(map[string]pageDay)
+	scanner := bufio.NewScanner(f)
+	// A busy day's line holds every page counted that day.
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	for scanner.Scan() {
+		var day pageDay
+		if err := json.Unmarshal(scanner.Bytes(), &day); err != nil || day.Date < from || day.Date > to {
+			continue
+		}
+		days[day.Date] = day
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read page history file %s: %w", path, err)
+	}
+	return days, nil
+}
@@ -0,0 +1,214 @@
+package visitors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPagePath(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                          "/",
+		"/":                         "/",
+		"/reviews/":                 "/reviews",
+		"/notion/posts/hello-world": "/notion/posts/hello-world",
+		"/notion/posts/hello//":     "/notion/posts/hello",
+		"/notion/content/hello":     "/notion/posts/hello",
+		"/notion/engineering/../x":  "/notion/x",
+	} {
+		if got := pagePath(in); got != want {
+			t.Fatalf("pagePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// pageSite serves 200 for every path except /missing.
+func pageSite(tracker *tracker) http.Handler {
+	return tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+}
+
+func view(t *testing.T, handler http.Handler, visitorID, target string) {
+	t.Helper()
+	req := browserRequest(target)
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: visitorID})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestMiddlewareCountsPageViews(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	handler := pageSite(tracker)
+
+	view(t, handler, "a", "/notion/posts/hello?type=engineering")
+	view(t, handler, "a", "/notion/posts/hello/")
+	view(t, handler, "b", "/notion/posts/hello?toc=top")
+	view(t, handler, "a", "/")
+	view(t, handler, "a", "/missing")
+	view(t, handler, "a", "/static/app.js")
+
+	top, err := tracker.TopPages("", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PageStats{
+		{Path: "/notion/posts/hello", Views: 3, Visitors: 2},
+		{Path: "/", Views: 1, Visitors: 1},
+	}
+	if !reflect.DeepEqual(top, want) {
+		t.Fatalf("top pages: got %+v, want %+v", top, want)
+	}
+}
+
+func TestTopPagesSpansHistory(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	handler := pageSite(tracker)
+
+	view(t, handler, "a", "/notion/posts/old")
+	view(t, handler, "b", "/notion/posts/old")
+	view(t, handler, "a", "/notion/posts/new")
+	setCurrentTime(t, time.Date(2026, 4, 25, 10, 0, 0, 0, time.Local))
+	view(t, handler, "a", "/notion/posts/new")
+	view(t, handler, "b", "/notion/posts/new")
+	view(t, handler, "c", "/notion/posts/new")
+	view(t, handler, "c", "/reviews")
+
+	if _, err := os.Stat(filepath.Join(dir, pageHistoryFile)); err != nil {
+		t.Fatalf("previous day not rolled into the page history: %v", err)
+	}
+
+	top, err := tracker.TopPages(PostPathPrefix, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PageStats{
+		{Path: "/notion/posts/new", Views: 4, Visitors: 4},
+		{Path: "/notion/posts/old", Views: 2, Visitors: 2},
+	}
+	if !reflect.DeepEqual(top, want) {
+		t.Fatalf("last 7 days: got %+v, want %+v", top, want)
+	}
+
+	top, err = tracker.TopPages(PostPathPrefix, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PageStats{{Path: "/notion/posts/new", Views: 3, Visitors: 3}}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("today: got %+v, want %+v", top, want)
+	}
+}
+
+func TestStatsHandlerServesTopPages(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	handler := pageSite(tracker)
+	view(t, handler, "a", "/notion/posts/hello")
+	view(t, handler, "a", "/reviews")
+	view(t, handler, "b", "/reviews")
+
+	_, resp := getHistory(t, tracker, "top=")
+	want := []PageStats{
+		{Path: "/reviews", Views: 2, Visitors: 2},
+		{Path: "/notion/posts/hello", Views: 1, Visitors: 1},
+	}
+	if !reflect.DeepEqual(resp.TopPages, want) || resp.History != nil {
+		t.Fatalf("top pages: %+v", resp)
+	}
+
+	_, resp = getHistory(t, tracker, "top=5&prefix=/notion/posts/&from=2026-04-20")
+	if !reflect.DeepEqual(resp.TopPages, want[1:]) || resp.History == nil {
+		t.Fatalf("ranged top posts: %+v", resp)
+	}
+
+	_, resp = getHistory(t, tracker, "")
+	if resp.TopPages != nil {
+		t.Fatalf("top pages without ?top=: %+v", resp.TopPages)
+	}
+
+	for _, query := range []string{"top=0", "top=many", "top=1000"} {
+		if code, _ := getHistory(t, tracker, query); code != http.StatusBadRequest {
+			t.Fatalf("%s: want 400, got %d", query, code)
+		}
+	}
+}
+
+func TestTrackPageViewCapsDistinctPaths(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	pages := &currentPages{Date: "2026-04-24", Pages: map[string]*pageCounter{}}
+	for i := range maxPagesPerDay {
+		pages.Pages[fmt.Sprintf("/p%d", i)] = &pageCounter{Views: 1, VisitorHashes: []string{"x"}}
+	}
+	if err := tracker.writeJSON(pagesStatsFile, pages); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tracker.trackPageView("/one-too-many", "x"); err != nil {
+		t.Fatal(err)
+	}
+	top, err := tracker.TopPages(otherPagesPath, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PageStats{{Path: otherPagesPath, Views: 1, Visitors: 1}}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("overflow: got %+v, want %+v", top, want)
+	}
+}
+
+func TestTopPagesReadsHistoryOncePerRollover(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	handler := pageSite(tracker)
+	view(t, handler, "a", "/notion/posts/old")
+	setCurrentTime(t, time.Date(2026, 4, 25, 10, 0, 0, 0, time.Local))
+	view(t, handler, "a", "/notion/posts/new")
+
+	top, err := tracker.TopPages(PostPathPrefix, 30, 0)
+	if err != nil || len(top) != 2 {
+		t.Fatalf("top pages: %+v, %v", top, err)
+	}
+
+	// Until the next rollover the history isn't read again.
+	if err := os.Remove(filepath.Join(dir, pageHistoryFile)); err != nil {
+		t.Fatal(err)
+	}
+	if top, err := tracker.TopPages(PostPathPrefix, 30, 0); err != nil || len(top) != 2 {
+		t.Fatalf("cached top pages: %+v, %v", top, err)
+	}
+
+	setCurrentTime(t, time.Date(2026, 4, 26, 10, 0, 0, 0, time.Local))
+	view(t, handler, "a", "/reviews")
+	top, err = tracker.TopPages(PostPathPrefix, 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PageStats{{Path: "/notion/posts/new", Views: 1, Visitors: 1}}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("after rollover: got %+v, want %+v", top, want)
+	}
+}
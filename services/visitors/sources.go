@@ -0,0 +1,379 @@
+package visitors
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	sourcesStatsFile   = "sources.json"
+	sourcesHistoryFile = "sources_history.jsonl"
+	// maxSourceKeys bounds each day's distinct referrers and campaigns;
+	// further ones are counted under otherSource.
+	maxSourceKeys = 500
+	// maxCampaignValue truncates UTM values, which are free text.
+	maxCampaignValue = 64
+	directSource     = "(direct)"
+	otherSource      = "(other)"
+)
+
+// Device classes, from the user agent.
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceUnknown = "unknown"
+)
+
+// sourceCounts are a day's aggregate traffic sources. Only counters are
+// kept: nothing ties a referrer, campaign or device to a visitor.
+//
+// Referrers and Campaigns count landings: a visitor's first page of the day,
+// or any page reached from another site or a campaign link. Referrers are
+// keyed by host, or (direct) when there's no external referrer; Campaigns by
+// campaignKey. Devices count each day's unique visitors by device class.
+// Bots count requests from crawlers and other clients that aren't counted as
+// visitors, by the name botClassifier gives them.
+type sourceCounts struct {
+	Referrers map[string]int `json:"referrers"`
+	Campaigns map[string]int `json:"campaigns"`
+	Devices   map[string]int `json:"devices"`
+	Bots      map[string]int `json:"bots,omitempty"`
+}
+
+// sourcesDay is the current day's counts in sources.json, and one line of
+// sources_history.jsonl per finished day (the last line for a date wins).
+type sourcesDay struct {
+	Date string `json:"date"`
+	sourceCounts
+}
+
+// landing describes where a tracked request came from.
+type landing struct {
+	// referrer is the external referrer's host, or "" for none.
+	referrer string
+	// campaign is the campaignKey of the request's UTM parameters, or "".
+	campaign string
+}
+
+type referrerStats struct {
+	Host   string `json:"host"`
+	Visits int    `json:"visits"`
+}
+
+type campaignStats struct {
+	Source   string `json:"source"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Visits   int    `json:"visits"`
+}
+
+// sourcesResponse is the ?sources view of the stats endpoint, most visits
+// first.
+type sourcesResponse struct {
+	Referrers []referrerStats `json:"referrers"`
+	Campaigns []campaignStats `json:"campaigns"`
+	Devices   map[string]int  `json:"devices"`
+	Bots      []botStats      `json:"bots"`
+}
+
+// landingFrom reads r's referrer host and UTM parameters. Referrers from the
+// site itself (r.Host) and non-web schemes are ignored; only the host of an
+// external referrer is kept, never its path or query.
+func landingFrom(r *http.Request) landing {
+	var l landing
+	if ref, err := url.Parse(r.Referer()); err == nil && (ref.Scheme == "http" || ref.Scheme == "https") {
+		host := strings.TrimPrefix(strings.ToLower(ref.Hostname()), "www.")
+		if host != "" && host != siteHost(r) {
+			l.referrer = host
+		}
+	}
+	query := r.URL.Query()
+	l.campaign = campaignKey(query.Get("utm_source"), query.Get("utm_medium"), query.Get("utm_campaign"))
+	return l
+}
+
+// siteHost is r.Host without its port or a leading www.
+func siteHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// campaignKey joins cleaned UTM values into one key: "source|medium|campaign".
+// It is "" without a source, which the other two are meaningless without.
+func campaignKey(source, medium, campaign string) string {
+	source = cleanCampaignValue(source)
+	if source == "" {
+		return ""
+	}
+	return source + "|" + cleanCampaignValue(medium) + "|" + cleanCampaignValue(campaign)
+}
+
+// cleanCampaignValue lowercases and trims a UTM value, drops the key
+// separator and control characters and truncates it to maxCampaignValue.
+func cleanCampaignValue(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if r == '|' || r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(v)))
+	if runes := []rune(v); len(runes) > maxCampaignValue {
+		v = string(runes[:maxCampaignValue])
+	}
+	return v
+}
+
+// deviceClass buckets a user agent into desktop, mobile or tablet, or
+// unknown when there is none. It's deliberately coarse: no browser, OS or
+// version is kept.
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return deviceUnknown
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return deviceTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"):
+		return deviceMobile
+	}
+	return deviceDesktop
+}
+
+// trackSources counts the request's landing and, for a visitor's first page
+// of the day, their device class.
+func (t *tracker) trackSources(r *http.Request, firstVisit bool) error {
+	l := landingFrom(r)
+	if !firstVisit && l.referrer == "" && l.campaign == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.prepare(); err != nil {
+		return err
+	}
+	day := t.day.sources
+	referrer := l.referrer
+	if referrer == "" {
+		referrer = directSource
+	}
+	countCapped(day.Referrers, referrer)
+	if l.campaign != "" {
+		countCapped(day.Campaigns, l.campaign)
+	}
+	if firstVisit {
+		day.Devices[deviceClass(r.UserAgent())]++
+	}
+	t.dirty = true
+	return nil
+}
+
+// countCapped increments counts[key], or counts[otherSource] once the map
+// holds maxSourceKeys keys.
+func countCapped(counts map[string]int, key string) {
+	if _, ok := counts[key]; !ok && len(counts) >= maxSourceKeys {
+		key = otherSource
+	}
+	counts[key]++
+}
+
+// sourcesHistoryRange is a source history range as read from disk, kept
+// until the history is next appended to.
+type sourcesHistoryRange struct {
+	from, to string
+	gen      int
+	days     map[string]*sourcesDay
+}
+
+// sourcesBetween sums the source counts over the dates from to to, inclusive.
+// As in pageCounts, only the in-memory day is read under t.mu; finished days
+// come from the cached source history.
+func (t *tracker) sourcesBetween(from, to string) (*sourcesResponse, error) {
+	t.mu.Lock()
+	if err := t.load(); err != nil {
+		t.mu.Unlock()
+		return nil, err
+	}
+	gen := t.sourcesHistoryGen
+	// The in-memory day is today or, if it hasn't been rolled over yet, the
+	// last day with visitors.
+	var memory *sourcesDay
+	if current := t.day.sources; current.Date >= from && current.Date <= to && !current.empty() {
+		memory = current.clone()
+	}
+	t.mu.Unlock()
+
+	history, err := t.cachedSourcesHistory(from, to, gen)
+	if err != nil {
+		return nil, err
+	}
+	days := maps.Clone(history)
+	if memory != nil {
+		days[memory.Date] = memory
+	}
+
+	total := newSourcesDay("")
+	for _, day := range days {
+		for k, n := range day.Referrers {
+			total.Referrers[k] += n
+		}
+		for k, n := range day.Campaigns {
+			total.Campaigns[k] += n
+		}
+		for k, n := range day.Devices {
+			total.Devices[k] += n
+		}
+		for k, n := range day.Bots {
+			total.Bots[k] += n
+		}
+	}
+	return total.response(), nil
+}
+
+func newSourcesDay(date string) *sourcesDay {
+	day := &sourcesDay{Date: date}
+	day.fill()
+	return day
+}
+
+// clone copies d, so it can be read after t.mu is released.
+func (d *sourcesDay) clone() *sourcesDay {
+	return &sourcesDay{Date: d.Date, sourceCounts: sourceCounts{
+		Referrers: maps.Clone(d.Referrers),
+		Campaigns: maps.Clone(d.Campaigns),
+		Devices:   maps.Clone(d.Devices),
+		Bots:      maps.Clone(d.Bots),
+	}}
+}
+
+func (d *sourcesDay) empty() bool {
+	return len(d.Referrers) == 0 && len(d.Campaigns) == 0 && len(d.Devices) == 0 && len(d.Bots) == 0
+}
+
+// response sorts the counts for the stats endpoint.
+func (d *sourcesDay) response() *sourcesResponse {
+	resp := &sourcesResponse{
+		Referrers: []referrerStats{},
+		Campaigns: []campaignStats{},
+		Devices:   d.Devices,
+		Bots:      sortedBots(d.Bots),
+	}
+	for host, n := range d.Referrers {
+		resp.Referrers = append(resp.Referrers, referrerStats{Host: host, Visits: n})
+	}
+	slices.SortFunc(resp.Referrers, func(a, b referrerStats) int {
+		if a.Visits != b.Visits {
+			return b.Visits - a.Visits
+		}
+		return strings.Compare(a.Host, b.Host)
+	})
+	for key, n := range d.Campaigns {
+		source, rest, _ := strings.Cut(key, "|")
+		medium, campaign, _ := strings.Cut(rest, "|")
+		resp.Campaigns = append(resp.Campaigns, campaignStats{Source: source, Medium: medium, Campaign: campaign, Visits: n})
+	}
+	slices.SortFunc(resp.Campaigns, func(a, b campaignStats) int {
+		if a.Visits != b.Visits {
+			return b.Visits - a.Visits
+		}
+		return strings.Compare(a.Source+"|"+a.Medium+"|"+a.Campaign, b.Source+"|"+b.Medium+"|"+b.Campaign)
+	})
+	return resp
+}
+
+func (t *tracker) readCurrentSources() (*sourcesDay, error) {
+	var day sourcesDay
+	if err := t.readJSON(sourcesStatsFile, &day); err != nil {
+		return nil, err
+	}
+	if day.Date == "" {
+		day.Date = currentDay()
+	}
+	day.fill()
+	return &day, nil
+}
+
+// fill replaces maps missing from a decoded day with empty ones.
+func (d *sourcesDay) fill() {
+	if d.Referrers == nil {
+		d.Referrers = map[string]int{}
+	}
+	if d.Campaigns == nil {
+		d.Campaigns = map[string]int{}
+	}
+	if d.Devices == nil {
+		d.Devices = map[string]int{}
+	}
+	if d.Bots == nil {
+		d.Bots = map[string]int{}
+	}
+}
+
+// cachedSourcesHistory returns readSourcesHistory(from, to), reusing the
+// last read while the history is still at generation gen. The returned map
+// and days must not be modified.
+func (t *tracker) cachedSourcesHistory(from, to string, gen int) (map[string]*sourcesDay, error) {
+	t.sourcesHistoryMu.Lock()
+	defer t.sourcesHistoryMu.Unlock()
+	if c := t.sourcesHistory; c != nil && c.from == from && c.to == to && c.gen == gen {
+		return c.days, nil
+	}
+	days, err := t.readSourcesHistory(from, to)
+	if err != nil {
+		return nil, err
+	}
+	t.sourcesHistory = &sourcesHistoryRange{from: from, to: to, gen: gen, days: days}
+	return days, nil
+}
+
+// appendSourcesHistory appends a finished day to the source history. t.mu
+// must be held.
+func (t *tracker) appendSourcesHistory(day *sourcesDay) error {
+	t.sourcesHistoryGen++
+	return t.appendLine(sourcesHistoryFile, day)
+}
+
+// readSourcesHistory returns the source history between from and to,
+// inclusive, by date. A torn last line is skipped.
+func (t *tracker) readSourcesHistory(from, to string) (map[string]*sourcesDay, error) {
+	path := filepath.Join(t.dir, sourcesHistoryFile)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]*sourcesDay{}, nil
+		}
+		return nil, fmt.Errorf("read sources history file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	days := make(map[string]*sourcesDay)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	for scanner.Scan() {
+		var day sourcesDay
+		if err := json.Unmarshal(scanner.Bytes(), &day); err != nil || day.Date < from || day.Date > to {
+			continue
+		}
+		day.fill()
+		days[day.Date] = &day
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read sources history file %s: %w", path, err)
+	}
+	return days, nil
+}
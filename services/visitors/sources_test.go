@@ -0,0 +1,146 @@
+package visitors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLandingFrom(t *testing.T) {
+	cases := []struct {
+		target, referer string
+		want            landing
+	}{
+		{"/", "", landing{}},
+		{"/", "https://www.Google.com/search?q=secret", landing{referrer: "google.com"}},
+		{"/", "https://example.com/notion/posts/x", landing{}}, // internal
+		{"/", "android-app://com.slack/", landing{}},
+		{"/?utm_source=Newsletter&utm_medium=email&utm_campaign=april|launch", "", landing{campaign: "newsletter|email|aprillaunch"}},
+		{"/?utm_medium=email", "", landing{}},
+	}
+	for _, tc := range cases {
+		req := browserRequest("https://example.com" + tc.target)
+		if tc.referer != "" {
+			req.Header.Set("Referer", tc.referer)
+		}
+		if got := landingFrom(req); got != tc.want {
+			t.Fatalf("%s from %q: got %+v, want %+v", tc.target, tc.referer, got, tc.want)
+		}
+	}
+}
+
+func TestDeviceClass(t *testing.T) {
+	for ua, want := range map[string]string{
+		"": deviceUnknown,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36":                         deviceDesktop,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1": deviceMobile,
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36":                   deviceMobile,
+		"Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36":                          deviceTablet,
+		"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1":          deviceTablet,
+	} {
+		if got := deviceClass(ua); got != want {
+			t.Fatalf("deviceClass(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
+
+func land(t *testing.T, handler http.Handler, visitorID, target, referer string, header http.Header) {
+	t.Helper()
+	req := browserRequest("https://example.com" + target)
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: visitorID})
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) Mobile/15E148")
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestMiddlewareAggregatesTrafficSources(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	handler := pageSite(tracker)
+
+	land(t, handler, "a", "/notion/posts/x", "https://news.ycombinator.com/item?id=1", nil)
+	land(t, handler, "a", "/reviews", "https://example.com/notion/posts/x", nil) // internal click
+	land(t, handler, "b", "/?utm_source=newsletter&utm_medium=email", "", nil)
+	land(t, handler, "c", "/", "", nil)
+	land(t, handler, "c", "/reviews", "", nil) // same visitor, no new landing
+	land(t, handler, "d", "/", "https://news.ycombinator.com/", http.Header{"Sec-Gpc": {"1"}})
+	land(t, handler, "e", "/missing", "https://lobste.rs/", nil)
+
+	setCurrentTime(t, time.Date(2026, 4, 25, 10, 0, 0, 0, time.Local))
+	land(t, handler, "a", "/", "https://news.ycombinator.com/", nil)
+
+	flushStats(t, tracker)
+	data, err := os.ReadFile(filepath.Join(dir, sourcesStatsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "item?id") || strings.Contains(string(data), hashVisitorID("a")) {
+		t.Fatalf("sources store holds more than aggregates: %s", data)
+	}
+
+	_, resp := getHistory(t, tracker, "sources&from=2026-04-24&to=2026-04-25")
+	want := &sourcesResponse{
+		Referrers: []referrerStats{{directSource, 2}, {"news.ycombinator.com", 2}},
+		Campaigns: []campaignStats{{Source: "newsletter", Medium: "email", Visits: 1}},
+		Devices:   map[string]int{deviceMobile: 4},
+		Bots:      []botStats{},
+	}
+	if !reflect.DeepEqual(resp.Sources, want) {
+		t.Fatalf("sources: got %+v, want %+v", resp.Sources, want)
+	}
+
+	_, resp = getHistory(t, tracker, "sources")
+	if got := resp.Sources.Referrers; len(got) != 1 || got[0] != (referrerStats{"news.ycombinator.com", 1}) {
+		t.Fatalf("today's referrers: %+v", got)
+	}
+}
+
+func TestSourcesReadHistoryOncePerRollover(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	handler := pageSite(tracker)
+	land(t, handler, "a", "/", "https://lobste.rs/", nil)
+	setCurrentTime(t, time.Date(2026, 4, 25, 10, 0, 0, 0, time.Local))
+	land(t, handler, "a", "/", "https://news.ycombinator.com/", nil)
+
+	sources, err := tracker.sourcesBetween("2026-04-24", "2026-04-25")
+	if err != nil || len(sources.Referrers) != 2 {
+		t.Fatalf("sources: %+v, %v", sources, err)
+	}
+
+	// Until the next rollover the history isn't read again, and today's
+	// landings still count.
+	if err := os.Remove(filepath.Join(dir, sourcesHistoryFile)); err != nil {
+		t.Fatal(err)
+	}
+	land(t, handler, "b", "/", "https://news.ycombinator.com/", nil)
+	sources, err = tracker.sourcesBetween("2026-04-24", "2026-04-25")
+	want := []referrerStats{{"news.ycombinator.com", 2}, {"lobste.rs", 1}}
+	if err != nil || !reflect.DeepEqual(sources.Referrers, want) {
+		t.Fatalf("cached sources: got %+v, want %+v (%v)", sources.Referrers, want, err)
+	}
+
+	setCurrentTime(t, time.Date(2026, 4, 26, 10, 0, 0, 0, time.Local))
+	land(t, handler, "c", "/", "", nil)
+	sources, err = tracker.sourcesBetween("2026-04-24", "2026-04-26")
+	want = []referrerStats{{"news.ycombinator.com", 2}, {directSource, 1}}
+	if err != nil || !reflect.DeepEqual(sources.Referrers, want) {
+		t.Fatalf("after rollover: got %+v, want %+v (%v)", sources.Referrers, want, err)
+	}
+}
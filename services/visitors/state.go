@@ -0,0 +1,252 @@
+package visitors
+
+import (
+	log "htmx-blog/logging"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// defaultFlushInterval is how often the in-memory day is written to disk,
+// and so how much of today's counts a crash can lose.
+const defaultFlushInterval = 10 * time.Second
+
+// dayState is the current day held in memory: visitor hashes as sets, so a
+// visit costs a map lookup whatever the day's traffic. It is loaded from the
+// snapshot files (current.json, pages.json, sources.json) on first use and
+// written back by flush.
+type dayState struct {
+	date     string
+	visitors map[string]struct{}
+	pages    map[string]*pageState
+	sources  *sourcesDay
+}
+
+// pageState is one page's counts for the current day.
+type pageState struct {
+	views    int
+	visitors map[string]struct{}
+}
+
+func newDayState(date string) *dayState {
+	return &dayState{
+		date:     date,
+		visitors: map[string]struct{}{},
+		pages:    map[string]*pageState{},
+		sources:  newSourcesDay(date),
+	}
+}
+
+// prepare loads the stored day on first use and rolls it over when the date
+// has changed. t.mu must be held.
+func (t *tracker) prepare() error {
+	if err := t.load(); err != nil {
+		return err
+	}
+	return t.rollover(currentDay())
+}
+
+// load reads the snapshot files into memory once. Page or source counts left
+// over from an older day than current.json's are finished days and go
+// straight to their history. t.mu must be held.
+func (t *tracker) load() error {
+	if t.day != nil {
+		return nil
+	}
+
+	current, err := t.readCurrent()
+	if err != nil {
+		return err
+	}
+	record, err := t.readRecord()
+	if err != nil {
+		return err
+	}
+	pages, err := t.readCurrentPages()
+	if err != nil {
+		return err
+	}
+	sources, err := t.readCurrentSources()
+	if err != nil {
+		return err
+	}
+
+	day := newDayState(current.Date)
+	for _, hash := range current.VisitorHashes {
+		day.visitors[hash] = struct{}{}
+	}
+	dirty := false
+	if pages.Date == day.date {
+		for p, counter := range pages.Pages {
+			state := &pageState{views: counter.Views, visitors: make(map[string]struct{}, len(counter.VisitorHashes))}
+			for _, hash := range counter.VisitorHashes {
+				state.visitors[hash] = struct{}{}
+			}
+			day.pages[p] = state
+		}
+	} else if len(pages.Pages) > 0 {
+		if err := t.appendPageHistory(pages.finished()); err != nil {
+			return err
+		}
+		dirty = true
+	}
+	if sources.Date == day.date {
+		day.sources = sources
+	} else if !sources.empty() {
+		if err := t.appendSourcesHistory(sources); err != nil {
+			return err
+		}
+		dirty = true
+	}
+
+	t.day, t.record, t.dirty = day, *record, dirty
+	return nil
+}
+
+// rollover appends the in-memory day to the history files and starts today
+// when the date has changed. The appends are synchronous so a finished day is
+// on disk before it's dropped from memory; if a crash comes before the next
+// flush, the stored day is rolled over again on restart and its history
+// lines are repeated, which readers resolve as last-line-wins. t.mu must be
+// held and t.day loaded.
+func (t *tracker) rollover(today string) error {
+	day := t.day
+	if day.date == today {
+		return nil
+	}
+	if len(day.visitors) > 0 {
+		if err := t.appendHistory(dayStats{Date: day.date, UniqueCount: len(day.visitors)}); err != nil {
+			return err
+		}
+	}
+	if len(day.pages) > 0 {
+		if err := t.appendPageHistory(day.currentPages().finished()); err != nil {
+			return err
+		}
+	}
+	if !day.sources.empty() {
+		if err := t.appendSourcesHistory(day.sources); err != nil {
+			return err
+		}
+	}
+	t.day = newDayState(today)
+	t.dirty = true
+	return nil
+}
+
+// currentPages is the day's page counts in their snapshot form.
+func (d *dayState) currentPages() *currentPages {
+	pages := &currentPages{Date: d.date, Pages: make(map[string]*pageCounter, len(d.pages))}
+	for p, state := range d.pages {
+		pages.Pages[p] = &pageCounter{Views: state.views, VisitorHashes: sortedKeys(state.visitors)}
+	}
+	return pages
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// flush writes the in-memory day and the record to their snapshot files if
+// anything changed since the last flush. Each file is replaced atomically, so
+// a crash leaves either the previous snapshot or the new one.
+func (t *tracker) flush() error {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	if t.day == nil || !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	current := &currentStats{
+		Date:          t.day.date,
+		UniqueCount:   len(t.day.visitors),
+		VisitorHashes: sortedKeys(t.day.visitors),
+	}
+	pages := t.day.currentPages()
+	sources := &sourcesDay{Date: t.day.sources.Date, sourceCounts: sourceCounts{
+		Referrers: maps.Clone(t.day.sources.Referrers),
+		Campaigns: maps.Clone(t.day.sources.Campaigns),
+		Devices:   maps.Clone(t.day.sources.Devices),
+		Bots:      maps.Clone(t.day.sources.Bots),
+	}}
+	record := t.record
+	t.dirty = false
+	t.mu.Unlock()
+
+	for _, file := range []struct {
+		name  string
+		value any
+	}{
+		{currentStatsFile, current},
+		{pagesStatsFile, pages},
+		{sourcesStatsFile, sources},
+		{recordStatsFile, record},
+	} {
+		if err := t.writeJSON(file.name, file.value); err != nil {
+			t.mu.Lock()
+			t.dirty = true
+			t.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// flushLoop flushes every interval until Close, rolling the day over first
+// so the history doesn't wait for the next visitor.
+func (t *tracker) flushLoop(interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			var err error
+			if t.day != nil {
+				err = t.rollover(currentDay())
+			}
+			t.mu.Unlock()
+			if err != nil {
+				trackingFailures.Inc("rollover")
+				log.Error("error rolling over visitor stats: %v", err)
+			}
+			if err := t.flush(); err != nil {
+				trackingFailures.Inc("flush")
+				log.Error("error flushing visitor stats: %v", err)
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flush and writes out anything still in memory.
+func (t *tracker) Close() error {
+	t.closeOnce.Do(func() { close(t.stop) })
+	<-t.done
+	return t.flush()
+}
+
+// flushIntervalFromEnv reads VISITOR_FLUSH_INTERVAL (a Go duration such as
+// "30s"), falling back to defaultFlushInterval.
+func flushIntervalFromEnv() time.Duration {
+	v := os.Getenv("VISITOR_FLUSH_INTERVAL")
+	if v == "" {
+		return defaultFlushInterval
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		log.Error("invalid VISITOR_FLUSH_INTERVAL %q, using %s", v, defaultFlushInterval)
+		return defaultFlushInterval
+	}
+	return interval
+}
@@ -0,0 +1,126 @@
+package visitors
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackerKeepsVisitorsAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	first := NewTracker(dir, Options{}).(*tracker)
+	view(t, pageSite(first), "a", "/reviews")
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewTracker(dir, Options{}).(*tracker)
+	defer second.Close()
+	handler := pageSite(second)
+	view(t, handler, "a", "/reviews")
+	view(t, handler, "b", "/reviews")
+
+	resp, err := second.readStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TodayUniqueVisitors != 2 || resp.HighestVisitorCount != 2 {
+		t.Fatalf("visitor a should be deduped across the restart: %+v", resp)
+	}
+	top, err := second.TopPages("/reviews", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PageStats{{Path: "/reviews", Views: 3, Visitors: 2}}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("page counts: got %+v, want %+v", top, want)
+	}
+}
+
+func TestTrackerRecoversFromCrashAfterRollover(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	crashed := NewTracker(dir, Options{}).(*tracker)
+	handler := pageSite(crashed)
+	view(t, handler, "a", "/")
+	view(t, handler, "b", "/")
+	flushStats(t, crashed)
+
+	// The next day's first visit rolls the 24th into the history, then the
+	// process dies before flushing: current.json still holds the 24th.
+	setCurrentTime(t, time.Date(2026, 4, 25, 10, 0, 0, 0, time.Local))
+	view(t, handler, "c", "/")
+
+	restarted := NewTracker(dir, Options{}).(*tracker)
+	defer restarted.Close()
+	view(t, pageSite(restarted), "d", "/")
+	flushStats(t, restarted)
+
+	history, err := restarted.readHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"2026-04-24": 2}; !reflect.DeepEqual(history, want) {
+		t.Fatalf("history: got %v, want %v", history, want)
+	}
+	if stats := readCurrentStats(t, dir); stats.Date != "2026-04-25" || stats.UniqueCount != 1 {
+		t.Fatalf("current day: %+v", stats)
+	}
+}
+
+func TestLoadRollsOverStalePageCounts(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 25, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+
+	// Files from before the in-memory tracker: page counts can be a day
+	// behind current.json.
+	writeJSONFile(t, dir, currentStatsFile, currentStats{Date: "2026-04-25", VisitorHashes: []string{"x"}})
+	writeJSONFile(t, dir, pagesStatsFile, currentPages{Date: "2026-04-24", Pages: map[string]*pageCounter{
+		"/": {Views: 4, VisitorHashes: []string{"x", "y"}},
+	}})
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	defer tracker.Close()
+	top, err := tracker.TopPages("/", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PageStats{{Path: "/", Views: 4, Visitors: 2}}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("stale page counts: got %+v, want %+v", top, want)
+	}
+	if top, _ := tracker.TopPages("/", 1, 0); len(top) != 0 {
+		t.Fatalf("stale page counts counted as today: %+v", top)
+	}
+}
+
+func TestFlushLoopWritesSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
+	defer restoreTime()
+	t.Setenv("VISITOR_FLUSH_INTERVAL", "10ms")
+
+	tracker := NewTracker(dir, Options{}).(*tracker)
+	defer tracker.Close()
+	view(t, pageSite(tracker), "a", "/")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, currentStatsFile)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("current.json not flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := readCurrentStats(t, dir); stats.UniqueCount != 1 {
+		t.Fatalf("flushed stats: %+v", stats)
+	}
+}
@@ -6,11 +6,13 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"htmx-blog/internal/httpstatus"
 	log "htmx-blog/logging"
+	"htmx-blog/metrics"
+	"maps"
 	"net/http"
 	"os"
 	"path/filepath"
-	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +27,11 @@ const (
 	recordStatsFile   = "record.json"
 )
 
+// trackingFailures counts errors recording visits, by stage: visit,
+// page_view, sources, bot, rollover or flush. Each is also logged.
+var trackingFailures = metrics.NewCounter("blog_visitor_tracking_failures_total",
+	"Visitor tracking errors by stage.", "stage")
+
 var currentTime = func() time.Time {
 	return time.Now()
 }
@@ -33,15 +40,58 @@ var currentTime = func() time.Time {
 type Tracker interface {
 	// Middleware wraps the public HTTP handler and records qualifying visits.
 	Middleware(next http.Handler) http.Handler
-	// StatsHandler returns an internal-only handler that reports current stats.
+	// StatsHandler returns an internal-only handler that reports current
+	// stats and, on request, the visitor history, the most viewed pages and
+	// traffic sources.
 	StatsHandler() http.HandlerFunc
+	// DashboardHandler returns an internal-only HTML dashboard of the same
+	// stats.
+	DashboardHandler() http.HandlerFunc
+	PageCounter
+	// Close stops the periodic flush and writes the current day to disk.
+	Close() error
 }
 
 type tracker struct {
 	dir        string
+	mode       Mode
+	trustProxy bool
 	cookieName string
 	secure     bool
-	mu         sync.Mutex
+	bots       *botClassifier
+
+	// saltMu guards the cookieless salt, read before mu is taken.
+	saltMu sync.Mutex
+	salt   dailySalt
+
+	// mu guards the in-memory day and record; see state.go.
+	mu     sync.Mutex
+	day    *dayState
+	record recordStats
+	dirty  bool
+	// pageHistoryGen, sourcesHistoryGen and historyGen count appends to
+	// the page, source and visitor histories, so cached reads of them know
+	// when they're out of date. Guarded by mu.
+	pageHistoryGen    int
+	sourcesHistoryGen int
+	historyGen        int
+
+	// pageHistoryMu guards pageHistory, the last page history range read.
+	pageHistoryMu sync.Mutex
+	pageHistory   *pageHistoryRange
+	// sourcesHistoryMu guards sourcesHistory, the last source history
+	// range read.
+	sourcesHistoryMu sync.Mutex
+	sourcesHistory   *sourcesHistoryRange
+	// historyMu guards history, the visitor history as last read.
+	historyMu sync.Mutex
+	history   *visitorHistory
+
+	// flushMu orders flushes so an older snapshot never overwrites a newer.
+	flushMu   sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 type currentStats struct {
@@ -60,46 +110,148 @@ type statsResponse struct {
 	TodayUniqueVisitors int    `json:"today_unique_visitors"`
 	HighestVisitorDate  string `json:"highest_visitor_date"`
 	HighestVisitorCount int    `json:"highest_visitor_count"`
+	// History is set when the request asks for a range.
+	History *historyResponse `json:"history,omitempty"`
+	// TopPages is set for ?top=, over the requested range or else today.
+	TopPages []PageStats `json:"top_pages,omitempty"`
+	// Sources is set for ?sources, over the same period as TopPages.
+	Sources *sourcesResponse `json:"sources,omitempty"`
 }
 
 // NewTracker creates a file-backed visitor tracker. If dir is empty it uses
-// VISITOR_STATS_DIR when set, otherwise ./stats/visitors.
-func NewTracker(dir string) Tracker {
+// VISITOR_STATS_DIR when set, otherwise ./stats/visitors. The current day is
+// kept in memory and flushed every VISITOR_FLUSH_INTERVAL (default 10s) until
+// Close. opts.Mode picks cookie or cookieless counting; an unknown mode is
+// logged and treated as cookieless, so a typo never turns the cookie on.
+func NewTracker(dir string, opts Options) Tracker {
 	if dir == "" {
 		dir = os.Getenv("VISITOR_STATS_DIR")
 	}
 	if dir == "" {
 		dir = defaultStatsDir
 	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = Mode(os.Getenv("VISITOR_TRACKING_MODE"))
+	}
+	switch mode {
+	case "":
+		mode = ModeCookie
+	case ModeCookie, ModeCookieless:
+	default:
+		log.Error("unknown visitor tracking mode %q, counting cookieless", mode)
+		mode = ModeCookieless
+	}
 
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		log.Error("error creating visitor stats directory %s: %v", dir, err)
 	}
 
-	return &tracker{
+	botPatterns := opts.BotPatterns
+	if botPatterns == "" {
+		botPatterns = os.Getenv("VISITOR_BOT_PATTERNS")
+	}
+	bots, err := newBotClassifier(botPatterns)
+	if err != nil {
+		log.Error("error loading bot patterns, using the built-in list: %v", err)
+		bots, _ = newBotClassifier("")
+	}
+
+	t := &tracker{
 		dir:        dir,
+		mode:       mode,
+		trustProxy: opts.TrustProxy || os.Getenv("VISITOR_TRUST_PROXY") == "true",
 		cookieName: defaultCookieName,
 		secure:     os.Getenv("PROD") == "true",
+		bots:       bots,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
 	}
+	go t.flushLoop(flushIntervalFromEnv())
+	return t
 }
 
 // Middleware wraps the public site handler and records qualifying top-level
 // HTML visits without interrupting the main request flow on tracking failures.
+// A page view, and where the visitor came from, is counted once the handler
+// has answered with a 2xx, so not-found paths don't pile up in the counts.
 func (t *tracker) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if t.shouldTrack(r) {
-			if err := t.trackVisit(w, r); err != nil {
-				log.Error("error tracking visitor for %s: %v", r.URL.Path, err)
+		if !t.shouldTrack(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if bot := t.bots.classify(r); bot != "" {
+			if err := t.trackBot(bot); err != nil {
+				trackingFailures.Inc("bot")
+				log.Error("error tracking bot request for %s: %v", r.URL.Path, err)
 			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		hash, firstVisit, err := t.trackVisit(w, r)
+		if err != nil {
+			trackingFailures.Inc("visit")
+			log.Error("error tracking visitor for %s: %v", r.URL.Path, err)
+		}
+
+		rec := httpstatus.NewRecorder(w)
+		next.ServeHTTP(rec, r)
+		if hash == "" || rec.Status() < 200 || rec.Status() > 299 {
+			return
+		}
+		if err := t.trackPageView(pagePath(r.URL.Path), hash); err != nil {
+			trackingFailures.Inc("page_view")
+			log.Error("error tracking page view for %s: %v", r.URL.Path, err)
+		}
+		if err := t.trackSources(r, firstVisit); err != nil {
+			trackingFailures.Inc("sources")
+			log.Error("error tracking traffic source for %s: %v", r.URL.Path, err)
 		}
-		next.ServeHTTP(w, r)
 	})
 }
 
 // StatsHandler serves the current day's count and the all-time highest day.
+// With any of from, to (YYYY-MM-DD, inclusive) or interval (daily, weekly,
+// monthly) it also serves that range of the history, today included; from
+// defaults to 30 days before to, and to to today. ?top=N (default 10) adds
+// the N most viewed pages over that range, or today without one, optionally
+// limited to paths starting with ?prefix=. ?sources adds referrer hosts, UTM
+// campaigns and device classes over the same period.
 func (t *tracker) StatsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		today, _ := time.ParseInLocation(dateLayout, currentDay(), time.Local)
+		query, ranged, err := parseHistoryQuery(r.URL.Query(), today)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, prefix, top, err := parseTopPages(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		resp, err := t.readStats()
+		if err == nil && ranged {
+			var daily map[string]int
+			if daily, err = t.dailyCounts(); err == nil {
+				resp.History = query.aggregate(daily)
+			}
+		}
+		from, to := today.Format(dateLayout), today.Format(dateLayout)
+		if ranged {
+			from, to = query.from.Format(dateLayout), query.to.Format(dateLayout)
+		}
+		if err == nil && top {
+			var counts map[string]*PageStats
+			if counts, err = t.pageCounts(from, to); err == nil {
+				resp.TopPages = topPages(counts, prefix, limit)
+			}
+		}
+		if err == nil && r.URL.Query().Has("sources") {
+			resp.Sources, err = t.sourcesBetween(from, to)
+		}
 		if err != nil {
 			log.Error("error reading visitor stats: %v", err)
 			http.Error(w, "error reading visitor stats", http.StatusInternalServerError)
@@ -135,59 +287,42 @@ func (t *tracker) shouldTrack(r *http.Request) bool {
 		return false
 	}
 
-	return !isBot(r.UserAgent())
+	return true
 }
 
-func (t *tracker) trackVisit(w http.ResponseWriter, r *http.Request) error {
+// trackVisit counts the visitor towards today's uniques and returns their
+// hash for the page view, and whether this is their first visit today.
+func (t *tracker) trackVisit(w http.ResponseWriter, r *http.Request) (hash string, firstVisit bool, err error) {
 	visitorID, err := t.visitorID(w, r)
 	if err != nil {
-		return err
+		return "", false, err
 	}
+	hash = hashVisitorID(visitorID)
 
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	today := currentDay()
-	hash := hashVisitorID(visitorID)
-
-	stats, err := t.readCurrent()
-	if err != nil {
-		return err
-	}
-	if stats.Date != today {
-		stats = &currentStats{
-			Date:          today,
-			UniqueCount:   0,
-			VisitorHashes: []string{},
-		}
-	}
-	if slices.Contains(stats.VisitorHashes, hash) {
-		return nil
+	if err := t.prepare(); err != nil {
+		return "", false, err
 	}
-
-	stats.VisitorHashes = append(stats.VisitorHashes, hash)
-	slices.Sort(stats.VisitorHashes)
-	stats.UniqueCount = len(stats.VisitorHashes)
-	if err := t.writeJSON(currentStatsFile, stats); err != nil {
-		return err
-	}
-
-	record, err := t.readRecord()
-	if err != nil {
-		return err
+	if _, seen := t.day.visitors[hash]; seen {
+		return hash, false, nil
 	}
-	if stats.UniqueCount > record.UniqueCount {
-		record.Date = stats.Date
-		record.UniqueCount = stats.UniqueCount
-		if err := t.writeJSON(recordStatsFile, record); err != nil {
-			return err
-		}
+	t.day.visitors[hash] = struct{}{}
+	t.dirty = true
+	if count := len(t.day.visitors); count > t.record.UniqueCount {
+		t.record = recordStats{Date: t.day.date, UniqueCount: count}
 	}
-
-	return nil
+	return hash, true, nil
 }
 
+// visitorID identifies the visitor: by their cookie, set here on a first
+// visit, or in cookieless mode by today's salted hash.
 func (t *tracker) visitorID(w http.ResponseWriter, r *http.Request) (string, error) {
+	if t.mode == ModeCookieless {
+		return t.cookielessID(r)
+	}
+
 	cookie, err := r.Cookie(t.cookieName)
 	if err == nil && cookie.Value != "" {
 		return cookie.Value, nil
@@ -216,30 +351,43 @@ func (t *tracker) readStats() (*statsResponse, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	today := currentDay()
-	stats, err := t.readCurrent()
-	if err != nil {
+	if err := t.load(); err != nil {
 		return nil, err
 	}
-	if stats.Date != today {
-		stats = &currentStats{
-			Date:          today,
-			UniqueCount:   0,
-			VisitorHashes: []string{},
-		}
+	resp := &statsResponse{
+		TodayDate:           currentDay(),
+		HighestVisitorDate:  t.record.Date,
+		HighestVisitorCount: t.record.UniqueCount,
 	}
+	if t.day.date == resp.TodayDate {
+		resp.TodayUniqueVisitors = len(t.day.visitors)
+	}
+	return resp, nil
+}
 
-	record, err := t.readRecord()
-	if err != nil {
+// dailyCounts returns unique visitors by date: the history plus the
+// in-memory day, which is today or, if it hasn't been rolled over yet, the
+// last day with visitors. As in pageCounts, only the in-memory day is read
+// under t.mu.
+func (t *tracker) dailyCounts() (map[string]int, error) {
+	t.mu.Lock()
+	if err := t.load(); err != nil {
+		t.mu.Unlock()
 		return nil, err
 	}
+	gen := t.historyGen
+	date, count := t.day.date, len(t.day.visitors)
+	t.mu.Unlock()
 
-	return &statsResponse{
-		TodayDate:           stats.Date,
-		TodayUniqueVisitors: stats.UniqueCount,
-		HighestVisitorDate:  record.Date,
-		HighestVisitorCount: record.UniqueCount,
-	}, nil
+	history, err := t.cachedHistory(gen)
+	if err != nil {
+		return nil, err
+	}
+	daily := maps.Clone(history)
+	if count > 0 {
+		daily[date] = count
+	}
+	return daily, nil
 }
 
 func (t *tracker) readCurrent() (*currentStats, error) {
@@ -301,6 +449,12 @@ func (t *tracker) writeJSON(name string, value any) error {
 		_ = os.Remove(tmpName)
 		return fmt.Errorf("write temp stats file %s: %w", name, err)
 	}
+	// Sync before the rename, so it never points at unwritten data.
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("sync temp stats file %s: %w", name, err)
+	}
 	if err := tmpFile.Close(); err != nil {
 		_ = os.Remove(tmpName)
 		return fmt.Errorf("close temp stats file %s: %w", name, err)
@@ -329,22 +483,3 @@ func hashVisitorID(id string) string {
 	sum := sha256.Sum256([]byte(id))
 	return hex.EncodeToString(sum[:])
 }
-
-func isBot(userAgent string) bool {
-	ua := strings.ToLower(userAgent)
-	for _, needle := range []string{
-		"bot",
-		"crawler",
-		"spider",
-		"slurp",
-		"bingpreview",
-		"facebookexternalhit",
-		"discordbot",
-		"googleother",
-	} {
-		if strings.Contains(ua, needle) {
-			return true
-		}
-	}
-	return false
-}
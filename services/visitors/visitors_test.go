@@ -16,12 +16,12 @@ func TestMiddlewareCreatesCookieAndReusesExistingVisitor(t *testing.T) {
 	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
 	defer restoreTime()
 
-	tracker := NewTracker(dir).(*tracker)
+	tracker := NewTracker(dir, Options{}).(*tracker)
 	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 	}))
 
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req := browserRequest("/")
 	rec := httptest.NewRecorder()
 	handler.ServeHTTP(rec, req)
 
@@ -50,6 +50,7 @@ func TestMiddlewareCreatesCookieAndReusesExistingVisitor(t *testing.T) {
 		t.Fatal("expected cookie to be insecure outside PROD")
 	}
 
+	flushStats(t, tracker)
 	stats := readCurrentStats(t, dir)
 	if stats.UniqueCount != 1 {
 		t.Fatalf("expected 1 unique visitor, got %d", stats.UniqueCount)
@@ -59,7 +60,7 @@ func TestMiddlewareCreatesCookieAndReusesExistingVisitor(t *testing.T) {
 		t.Fatalf("unexpected record %+v", record)
 	}
 
-	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req = browserRequest("/")
 	req.AddCookie(cookie)
 	rec = httptest.NewRecorder()
 	handler.ServeHTTP(rec, req)
@@ -68,6 +69,7 @@ func TestMiddlewareCreatesCookieAndReusesExistingVisitor(t *testing.T) {
 		t.Fatal("expected no new cookie when visitor cookie already exists")
 	}
 
+	flushStats(t, tracker)
 	stats = readCurrentStats(t, dir)
 	if stats.UniqueCount != 1 {
 		t.Fatalf("expected visitor to be deduped, got %d", stats.UniqueCount)
@@ -79,10 +81,10 @@ func TestMiddlewareResetsCurrentDayButKeepsRecord(t *testing.T) {
 	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
 	defer restoreTime()
 
-	tracker := NewTracker(dir).(*tracker)
+	tracker := NewTracker(dir, Options{}).(*tracker)
 	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 
-	firstReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	firstReq := browserRequest("/")
 	firstRec := httptest.NewRecorder()
 	handler.ServeHTTP(firstRec, firstReq)
 	cookie := firstRec.Result().Cookies()[0]
@@ -91,11 +93,12 @@ func TestMiddlewareResetsCurrentDayButKeepsRecord(t *testing.T) {
 		return time.Date(2026, 4, 25, 9, 0, 0, 0, time.Local)
 	}
 
-	secondReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	secondReq := browserRequest("/")
 	secondReq.AddCookie(cookie)
 	secondRec := httptest.NewRecorder()
 	handler.ServeHTTP(secondRec, secondReq)
 
+	flushStats(t, tracker)
 	stats := readCurrentStats(t, dir)
 	if stats.Date != "2026-04-25" {
 		t.Fatalf("expected current stats to reset to 2026-04-25, got %s", stats.Date)
@@ -115,7 +118,7 @@ func TestMiddlewareSkipsExcludedRequests(t *testing.T) {
 	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
 	defer restoreTime()
 
-	tracker := NewTracker(dir).(*tracker)
+	tracker := NewTracker(dir, Options{}).(*tracker)
 	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 
 	tests := []struct {
@@ -163,7 +166,7 @@ func TestMiddlewareSkipsExcludedRequests(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req := browserRequest(tt.target)
 			for key, value := range tt.headers {
 				req.Header.Set(key, value)
 			}
@@ -191,7 +194,7 @@ func TestMiddlewareWritesConcurrentUniqueVisitorsSafely(t *testing.T) {
 	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
 	defer restoreTime()
 
-	tracker := NewTracker(dir).(*tracker)
+	tracker := NewTracker(dir, Options{}).(*tracker)
 	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 
 	var wg sync.WaitGroup
@@ -200,7 +203,7 @@ func TestMiddlewareWritesConcurrentUniqueVisitorsSafely(t *testing.T) {
 		go func(i int) {
 			defer wg.Done()
 
-			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req := browserRequest("/")
 			req.AddCookie(&http.Cookie{
 				Name:  defaultCookieName,
 				Value: "visitor-" + time.Date(2026, 4, 24, 10, 0, i, 0, time.Local).Format(time.RFC3339Nano),
@@ -211,6 +214,7 @@ func TestMiddlewareWritesConcurrentUniqueVisitorsSafely(t *testing.T) {
 	}
 	wg.Wait()
 
+	flushStats(t, tracker)
 	stats := readCurrentStats(t, dir)
 	if stats.UniqueCount != 25 {
 		t.Fatalf("expected 25 unique visitors, got %d", stats.UniqueCount)
@@ -230,7 +234,7 @@ func TestStatsHandlerReturnsTodayAndHighestDay(t *testing.T) {
 	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
 	defer restoreTime()
 
-	tracker := NewTracker(dir).(*tracker)
+	tracker := NewTracker(dir, Options{}).(*tracker)
 	writeJSONFile(t, dir, currentStatsFile, currentStats{
 		Date:          "2026-04-24",
 		UniqueCount:   2,
@@ -266,7 +270,7 @@ func TestStatsHandlerResetsStaleCurrentDayInResponse(t *testing.T) {
 	restoreTime := setCurrentTime(t, time.Date(2026, 4, 24, 10, 0, 0, 0, time.Local))
 	defer restoreTime()
 
-	tracker := NewTracker(dir).(*tracker)
+	tracker := NewTracker(dir, Options{}).(*tracker)
 	writeJSONFile(t, dir, currentStatsFile, currentStats{
 		Date:          "2026-04-23",
 		UniqueCount:   3,
@@ -286,6 +290,15 @@ func TestStatsHandlerResetsStaleCurrentDayInResponse(t *testing.T) {
 	}
 }
 
+// browserRequest is a GET for target with the headers every browser sends,
+// so the bot classifier lets it through.
+func browserRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("User-Agent", firefoxUA)
+	req.Header.Set("Accept-Language", "en-GB,en;q=0.5")
+	return req
+}
+
 func setCurrentTime(t *testing.T, now time.Time) func() {
 	t.Helper()
 	original := currentTime
@@ -297,6 +310,15 @@ func setCurrentTime(t *testing.T, now time.Time) func() {
 	}
 }
 
+// flushStats writes the tracker's in-memory day to disk, as the periodic
+// flush would.
+func flushStats(t *testing.T, tracker *tracker) {
+	t.Helper()
+	if err := tracker.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+}
+
 func readCurrentStats(t *testing.T, dir string) currentStats {
 	t.Helper()
 
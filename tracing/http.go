@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"htmx-blog/internal/httpstatus"
+	log "htmx-blog/logging"
+)
+
+// Middleware starts a server span for every request, named after the route
+// pattern routes matches it to (e.g. "GET /notion/posts/{slug}"), and puts
+// it in the request context for handlers to hang their own spans from. A
+// W3C traceparent header on the request makes the span part of the
+// caller's trace. Inside logging.Middleware, the span is tagged with the
+// request ID so a trace can be matched to its log lines.
+func Middleware(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := routes.Handler(r)
+		name := pattern
+		if name == "" {
+			name = r.Method
+		} else if !strings.Contains(pattern, " ") {
+			name = r.Method + " " + pattern
+		}
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := Tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("http.route", pattern),
+			),
+		)
+		defer span.End()
+		if !span.IsRecording() {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if id := log.RequestID(ctx); id != "" {
+			span.SetAttributes(attribute.String("request.id", id))
+		}
+
+		rec := httpstatus.NewRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.Status()))
+		if rec.Status() >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
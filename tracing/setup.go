@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	log "htmx-blog/logging"
+)
+
+const defaultServiceName = "htmx-blog"
+
+// SetupFromEnv turns tracing on when OTEL_EXPORTER_OTLP_ENDPOINT (the
+// collector's base URL) or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (the full
+// traces URL) is set, batching spans to it over OTLP/HTTP. The exporter also
+// reads OTEL_EXPORTER_OTLP_HEADERS, and OTEL_SERVICE_NAME overrides the
+// service name. Call shutdown before exiting to flush what is queued.
+func SetupFromEnv() (shutdown func(context.Context) error, err error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "OTLP exporter")
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", defaultServiceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "tracing resource")
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Error("tracing: %v", err)
+	}))
+	return tp.Shutdown, nil
+}
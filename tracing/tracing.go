@@ -0,0 +1,41 @@
+// Package tracing sets up OpenTelemetry for the server and holds the small
+// helpers the rest of the code records spans with, so a slow request can be
+// broken down into cache, Notion and rendering time.
+//
+// Until SetupFromEnv installs a tracer provider, otel's global provider is a
+// no-op and so are the spans Start returns.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the tracer name spans are recorded under.
+const instrumentationName = "htmx-blog"
+
+// Tracer is the server's tracer from the global provider, for spans that
+// need options Start doesn't take, such as a client span kind.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start begins a span named name as a child of the span in ctx, or as a new
+// trace. End it when the operation finishes.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RecordError adds err to span as an exception event and marks the span
+// failed. A nil err is ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
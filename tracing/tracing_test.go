@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	log "htmx-blog/logging"
+)
+
+// record installs a tracer provider exporting to memory for the rest of the
+// test.
+func record(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		tp.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func byName(exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func TestSpansNestAndRecordErrors(t *testing.T) {
+	exporter := record(t)
+
+	ctx, parent := Start(context.Background(), "parent", attribute.String("slug", "hello"))
+	_, child := Start(ctx, "child")
+	RecordError(child, errors.New("notion said no"))
+	RecordError(child, nil)
+	child.End()
+	parent.End()
+
+	spans := byName(exporter)
+	p, c := spans["parent"], spans["child"]
+	if c.Parent.SpanID() != p.SpanContext.SpanID() || c.SpanContext.TraceID() != p.SpanContext.TraceID() {
+		t.Fatalf("child not linked to parent: %+v / %+v", c, p)
+	}
+	if c.Status.Code != codes.Error || c.Status.Description != "notion said no" || len(c.Events) != 1 || c.Events[0].Name != "exception" {
+		t.Fatalf("error not recorded: %+v %+v", c.Status, c.Events)
+	}
+	if len(p.Attributes) != 1 || p.Attributes[0] != attribute.String("slug", "hello") {
+		t.Fatalf("attributes: %+v", p.Attributes)
+	}
+}
+
+func TestMiddlewareStartsServerSpan(t *testing.T) {
+	exporter := record(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /posts/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "handler")
+		defer span.End()
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/posts/hello", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req = req.WithContext(log.WithRequestID(req.Context(), "req-1"))
+	Middleware(mux, mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := byName(exporter)
+	server, ok := spans["GET /posts/{slug}"]
+	if !ok {
+		t.Fatalf("no server span in %+v", spans)
+	}
+	if server.SpanKind != trace.SpanKindServer || server.Status.Code != codes.Error {
+		t.Fatalf("server span: kind %v, status %+v", server.SpanKind, server.Status)
+	}
+	if server.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("remote parent ignored: %s / %s", server.SpanContext.TraceID(), server.Parent.SpanID())
+	}
+	want := map[attribute.Key]attribute.Value{
+		"http.route":                attribute.StringValue("GET /posts/{slug}"),
+		"request.id":                attribute.StringValue("req-1"),
+		"http.response.status_code": attribute.IntValue(http.StatusBadGateway),
+	}
+	for _, kv := range server.Attributes {
+		if v, ok := want[kv.Key]; ok && v == kv.Value {
+			delete(want, kv.Key)
+		}
+	}
+	if len(want) > 0 {
+		t.Fatalf("missing attributes %v in %+v", want, server.Attributes)
+	}
+	if spans["handler"].Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Fatal("handler span not a child of the server span")
+	}
+}
+
+func TestSetupFromEnvWithoutEndpointIsNoop(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	prev := otel.GetTracerProvider()
+	shutdown, err := SetupFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otel.GetTracerProvider() != prev {
+		t.Fatal("provider installed without an endpoint")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"html/template"
 	log "htmx-blog/logging"
+	"io"
 	"net/http"
 
 	"github.com/pkg/errors"
@@ -13,7 +14,7 @@ func Render(w http.ResponseWriter, data map[string]interface{}, paths ...string)
 
 	allPaths := append([]string{layoutPath}, paths...)
 
-	tmpl, err := template.ParseFiles(allPaths...)
+	tmpl, err := template.New("main").Funcs(templateFuncs).ParseFiles(allPaths...)
 	if err != nil {
 		log.Error("failed to parse template files: %v", err)
 		http.Error(w, errors.Wrap(err, "failed to render html page").Error(), http.StatusInternalServerError)
@@ -26,3 +27,16 @@ func Render(w http.ResponseWriter, data map[string]interface{}, paths ...string)
 		return
 	}
 }
+
+// RenderPartial executes the named template from paths without the page
+// layout, for htmx fragments appended to an already-written response.
+func RenderPartial(w io.Writer, name string, data any, paths ...string) error {
+	tmpl, err := template.New(name).Funcs(templateFuncs).ParseFiles(paths...)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse partial")
+	}
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
+		return errors.Wrap(err, "failed to execute partial")
+	}
+	return nil
+}
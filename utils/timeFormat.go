@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"html/template"
+	log "htmx-blog/logging"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	dateLayout     = "January 2, 2006"
+	dateTimeLayout = "January 2, 2006 at 15:04"
+)
+
+var (
+	siteLocationOnce sync.Once
+	siteLocation     *time.Location
+)
+
+// SiteLocation is the timezone dates are displayed and grouped in, read once
+// from SITE_TIMEZONE (an IANA name such as "Asia/Singapore"). Unset or unknown
+// values fall back to UTC.
+func SiteLocation() *time.Location {
+	siteLocationOnce.Do(func() {
+		siteLocation = time.UTC
+		name := os.Getenv("SITE_TIMEZONE")
+		if name == "" {
+			return
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			log.Error("invalid SITE_TIMEZONE %q, using UTC: %v", name, err)
+			return
+		}
+		siteLocation = loc
+	})
+	return siteLocation
+}
+
+// templateFuncs are available to every template rendered through Render and
+// RenderPartial. Server-side output is in the site timezone; elements marked
+// data-localize are reformatted in the reader's locale by the layout script.
+var templateFuncs = template.FuncMap{
+	"formatDate":     formatDate,
+	"formatDateTime": formatDateTime,
+	"isoTime":        isoTime,
+	"siteTimezone":   func() string { return SiteLocation().String() },
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(SiteLocation()).Format(dateLayout)
+}
+
+func formatDateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(SiteLocation()).Format(dateTimeLayout)
+}
+
+// isoTime formats t for a <time datetime> attribute.
+func isoTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(SiteLocation()).Format(time.RFC3339)
+}